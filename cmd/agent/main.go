@@ -3,9 +3,29 @@ package main
 import (
 	"calculator/internal/agent"
 	"calculator/pkg/loggers"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
 )
 
 func main() {
-	loggers.InitLogger("agent", "agent_logs.txt")
-	agent.Run()
+	os.Exit(mainWithExitCode())
+}
+
+func mainWithExitCode() int {
+	cfg, err := agent.LoadConfig(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	level, _ := loggers.ParseLevel(cfg.LogLevel)
+	loggers.SetLevel(level)
+	loggers.InitLogger("agent", cfg.LogFile)
+	defer loggers.CloseAllLoggers()
+	agent.Run(cfg)
+	return 1
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"calculator/internal/config"
+	"calculator/internal/database"
+	"calculator/pkg/loggers"
+	"fmt"
+	"io"
+	"os"
+)
+
+// adminCommand выполняет "orchestrator admin grant|revoke <login>": выдаёт или
+// отбирает права администратора у уже зарегистрированного пользователя. База
+// берётся из той же конфигурации, что и у сервера.
+func adminCommand(args []string) int {
+	if len(args) != 2 || (args[0] != "grant" && args[0] != "revoke") {
+		fmt.Fprintln(os.Stderr, "usage: orchestrator admin grant|revoke <login>")
+		return 2
+	}
+	cfg, err := config.Load(nil, io.Discard)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	loggers.InitLogger("general", cfg.Logs.General)
+	defer loggers.CloseAllLoggers()
+	database.Init(cfg.Database)
+	admin := args[0] == "grant"
+	if err := database.SetAdmin(args[1], admin); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[1], err)
+		return 1
+	}
+	fmt.Printf("%s: admin=%t\n", args[1], admin)
+	return 0
+}
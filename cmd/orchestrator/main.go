@@ -2,9 +2,12 @@ package main
 
 import (
 	"calculator/internal/application"
+	"calculator/internal/config"
 	"calculator/internal/database"
 	"calculator/pkg/loggers"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 
@@ -21,11 +24,24 @@ func mainWithExitCode(ctx context.Context) int {
 	if err != nil {
 		fmt.Println("Warning: .env file not found, falling back to system environment variables")
 	}
-	loggers.InitLogger("server", "server_logs.txt")
-	loggers.InitLogger("orchestrator", "calculations_logs.txt")
-	loggers.InitLogger("general", "general_logs.txt")
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		return adminCommand(os.Args[2:])
+	}
+	cfg, err := config.Load(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	level, _ := loggers.ParseLevel(cfg.LogLevel)
+	loggers.SetLevel(level)
+	loggers.InitLogger("server", cfg.Logs.Server)
+	loggers.InitLogger("orchestrator", cfg.Logs.Orchestrator)
+	loggers.InitLogger("general", cfg.Logs.General)
 	defer loggers.CloseAllLoggers()
-	database.Init()
-	app := application.New()
+	database.Init(cfg.Database)
+	app := application.New(cfg)
 	return app.Run(ctx)
 }
@@ -4,83 +4,369 @@ import (
 	"calculator/internal/task/taskpb"
 	"calculator/pkg/loggers"
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"os"
-	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/metadata"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
-func Run() {
+// Version сообщается оркестратору при регистрации; задаётся при сборке через -ldflags "-X".
+var Version = "dev"
+
+// agentIDKey — ключ метаданных, по которому оркестратор узнаёт агента в потоке Work.
+const agentIDKey = "agent-id"
+
+// agentSessionKey — ключ метаданных со случайным ключом процесса: по нему
+// оркестратор отличает агента от другого клиента с тем же ID.
+const agentSessionKey = "agent-session"
+
+const defaultHeartbeatInterval = 2 * time.Second
+
+type identity struct {
+	id             string
+	name           string
+	labels         map[string]string
+	hostname       string
+	computingPower int32
+	batchSize      int32
+	operations     []string
+}
+
+// agentID возвращает ID из конфигурации, а если его нет — из файла IDFile; при
+// первом запуске ID создаётся и сохраняется в файл, чтобы состояние агента на
+// оркестраторе (drain, disable) пережило перезапуск.
+func agentID(cfg Config) (string, error) {
+	if cfg.ID != "" {
+		return cfg.ID, nil
+	}
+	data, err := os.ReadFile(cfg.IDFile)
+	if err == nil && strings.TrimSpace(string(data)) != "" {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("read agent ID: %w", err)
+	}
+	id := uuid.New().String()
+	if err := os.WriteFile(cfg.IDFile, []byte(id+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("save agent ID: %w", err)
+	}
+	return id, nil
+}
+
+// supportedOperations — операции, которые умеет calc.
+var supportedOperations = []string{"+", "-", "*", "/"}
+
+func (i identity) info(load int32) *taskpb.AgentInfo {
+	return &taskpb.AgentInfo{
+		AgentId:        i.id,
+		Name:           i.name,
+		Labels:         i.labels,
+		Hostname:       i.hostname,
+		Version:        Version,
+		ComputingPower: i.computingPower,
+		Load:           load,
+		Operations:     i.operations,
+		BatchSize:      i.batchSize,
+	}
+}
+
+// session — параметры, которые оркестратор сообщил при регистрации.
+type session struct {
+	interval  time.Duration
+	batchSize int
+}
+
+func register(ctx context.Context, client taskpb.OrchestratorClient, self identity, load *atomic.Int32) (session, error) {
+	reg, err := client.RegisterAgent(ctx, self.info(load.Load()))
+	if err != nil {
+		return session{}, err
+	}
+	interval := time.Duration(reg.GetHeartbeatIntervalMs()) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	// Оркестратор без поддержки пакетов не присылает batch_size: работаем через Work.
+	return session{interval: interval, batchSize: max(1, int(reg.GetBatchSize()))}, nil
+}
+
+// heartbeat сообщает оркестратору текущую нагрузку; если оркестратор агента
+// не знает (например, после перезапуска или истечения), агент регистрируется заново.
+func heartbeat(ctx context.Context, client taskpb.OrchestratorClient, self identity, interval time.Duration, load *atomic.Int32) {
 	logger := loggers.GetLogger("agent")
-	n, _ := strconv.Atoi(getenv("COMPUTING_POWER", "10"))
-	sem := make(chan struct{}, n)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
-		conn, err := grpc.NewClient(
-			getenv("ORCH_ADDR", "localhost:50051"),
-			grpc.WithTransportCredentials(insecure.NewCredentials()),
-			grpc.WithConnectParams(
-				grpc.ConnectParams{Backoff: backoff.DefaultConfig,
-					MinConnectTimeout: 5 * time.Second},
-			),
-		)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ack, err := client.Heartbeat(ctx, &taskpb.AgentHeartbeat{AgentId: self.id, Load: load.Load()})
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Heartbeat", "err", err)
+			}
+			continue
+		}
+		if !ack.GetRegistered() {
+			if _, err := register(ctx, client, self, load); err != nil {
+				logger.Error("RegisterAgent", "err", err)
+				continue
+			}
+			logger.Info("agent re-registered", "id", self.id)
+		}
+	}
+}
+
+// Run подключается к оркестраторам из cfg и выполняет задачи, пока процесс жив.
+func Run(cfg Config) {
+	logger := loggers.GetLogger("agent")
+	go watchReload(context.Background(), cfg)
+	n := cfg.ComputingPower
+	var inflight sync.Map
+	var load atomic.Int32
+	hostname, _ := os.Hostname()
+	id, err := agentID(cfg)
+	if err != nil {
+		logger.Error("agent ID", "err", err)
+		return
+	}
+	self := identity{
+		id:             id,
+		name:           cfg.Name,
+		labels:         cfg.Labels,
+		hostname:       hostname,
+		computingPower: int32(n),
+		batchSize:      int32(cfg.BatchSize),
+		operations:     cfg.Operations,
+	}
+	dial, err := dialOptions(cfg.TLS, cfg.Token)
+	if err != nil {
+		logger.Error("invalid TLS settings", "err", err)
+		return
+	}
+	dial = append(dial, grpc.WithConnectParams(
+		grpc.ConnectParams{Backoff: backoff.DefaultConfig,
+			MinConnectTimeout: 5 * time.Second},
+	))
+	base := metadata.AppendToOutgoingContext(context.Background(), agentIDKey, self.id, agentSessionKey, uuid.New().String())
+	for attempt := 0; ; attempt++ {
+		addr := cfg.Orchestrators[attempt%len(cfg.Orchestrators)]
+		conn, err := grpc.NewClient(addr, dial...)
 		if err != nil {
 			logger.Error("gRPC NewClient", "err", err)
 			time.Sleep(5 * time.Second)
 			continue
 		}
 		client := taskpb.NewOrchestratorClient(conn)
-		stream, err := client.GetTasks(context.Background(), &taskpb.Empty{})
+		ctx, cancel := context.WithCancel(base)
+		sess, err := register(ctx, client, self, &load)
 		if err != nil {
-			logger.Error("GetTasks", "err", err)
+			logger.Error("RegisterAgent", "orchestrator", addr, "err", err)
+			cancel()
 			conn.Close()
 			time.Sleep(5 * time.Second)
 			continue
 		}
-		for {
-			task, err := stream.Recv()
-			if err != nil {
-				logger.Error("stream recv", "err", err)
-				break
+		logger.Info("agent registered", "id", self.id, "name", self.name, "orchestrator", addr, "batch_size", sess.batchSize)
+		go heartbeat(ctx, client, self, sess.interval, &load)
+		go watchCancellations(ctx, client, &inflight)
+		if sess.batchSize > 1 {
+			stream, err := client.WorkBatch(ctx)
+			if err == nil {
+				workBatch(ctx, stream, int32(n), sess.batchSize, &inflight, &load)
+			} else {
+				logger.Error("WorkBatch", "err", err)
+			}
+		} else {
+			stream, err := client.Work(ctx)
+			if err == nil {
+				work(ctx, stream, int32(n), &inflight, &load)
+			} else {
+				logger.Error("Work", "err", err)
 			}
-			sem <- struct{}{}
-			go func(t *taskpb.Task) {
-				defer func() { <-sem }()
-				time.Sleep(time.Duration(t.OperationTime) * time.Millisecond)
-				res := calc(t.Arg1, t.Arg2, t.Operation)
-				if _, err := client.SendResult(context.Background(), &taskpb.SolvedTask{
-					Id:     t.Id,
-					Result: res,
-				}); err != nil {
-					logger.Error("SendResult", "err", err)
-				}
-			}(task)
 		}
+		cancel()
 		conn.Close()
 		time.Sleep(5 * time.Second)
 	}
 }
 
-func calc(a, b float64, op string) float64 {
+// execute выполняет задачу в отдельной горутине и передаёт результат в finish;
+// для брошенной (отменённой) задачи finish получает nil.
+func execute(ctx context.Context, t *taskpb.Task, inflight *sync.Map, load *atomic.Int32, finish func(*taskpb.SolvedTask)) {
+	load.Add(1)
+	taskCtx, taskCancel := context.WithCancel(ctx)
+	inflight.Store(t.Id, taskCancel)
+	go func() {
+		defer load.Add(-1)
+		defer inflight.Delete(t.Id)
+		defer taskCancel()
+		select {
+		case <-time.After(time.Duration(t.OperationTime) * time.Millisecond):
+			finish(solve(t))
+		case <-taskCtx.Done():
+			loggers.GetLogger("agent").Info("task abandoned", "id", t.Id)
+			finish(nil)
+		}
+	}()
+}
+
+// work выполняет задачи из потока Work. Агент сразу выдаёт оркестратору
+// credits кредитов и возвращает по одному вместе с каждым результатом, поэтому
+// одновременно у него не больше credits задач.
+func work(ctx context.Context, stream taskpb.Orchestrator_WorkClient, credits int32, inflight *sync.Map, load *atomic.Int32) {
+	logger := loggers.GetLogger("agent")
+	var mu sync.Mutex
+	send := func(req *taskpb.WorkRequest) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := stream.Send(req); err != nil && ctx.Err() == nil {
+			logger.Error("Work send", "err", err)
+		}
+	}
+	send(&taskpb.WorkRequest{Credits: credits})
+	for {
+		task, err := stream.Recv()
+		if err != nil {
+			logger.Error("stream recv", "err", err)
+			return
+		}
+		execute(ctx, task, inflight, load, func(result *taskpb.SolvedTask) {
+			send(&taskpb.WorkRequest{Credits: 1, Result: result})
+		})
+	}
+}
+
+// workBatch — пакетный вариант work: задачи приходят пакетами, а готовые
+// результаты отправляются пакетами до batchSize — всё, что накопилось к моменту отправки.
+func workBatch(ctx context.Context, stream taskpb.Orchestrator_WorkBatchClient, credits int32, batchSize int, inflight *sync.Map, load *atomic.Int32) {
+	logger := loggers.GetLogger("agent")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := stream.Send(&taskpb.ResultBatch{Credits: credits}); err != nil {
+		logger.Error("WorkBatch send", "err", err)
+		return
+	}
+	// Задач на агенте не больше credits, поэтому запись в finished не блокируется.
+	finished := make(chan *taskpb.SolvedTask, credits)
+	go func() {
+		for {
+			batch := &taskpb.ResultBatch{}
+			add := func(result *taskpb.SolvedTask) {
+				batch.Credits++
+				if result != nil {
+					batch.Results = append(batch.Results, result)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case result := <-finished:
+				add(result)
+			}
+		collect:
+			for len(batch.Results) < batchSize {
+				select {
+				case result := <-finished:
+					add(result)
+				default:
+					break collect
+				}
+			}
+			if err := stream.Send(batch); err != nil && ctx.Err() == nil {
+				logger.Error("WorkBatch send", "err", err)
+			}
+		}
+	}()
+	for {
+		batch, err := stream.Recv()
+		if err != nil {
+			logger.Error("stream recv", "err", err)
+			return
+		}
+		for _, task := range batch.GetTasks() {
+			execute(ctx, task, inflight, load, func(result *taskpb.SolvedTask) { finished <- result })
+		}
+	}
+}
+
+// watchCancellations прерывает задачи, которые оркестратор отменил вместе с выражением.
+func watchCancellations(ctx context.Context, client taskpb.OrchestratorClient, inflight *sync.Map) {
+	logger := loggers.GetLogger("agent")
+	stream, err := client.WatchCancellations(ctx, &taskpb.Empty{})
+	if err != nil {
+		logger.Error("WatchCancellations", "err", err)
+		return
+	}
+	for {
+		c, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("cancellations recv", "err", err)
+			}
+			return
+		}
+		for _, id := range c.TaskIds {
+			if cancel, ok := inflight.Load(id); ok {
+				cancel.(context.CancelFunc)()
+			}
+		}
+	}
+}
+
+// calcError — доменная ошибка вычисления, которую агент передаёт оркестратору в SolvedTask.
+type calcError struct {
+	code taskpb.ErrorCode
+	msg  string
+}
+
+func (e *calcError) Error() string { return e.msg }
+
+func calc(a, b float64, op string) (float64, error) {
+	var res float64
 	switch op {
 	case "+":
-		return a + b
+		res = a + b
 	case "-":
-		return a - b
+		res = a - b
 	case "*":
-		return a * b
+		res = a * b
 	case "/":
-		return a / b
+		if b == 0 {
+			return 0, &calcError{taskpb.ErrorCode_ERROR_DIVISION_BY_ZERO, "division by zero"}
+		}
+		res = a / b
+	default:
+		return 0, &calcError{taskpb.ErrorCode_ERROR_UNSUPPORTED_OPERATION, fmt.Sprintf("unsupported operation %q", op)}
+	}
+	switch {
+	case math.IsNaN(res):
+		return 0, &calcError{taskpb.ErrorCode_ERROR_NAN, fmt.Sprintf("%v %s %v is not a number", a, op, b)}
+	case math.IsInf(res, 0):
+		return 0, &calcError{taskpb.ErrorCode_ERROR_OVERFLOW, fmt.Sprintf("%v %s %v overflows float64", a, op, b)}
 	}
-	return 0
+	return res, nil
 }
 
-func getenv(k, def string) string {
-	if v := os.Getenv(k); v != "" {
-		return v
+// solve вычисляет задачу и упаковывает результат или ошибку в SolvedTask.
+func solve(t *taskpb.Task) *taskpb.SolvedTask {
+	res, err := calc(t.Arg1, t.Arg2, t.Operation)
+	if err != nil {
+		var cerr *calcError
+		errors.As(err, &cerr)
+		loggers.GetLogger("agent").Warn("task failed", "id", t.Id, "err", err)
+		return &taskpb.SolvedTask{Id: t.Id, ErrorCode: cerr.code, ErrorMessage: cerr.msg}
 	}
-	return def
+	return &taskpb.SolvedTask{Id: t.Id, Result: res}
 }
@@ -1,10 +1,213 @@
 package agent
 
 import (
+	"context"
+	"io"
+	"math"
 	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"calculator/internal/task/taskpb"
+	"calculator/pkg/loggers"
+
+	"google.golang.org/grpc"
 )
 
+func TestMain(m *testing.M) {
+	loggers.InitLogger("agent", os.DevNull)
+	code := m.Run()
+	loggers.CloseAllLoggers()
+	os.Exit(code)
+}
+
+// fakeClient отвечает на RegisterAgent и Heartbeat; оркестратор «забывает» агента после первого heartbeat.
+type fakeClient struct {
+	taskpb.OrchestratorClient
+	mu            sync.Mutex
+	registrations []*taskpb.AgentInfo
+	heartbeats    []*taskpb.AgentHeartbeat
+}
+
+func (f *fakeClient) RegisterAgent(_ context.Context, in *taskpb.AgentInfo, _ ...grpc.CallOption) (*taskpb.Registration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.registrations = append(f.registrations, in)
+	return &taskpb.Registration{HeartbeatIntervalMs: 5, BatchSize: in.BatchSize / 2}, nil
+}
+
+func (f *fakeClient) Heartbeat(_ context.Context, in *taskpb.AgentHeartbeat, _ ...grpc.CallOption) (*taskpb.HeartbeatAck, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.heartbeats = append(f.heartbeats, in)
+	return &taskpb.HeartbeatAck{Registered: len(f.heartbeats) > 1}, nil
+}
+
+func (f *fakeClient) counts() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.registrations), len(f.heartbeats)
+}
+
+func TestHeartbeatReportsLoadAndReregisters(t *testing.T) {
+	client := &fakeClient{}
+	self := identity{id: "agent-1", hostname: "host", computingPower: 4, batchSize: 8, operations: []string{"+", "-"}}
+	var load atomic.Int32
+	load.Store(3)
+	sess, err := register(context.Background(), client, self, &load)
+	if err != nil {
+		t.Fatalf("register error: %v", err)
+	}
+	if sess.interval != 5*time.Millisecond || sess.batchSize != 4 {
+		t.Errorf("session = %+v, want 5ms and the negotiated batch of 4", sess)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		heartbeat(ctx, client, self, sess.interval, &load)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, beats := client.counts(); beats >= 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	registrations, beats := client.counts()
+	if beats < 2 {
+		t.Fatalf("only %d heartbeats sent", beats)
+	}
+	if registrations != 2 {
+		t.Errorf("registrations = %d, want 2 (initial and after the unknown-agent ack)", registrations)
+	}
+	info := client.registrations[0]
+	if info.AgentId != "agent-1" || info.Hostname != "host" || info.ComputingPower != 4 || info.BatchSize != 8 || info.Load != 3 ||
+		info.Version != Version || !reflect.DeepEqual(info.Operations, []string{"+", "-"}) {
+		t.Errorf("registration = %+v", info)
+	}
+	if client.heartbeats[0].AgentId != "agent-1" || client.heartbeats[0].Load != 3 {
+		t.Errorf("heartbeat = %+v", client.heartbeats[0])
+	}
+}
+
+// fakeWorkStream — клиентская сторона потока Work: задачи приходят из tasks, запросы агента копятся в requests.
+type fakeWorkStream struct {
+	grpc.ClientStream
+	tasks    chan *taskpb.Task
+	requests chan *taskpb.WorkRequest
+}
+
+func (f *fakeWorkStream) Send(req *taskpb.WorkRequest) error {
+	f.requests <- req
+	return nil
+}
+
+func (f *fakeWorkStream) Recv() (*taskpb.Task, error) {
+	task, ok := <-f.tasks
+	if !ok {
+		return nil, io.EOF
+	}
+	return task, nil
+}
+
+func TestWorkGrantsCreditsAndReturnsThemWithResults(t *testing.T) {
+	stream := &fakeWorkStream{tasks: make(chan *taskpb.Task), requests: make(chan *taskpb.WorkRequest, 4)}
+	var inflight sync.Map
+	var load atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		work(context.Background(), stream, 3, &inflight, &load)
+		close(done)
+	}()
+	if req := <-stream.requests; req.Credits != 3 || req.Result != nil {
+		t.Fatalf("first request = %+v, want 3 credits", req)
+	}
+	stream.tasks <- &taskpb.Task{Id: "t1", Arg1: 2, Arg2: 3, Operation: "*"}
+	select {
+	case req := <-stream.requests:
+		if req.Credits != 1 || req.Result.GetId() != "t1" || req.Result.GetResult() != 6 {
+			t.Errorf("request = %+v, want the result with one credit", req)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("result was not sent")
+	}
+	close(stream.tasks)
+	<-done
+	deadline := time.Now().Add(time.Second)
+	for load.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := load.Load(); n != 0 {
+		t.Errorf("load = %d, want 0", n)
+	}
+}
+
+type fakeBatchStream struct {
+	grpc.ClientStream
+	batches  chan *taskpb.TaskBatch
+	requests chan *taskpb.ResultBatch
+}
+
+func (f *fakeBatchStream) Send(req *taskpb.ResultBatch) error {
+	f.requests <- req
+	return nil
+}
+
+func (f *fakeBatchStream) Recv() (*taskpb.TaskBatch, error) {
+	batch, ok := <-f.batches
+	if !ok {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+func TestWorkBatchReturnsResultsInBatches(t *testing.T) {
+	stream := &fakeBatchStream{batches: make(chan *taskpb.TaskBatch), requests: make(chan *taskpb.ResultBatch, 4)}
+	var inflight sync.Map
+	var load atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		workBatch(context.Background(), stream, 4, 4, &inflight, &load)
+		close(done)
+	}()
+	if req := <-stream.requests; req.Credits != 4 || len(req.Results) != 0 {
+		t.Fatalf("first request = %+v, want 4 credits", req)
+	}
+	stream.batches <- &taskpb.TaskBatch{Tasks: []*taskpb.Task{
+		{Id: "t1", Arg1: 1, Arg2: 2, Operation: "+"},
+		{Id: "t2", Arg1: 1, Arg2: 0, Operation: "/"},
+		{Id: "t3", Arg1: 3, Arg2: 3, Operation: "*"},
+	}}
+	results := make(map[string]*taskpb.SolvedTask)
+	var credits int32
+	deadline := time.After(time.Second)
+	for len(results) < 3 {
+		select {
+		case req := <-stream.requests:
+			credits += req.Credits
+			for _, result := range req.Results {
+				results[result.Id] = result
+			}
+		case <-deadline:
+			t.Fatalf("got results %v, want all three", results)
+		}
+	}
+	if credits != 3 {
+		t.Errorf("returned %d credits, want 3", credits)
+	}
+	if results["t1"].Result != 3 || results["t3"].Result != 9 || results["t2"].ErrorCode != taskpb.ErrorCode_ERROR_DIVISION_BY_ZERO {
+		t.Errorf("results = %v", results)
+	}
+	close(stream.batches)
+	<-done
+}
+
 func TestCalcOperations(t *testing.T) {
 	tests := []struct {
 		a, b float64
@@ -18,34 +221,28 @@ func TestCalcOperations(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		got := calc(tt.a, tt.b, tt.op)
-		if got != tt.want {
-			t.Errorf("calc(%v, %v, %q) = %v, want %v", tt.a, tt.b, tt.op, got, tt.want)
+		got, err := calc(tt.a, tt.b, tt.op)
+		if err != nil || got != tt.want {
+			t.Errorf("calc(%v, %v, %q) = %v, %v, want %v", tt.a, tt.b, tt.op, got, err, tt.want)
 		}
 	}
 }
 
-func TestCalcUnknown(t *testing.T) {
-	got := calc(1, 1, "%")
-	if got != 0 {
-		t.Errorf("calc(1, 1, \"%%\") = %v, want 0", got)
-	}
-}
-
-func TestGetenvDefault(t *testing.T) {
-	os.Unsetenv("TEST_AGENT_POWER")
-	got := getenv("TEST_AGENT_POWER", "42")
-	if got != "42" {
-		t.Errorf("getenv without set = %q, want %q", got, "42")
+func TestCalcErrors(t *testing.T) {
+	tests := []struct {
+		a, b float64
+		op   string
+		code taskpb.ErrorCode
+	}{
+		{1, 1, "%", taskpb.ErrorCode_ERROR_UNSUPPORTED_OPERATION},
+		{1, 0, "/", taskpb.ErrorCode_ERROR_DIVISION_BY_ZERO},
+		{math.MaxFloat64, 2, "*", taskpb.ErrorCode_ERROR_OVERFLOW},
+		{math.Inf(1), math.Inf(1), "-", taskpb.ErrorCode_ERROR_NAN},
 	}
-}
-
-func TestGetenvOverride(t *testing.T) {
-	// Устанавливаем переменную окружения
-	os.Setenv("TEST_AGENT_POWER", "17")
-	defer os.Unsetenv("TEST_AGENT_POWER")
-	got := getenv("TEST_AGENT_POWER", "99")
-	if got != "17" {
-		t.Errorf("getenv with set = %q, want %q", got, "17")
+	for _, tt := range tests {
+		solved := solve(&taskpb.Task{Id: "t", Arg1: tt.a, Arg2: tt.b, Operation: tt.op})
+		if solved.ErrorCode != tt.code || solved.ErrorMessage == "" || solved.Result != 0 {
+			t.Errorf("solve(%v %s %v) = %+v, want code %v", tt.a, tt.op, tt.b, solved, tt.code)
+		}
 	}
 }
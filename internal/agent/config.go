@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"calculator/pkg/configload"
+	"calculator/pkg/loggers"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Config — настройки агента. Значения берутся по возрастанию приоритета:
+// значения по умолчанию, JSON-файл (-config или AGENT_CONFIG), переменные
+// окружения, флаги командной строки.
+type Config struct {
+	// Orchestrators — адреса оркестраторов; при потере соединения агент
+	// переходит к следующему по кругу.
+	Orchestrators []string `json:"orchestrators"`
+	// ID — постоянный ID агента. Если он не задан, ID берётся из файла IDFile,
+	// а при первом запуске создаётся и сохраняется туда.
+	ID             string            `json:"id"`
+	IDFile         string            `json:"id_file"`
+	ComputingPower int               `json:"computing_power"`
+	BatchSize      int               `json:"batch_size"`
+	Operations     []string          `json:"operations"`
+	Name           string            `json:"name"`
+	Labels         map[string]string `json:"labels"`
+	Token          string            `json:"token"`
+	LogFile        string            `json:"log_file"`
+	LogLevel       string            `json:"log_level"`
+	TLS            TLS               `json:"tls"`
+
+	// args — аргументы командной строки, с которыми конфигурация загружена; нужны Reload.
+	args []string
+}
+
+func DefaultConfig() Config {
+	hostname, _ := os.Hostname()
+	return Config{
+		Orchestrators:  []string{"localhost:50051"},
+		ComputingPower: 10,
+		BatchSize:      1,
+		Operations:     slices.Clone(supportedOperations),
+		Name:           hostname,
+		IDFile:         "agent_id",
+		LogFile:        "agent_logs.txt",
+		LogLevel:       "INFO",
+	}
+}
+
+// Validate проверяет все значения сразу и возвращает все найденные ошибки.
+func (c Config) Validate() error {
+	var errs []error
+	if len(c.Orchestrators) == 0 {
+		errs = append(errs, errors.New("orchestrators must not be empty"))
+	}
+	for _, addr := range c.Orchestrators {
+		if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+			errs = append(errs, fmt.Errorf("orchestrator address %q must be host:port", addr))
+		}
+	}
+	if c.ComputingPower < 1 {
+		errs = append(errs, fmt.Errorf("computing_power must be at least 1, got %d", c.ComputingPower))
+	}
+	if c.BatchSize < 1 {
+		errs = append(errs, fmt.Errorf("batch_size must be at least 1, got %d", c.BatchSize))
+	}
+	if len(c.Operations) == 0 {
+		errs = append(errs, errors.New("operations must not be empty"))
+	}
+	for _, op := range c.Operations {
+		if !slices.Contains(supportedOperations, op) {
+			errs = append(errs, fmt.Errorf("unsupported operation %q, supported: %s", op, strings.Join(supportedOperations, " ")))
+		}
+	}
+	for key := range c.Labels {
+		if key == "" {
+			errs = append(errs, errors.New("label names must not be empty"))
+		}
+	}
+	if c.ID == "" && c.IDFile == "" {
+		errs = append(errs, errors.New("id or id_file must be set"))
+	}
+	if c.LogFile == "" {
+		errs = append(errs, errors.New("log_file must not be empty"))
+	}
+	if _, err := loggers.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+	if err := c.TLS.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("tls: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// labels разбирает метки вида "zone=eu,tier=gpu".
+func labels(c *Config, raw string) error {
+	parsed := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("label %q must be name=value", pair)
+		}
+		parsed[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	c.Labels = parsed
+	return nil
+}
+
+var settings = []configload.Setting[Config]{
+	{Env: "ORCH_ADDR", Flag: "orchestrator", Usage: "orchestrator addresses host:port, comma-separated, tried in turn", Set: configload.List(func(c *Config) *[]string { return &c.Orchestrators })},
+	{Env: "COMPUTING_POWER", Flag: "computing-power", Usage: "number of tasks computed at once", Set: configload.Integer(func(c *Config) *int { return &c.ComputingPower })},
+	{Env: "BATCH_SIZE", Flag: "batch-size", Usage: "preferred number of tasks per batch", Set: configload.Integer(func(c *Config) *int { return &c.BatchSize })},
+	{Env: "AGENT_OPERATIONS", Flag: "operations", Usage: "operations the agent accepts, comma-separated", Set: configload.List(func(c *Config) *[]string { return &c.Operations })},
+	{Env: "AGENT_ID", Flag: "id", Usage: "stable agent ID (default: read from -id-file)", Set: configload.Text(func(c *Config) *string { return &c.ID })},
+	{Env: "AGENT_ID_FILE", Flag: "id-file", Usage: "file keeping the generated agent ID between restarts", Set: configload.Text(func(c *Config) *string { return &c.IDFile })},
+	{Env: "AGENT_NAME", Flag: "name", Usage: "agent name shown to administrators (default: hostname)", Set: configload.Text(func(c *Config) *string { return &c.Name })},
+	{Env: "AGENT_LABELS", Flag: "labels", Usage: "agent labels name=value, comma-separated", Set: labels},
+	{Env: "AGENT_TOKEN", Set: configload.Text(func(c *Config) *string { return &c.Token })},
+	{Env: "AGENT_LOG", Flag: "log-file", Usage: "log file", Set: configload.Text(func(c *Config) *string { return &c.LogFile })},
+	{Env: "LOG_LEVEL", Flag: "log-level", Usage: "log level: DEBUG, INFO, WARN or ERROR", Set: configload.Text(func(c *Config) *string { return &c.LogLevel })},
+	{Env: "ORCH_TLS_CA", Flag: "tls-ca", Usage: "CA certificate of the orchestrator; enables TLS", Set: configload.Text(func(c *Config) *string { return &c.TLS.CA })},
+	{Env: "ORCH_TLS_CERT", Flag: "tls-cert", Usage: "agent client certificate", Set: configload.Text(func(c *Config) *string { return &c.TLS.Cert })},
+	{Env: "ORCH_TLS_KEY", Flag: "tls-key", Usage: "agent client certificate key", Set: configload.Text(func(c *Config) *string { return &c.TLS.Key })},
+	{Env: "ORCH_TLS_SERVER_NAME", Flag: "tls-server-name", Usage: "expected orchestrator certificate name", Set: configload.Text(func(c *Config) *string { return &c.TLS.ServerName })},
+}
+
+// LoadConfig собирает конфигурацию агента из файла, окружения и аргументов
+// командной строки args и проверяет её. На -help возвращает flag.ErrHelp.
+func LoadConfig(args []string, output io.Writer) (Config, error) {
+	c, err := configload.Load("agent", "AGENT_CONFIG", DefaultConfig(), settings, args, output)
+	if err != nil {
+		return Config{}, err
+	}
+	c.args = args
+	return c, nil
+}
+
+// Reload заново загружает конфигурацию из тех же источников, что и c.
+func (c Config) Reload() (Config, error) {
+	return LoadConfig(c.args, io.Discard)
+}
+
+// restartRequired возвращает имена настроек, которые в next отличаются от c,
+// но вступят в силу только после перезапуска агента. На ходу меняется лишь уровень логов.
+func (c Config) restartRequired(next Config) []string {
+	var names []string
+	check := func(name string, changed bool) {
+		if changed {
+			names = append(names, name)
+		}
+	}
+	check("orchestrators", !slices.Equal(c.Orchestrators, next.Orchestrators))
+	check("computing_power", c.ComputingPower != next.ComputingPower)
+	check("batch_size", c.BatchSize != next.BatchSize)
+	check("operations", !slices.Equal(c.Operations, next.Operations))
+	check("id", c.ID != next.ID || c.IDFile != next.IDFile)
+	check("name", c.Name != next.Name)
+	check("labels", !maps.Equal(c.Labels, next.Labels))
+	check("token", c.Token != next.Token)
+	check("log_file", c.LogFile != next.LogFile)
+	check("tls", c.TLS != next.TLS)
+	return names
+}
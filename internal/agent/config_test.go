@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `{"orchestrators":["a:1","b:2"],"computing_power":4,"name":"calc-1","labels":{"zone":"eu"},"tls":{"server_name":"orch"}}`)
+	t.Setenv("AGENT_CONFIG", path)
+	t.Setenv("COMPUTING_POWER", "6")
+	t.Setenv("AGENT_OPERATIONS", " +, *,+")
+	c, err := LoadConfig([]string{"-computing-power", "8", "-labels", "zone=us, gpu=no"}, io.Discard)
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if !reflect.DeepEqual(c.Orchestrators, []string{"a:1", "b:2"}) || c.Name != "calc-1" || c.TLS.ServerName != "orch" {
+		t.Errorf("file values not applied: %+v", c)
+	}
+	if c.ComputingPower != 8 || !reflect.DeepEqual(c.Labels, map[string]string{"zone": "us", "gpu": "no"}) {
+		t.Errorf("flags must override env and file: %+v", c)
+	}
+	if !reflect.DeepEqual(c.Operations, []string{"+", "*"}) {
+		t.Errorf("operations = %v, want [+ *] from the environment", c.Operations)
+	}
+	if c.BatchSize != 1 || c.LogFile != "agent_logs.txt" {
+		t.Errorf("defaults not kept: %+v", c)
+	}
+}
+
+func TestLoadConfigRejectsInvalidValues(t *testing.T) {
+	cases := []struct {
+		name, env, value string
+		args             []string
+		want             string
+	}{
+		{"power not a number", "COMPUTING_POWER", "ten", nil, "COMPUTING_POWER"},
+		{"zero power", "", "", []string{"-computing-power", "0"}, "computing_power"},
+		{"zero batch", "BATCH_SIZE", "0", nil, "batch_size"},
+		{"unsupported operation", "AGENT_OPERATIONS", "+,^", nil, `"^"`},
+		{"no operations", "", "", []string{"-operations", ","}, "operations"},
+		{"address without port", "ORCH_ADDR", "localhost", nil, `"localhost"`},
+		{"bad label", "", "", []string{"-labels", "gpu"}, "-labels"},
+		{"bad log level", "LOG_LEVEL", "loud", nil, "log_level"},
+		{"certificate without CA", "", "", []string{"-tls-cert", "c.pem", "-tls-key", "k.pem"}, "tls"},
+		{"extra argument", "", "", []string{"localhost:50051"}, "unexpected"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.env != "" {
+				t.Setenv(tc.env, tc.value)
+			}
+			_, err := LoadConfig(tc.args, io.Discard)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error = %v, want it to mention %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRejectsUnknownFileFields(t *testing.T) {
+	path := writeConfig(t, `{"workers":3}`)
+	if _, err := LoadConfig([]string{"-config", path}, io.Discard); err == nil || !strings.Contains(err.Error(), "workers") {
+		t.Errorf("error = %v, want the unknown field named", err)
+	}
+}
+
+func TestLoadConfigHelp(t *testing.T) {
+	var usage strings.Builder
+	if _, err := LoadConfig([]string{"--help"}, &usage); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("error = %v, want flag.ErrHelp", err)
+	}
+	if !strings.Contains(usage.String(), "-orchestrator") || !strings.Contains(usage.String(), "ORCH_ADDR") {
+		t.Errorf("usage does not describe flags:\n%s", usage.String())
+	}
+}
+
+func TestLoadConfigKeepsSupportedOperations(t *testing.T) {
+	path := writeConfig(t, `{"operations":["/"]}`)
+	if _, err := LoadConfig([]string{"-config", path}, io.Discard); err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if !reflect.DeepEqual(supportedOperations, []string{"+", "-", "*", "/"}) {
+		t.Errorf("supportedOperations = %v, the file overwrote the defaults", supportedOperations)
+	}
+}
+
+func TestAgentIDPersistsAcrossRestarts(t *testing.T) {
+	cfg := Config{IDFile: filepath.Join(t.TempDir(), "agent_id")}
+	first, err := agentID(cfg)
+	if err != nil || first == "" {
+		t.Fatalf("agentID = %q, %v", first, err)
+	}
+	second, err := agentID(cfg)
+	if err != nil || second != first {
+		t.Errorf("agentID after restart = %q, %v, want %q", second, err, first)
+	}
+	cfg.ID = "calc-1"
+	if id, _ := agentID(cfg); id != "calc-1" {
+		t.Errorf("agentID = %q, want the configured calc-1", id)
+	}
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"calculator/pkg/loggers"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TLS — как агент проверяет оркестратор и чем представляется ему сам.
+// Без CA соединение идёт без шифрования.
+type TLS struct {
+	CA         string `json:"ca"`
+	Cert       string `json:"cert"`
+	Key        string `json:"key"`
+	ServerName string `json:"server_name"`
+}
+
+func (c TLS) enabled() bool {
+	return c.CA != ""
+}
+
+func (c TLS) validate() error {
+	if (c.Cert == "") != (c.Key == "") {
+		return errors.New("client certificate and key must be set together")
+	}
+	if !c.enabled() && c.Cert != "" {
+		return errors.New("client certificate requires a CA")
+	}
+	return nil
+}
+
+func (c TLS) credentials() (credentials.TransportCredentials, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if !c.enabled() {
+		return insecure.NewCredentials(), nil
+	}
+	pem, err := os.ReadFile(c.CA)
+	if err != nil {
+		return nil, fmt.Errorf("read orchestrator CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("orchestrator CA %s has no certificates", c.CA)
+	}
+	config := &tls.Config{RootCAs: pool, ServerName: c.ServerName, MinVersion: tls.VersionTLS12}
+	if c.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// tokenCredentials добавляет общий секрет агентов к каждому вызову.
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return t.secure
+}
+
+// dialOptions собирает транспорт и токен (AGENT_TOKEN) для соединения с оркестратором.
+func dialOptions(config TLS, token string) ([]grpc.DialOption, error) {
+	creds, err := config.credentials()
+	if err != nil {
+		return nil, err
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if token != "" {
+		if !config.enabled() {
+			loggers.GetLogger("agent").Warn("AGENT_TOKEN is sent without TLS")
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{token: token, secure: config.enabled()}))
+	}
+	return opts, nil
+}
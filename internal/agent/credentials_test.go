@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSigned записывает в dir самоподписанный сертификат с ключом; он же служит CA.
+func selfSigned(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "agent"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestTLSConfigCredentials(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := selfSigned(t, dir)
+	creds, err := TLS{CA: cert, Cert: cert, Key: key}.credentials()
+	if err != nil {
+		t.Fatalf("credentials error: %v", err)
+	}
+	if creds.Info().SecurityProtocol != "tls" {
+		t.Errorf("protocol = %q, want tls", creds.Info().SecurityProtocol)
+	}
+	if creds, _ := (TLS{}).credentials(); creds.Info().SecurityProtocol != "insecure" {
+		t.Errorf("protocol without CA = %q, want insecure", creds.Info().SecurityProtocol)
+	}
+	invalid := []TLS{
+		{Cert: cert, Key: key},
+		{CA: cert, Cert: cert},
+		{CA: filepath.Join(dir, "missing.pem")},
+		{CA: key},
+		{CA: cert, Cert: cert, Key: filepath.Join(dir, "missing.pem")},
+	}
+	for _, config := range invalid {
+		if _, err := dialOptions(config, ""); err == nil {
+			t.Errorf("dialOptions(%+v) accepted an invalid configuration", config)
+		}
+	}
+}
+
+func TestTokenCredentials(t *testing.T) {
+	creds := tokenCredentials{token: "secret", secure: true}
+	md, err := creds.GetRequestMetadata(context.Background())
+	if err != nil || md["authorization"] != "Bearer secret" {
+		t.Errorf("metadata = %v, %v", md, err)
+	}
+	if !creds.RequireTransportSecurity() {
+		t.Error("token over TLS must require transport security")
+	}
+	opts, err := dialOptions(TLS{}, "secret")
+	if err != nil || len(opts) != 2 {
+		t.Errorf("dialOptions = %d options, %v, want transport and token", len(opts), err)
+	}
+}
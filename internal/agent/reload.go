@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"calculator/pkg/configload"
+	"calculator/pkg/loggers"
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reload заново загружает конфигурацию и применяет уровень логов; при ошибке
+// действующие настройки не меняются. Остальные настройки требуют перезапуска,
+// об их изменении агент только предупреждает.
+func reload(current Config, logger *slog.Logger) (Config, error) {
+	next, err := current.Reload()
+	if err != nil {
+		return current, err
+	}
+	level, _ := loggers.ParseLevel(next.LogLevel)
+	configload.Reloaded(logger, level, current.restartRequired(next))
+	current.LogLevel = next.LogLevel
+	return current, nil
+}
+
+// watchReload применяет настройки заново по SIGHUP, не разрывая соединение с оркестратором.
+func watchReload(ctx context.Context, cfg Config) {
+	logger := loggers.GetLogger("agent")
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			next, err := reload(cfg, logger)
+			if err != nil {
+				logger.Error("configuration reload failed, keeping current settings", "err", err)
+				continue
+			}
+			cfg = next
+		}
+	}
+}
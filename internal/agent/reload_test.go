@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"calculator/pkg/loggers"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+	defer loggers.SetLevel(slog.LevelInfo)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	path := writeConfig(t, `{"log_level":"info","computing_power":2}`)
+	c, err := LoadConfig([]string{"-config", path}, io.Discard)
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+
+	os.WriteFile(path, []byte(`{"log_level":"debug","computing_power":5}`), 0o600)
+	c, err = reload(c, logger)
+	if err != nil || loggers.Level() != slog.LevelDebug {
+		t.Fatalf("reload = %v, level %v, want DEBUG from the file", err, loggers.Level())
+	}
+	if c.ComputingPower != 2 {
+		t.Errorf("computing_power = %d, want 2 kept until restart", c.ComputingPower)
+	}
+	if restart := c.restartRequired(Config{LogLevel: "ERROR"}); len(restart) == 0 {
+		t.Error("restartRequired found no changes")
+	}
+
+	os.WriteFile(path, []byte(`{"log_level":"loud"}`), 0o600)
+	if _, err := reload(c, logger); err == nil || loggers.Level() != slog.LevelDebug {
+		t.Errorf("invalid file: err = %v, level %v, want the previous level kept", err, loggers.Level())
+	}
+	t.Setenv("LOG_LEVEL", "WARN")
+	os.WriteFile(path, []byte(`{"log_level":"error"}`), 0o600)
+	if _, err := reload(c, logger); err != nil || loggers.Level() != slog.LevelWarn {
+		t.Errorf("reload = %v, level %v, want WARN from LOG_LEVEL", err, loggers.Level())
+	}
+}
@@ -1,34 +1,67 @@
 package application
 
 import (
+	"calculator/internal/config"
+	"calculator/internal/cost"
+	"calculator/internal/database"
 	"calculator/internal/http/server"
+	"calculator/internal/queue"
+	"calculator/internal/quota"
+	"calculator/internal/recovery"
 	rpcserver "calculator/internal/rpc"
+	"calculator/pkg/calculator"
 	"calculator/pkg/loggers"
 	"context"
 	"os"
 	"os/signal"
+	"sync"
+	"time"
 )
 
-type Application struct{}
+type Application struct {
+	mu       sync.Mutex
+	config   config.Config
+	tasks    *queue.Queue
+	quotas   *quota.Manager
+	costs    *cost.Model
+	recovery *recovery.Recovery
+	agents   *rpcserver.Registry
+}
 
-func New() *Application {
-	return &Application{}
+func New(cfg config.Config) *Application {
+	a := &Application{
+		config: cfg,
+		tasks:  queue.New(cfg.Scheduler.Options()),
+		quotas: quota.NewManager(cfg.Quotas),
+		costs:  cost.NewModel(cfg.Costs),
+		agents: rpcserver.NewRegistry(),
+	}
+	calculator.SetDefaultTimeout(time.Duration(cfg.ExpressionTimeout))
+	a.recovery = recovery.New(database.DBStore{}, func(expressionID string) {
+		go calculator.Calc(database.DBStore{}, a.tasks, a.costs, expressionID)
+	})
+	return a
 }
 
 func (a *Application) Run(ctx context.Context) int {
 	logger := loggers.GetLogger("general")
 	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
 	defer stop()
-	httpShutdown, err := server.Run(ctx)
+	if _, err := a.recovery.Run(); err != nil {
+		logger.Error(err.Error())
+		return 1
+	}
+	httpShutdown, err := server.Run(ctx, a.config, a.settings, a.tasks, a.quotas, a.costs, a.recovery, a.agents)
 	if err != nil {
 		logger.Error(err.Error())
 		return 1
 	}
-	grpcShutdown, err := rpcserver.Run(ctx)
+	grpcShutdown, err := rpcserver.Run(ctx, a.config.GRPCAddr, a.config.Agents.Options(), a.tasks, a.agents)
 	if err != nil {
 		logger.Error(err.Error())
 		return 1
 	}
+	go a.watchReload(ctx)
 	<-ctx.Done()
 	if err := grpcShutdown(context.Background()); err != nil {
 		logger.Error("gRPC shutdown: " + err.Error())
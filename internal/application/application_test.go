@@ -0,0 +1,71 @@
+package application
+
+import (
+	"calculator/internal/config"
+	"calculator/pkg/loggers"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	loggers.InitLogger("general", os.DevNull)
+	code := m.Run()
+	loggers.CloseAllLoggers()
+	os.Exit(code)
+}
+
+func TestReload(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	defer loggers.SetLevel(slog.LevelInfo)
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"jwt_secret":"s"}`), 0o600)
+	cfg, err := config.Load([]string{"-config", path}, io.Discard)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	a := New(cfg)
+	a.quotas.Set(1, cfg.Quotas)
+
+	os.WriteFile(path, []byte(`{"jwt_secret":"s","port":9999,"log_level":"DEBUG","costs":{"division_ms":5},"quotas":{"max_concurrent":2}}`), 0o600)
+	if err := a.reload(); err != nil {
+		t.Fatalf("reload error: %v", err)
+	}
+	settings := a.settings()
+	if settings.LogLevel != "DEBUG" || loggers.Level() != slog.LevelDebug {
+		t.Errorf("log level = %s, want DEBUG", settings.LogLevel)
+	}
+	if ms, _ := a.costs.Cost(1, "/"); ms != 5 || settings.Costs.Division != 5 {
+		t.Errorf("division cost = %d, want 5", ms)
+	}
+	if limits, _ := a.quotas.Limits(2); limits.MaxConcurrent != 2 {
+		t.Errorf("default quota = %+v, want max_concurrent 2", limits)
+	}
+	if limits, _ := a.quotas.Limits(1); limits.MaxConcurrent == 2 {
+		t.Error("reload replaced a personal quota")
+	}
+	if settings.Port != cfg.Port {
+		t.Errorf("port = %d, want %d until restart", settings.Port, cfg.Port)
+	}
+
+	os.WriteFile(path, []byte(`{"jwt_secret":"s","log_level":"LOUD"}`), 0o600)
+	if err := a.reload(); err == nil {
+		t.Error("reload accepted an invalid configuration")
+	}
+	if loggers.Level() != slog.LevelDebug {
+		t.Error("a failed reload changed the log level")
+	}
+
+	os.WriteFile(path, []byte(`{"jwt_secret":"s","costs":{"division_ms":7},"quotas":{"max_concurrent":-1}}`), 0o600)
+	if err := a.reload(); err == nil {
+		t.Error("reload accepted negative quotas")
+	}
+	if ms, _ := a.costs.Cost(1, "/"); ms != 5 {
+		t.Errorf("division cost = %d, want 5: a failed reload must not apply part of the configuration", ms)
+	}
+	if limits, _ := a.quotas.Limits(2); limits.MaxConcurrent != 2 {
+		t.Errorf("default quota = %+v, want max_concurrent 2 kept", limits)
+	}
+}
@@ -0,0 +1,82 @@
+package application
+
+import (
+	"calculator/internal/config"
+	"calculator/pkg/calculator"
+	"calculator/pkg/configload"
+	"calculator/pkg/loggers"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// watchReload перечитывает конфигурацию по SIGHUP. Соединения агентов и
+// клиентов при этом не разрываются.
+func (a *Application) watchReload(ctx context.Context) {
+	logger := loggers.GetLogger("general")
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := a.reload(); err != nil {
+				logger.Error("configuration reload failed, keeping current settings", "err", err)
+			}
+		}
+	}
+}
+
+// reload загружает конфигурацию из тех же источников, что и при запуске, и
+// применяет то, что можно менять на ходу: уровень логирования, время операций,
+// квоты и лимит времени выражений по умолчанию. Персональные настройки
+// пользователей не трогаются. Конфигурация применяется целиком или не
+// применяется вовсе: при ошибке остаются прежние настройки.
+func (a *Application) reload() error {
+	a.mu.Lock()
+	current := a.config
+	a.mu.Unlock()
+	next, err := current.Reload()
+	if err != nil {
+		return err
+	}
+	next, restart := current.Reloadable(next)
+	if err := next.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	level, err := loggers.ParseLevel(next.LogLevel)
+	if err != nil {
+		return err
+	}
+	costs := a.costs.Defaults()
+	if err := a.costs.SetDefaults(next.Costs); err != nil {
+		return fmt.Errorf("costs: %w", err)
+	}
+	if err := a.quotas.SetDefaults(next.Quotas); err != nil {
+		a.costs.SetDefaults(costs)
+		return fmt.Errorf("quotas: %w", err)
+	}
+	calculator.SetDefaultTimeout(time.Duration(next.ExpressionTimeout))
+	a.mu.Lock()
+	a.config = next
+	a.mu.Unlock()
+	configload.Reloaded(loggers.GetLogger("general"), level, restart, "costs", next.Costs, "quotas", next.Quotas, "expression_timeout", next.ExpressionTimeout)
+	return nil
+}
+
+// settings — действующая конфигурация без секретов: с учётом перезагрузок и
+// изменений через admin API.
+func (a *Application) settings() config.Config {
+	a.mu.Lock()
+	cfg := a.config
+	a.mu.Unlock()
+	cfg.LogLevel = loggers.Level().String()
+	cfg.Costs = a.costs.Defaults()
+	cfg.Quotas = a.quotas.Defaults()
+	return cfg.Redacted()
+}
@@ -4,7 +4,6 @@ import (
 	"calculator/internal/database"
 	"calculator/pkg/authutils"
 	"errors"
-	"os"
 )
 
 func Register(login, password string) error {
@@ -20,7 +19,7 @@ func Register(login, password string) error {
 	return database.CreateUser(&user)
 }
 
-func Login(login, password string) (string, error) {
+func Login(login, password, secret string) (string, error) {
 	user, err := database.GetUserByLogin(login)
 	if err != nil {
 		return "", errors.New("invalid credentials")
@@ -28,5 +27,5 @@ func Login(login, password string) (string, error) {
 	if !authutils.CheckPasswordHash(password, user.GetPassword()) {
 		return "", errors.New("invalid credentials")
 	}
-	return authutils.GenerateJWT(user.ID, os.Getenv("JWT_SECRET"))
+	return authutils.GenerateJWT(user.ID, secret)
 }
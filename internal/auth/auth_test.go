@@ -1,7 +1,6 @@
 package auth_test
 
 import (
-	"os"
 	"testing"
 
 	"calculator/internal/auth"
@@ -73,9 +72,7 @@ func TestLogin_Success(t *testing.T) {
 		t.Fatalf("CreateUser error: %v", err)
 	}
 	secret := "jwtsecret"
-	os.Setenv("JWT_SECRET", secret)
-	defer os.Unsetenv("JWT_SECRET")
-	tokenStr, err := auth.Login(login, password)
+	tokenStr, err := auth.Login(login, password, secret)
 	if err != nil {
 		t.Fatalf("Login returned error: %v", err)
 	}
@@ -109,9 +106,7 @@ func TestLogin_WrongPassword(t *testing.T) {
 	user := database.User{Login: login}
 	user.SetPassword(hash)
 	database.CreateUser(&user)
-	os.Setenv("JWT_SECRET", "jwtsecret")
-	defer os.Unsetenv("JWT_SECRET")
-	_, err := auth.Login(login, "wrong")
+	_, err := auth.Login(login, "wrong", "jwtsecret")
 	if err == nil {
 		t.Fatal("expected error for invalid credentials, got nil")
 	}
@@ -122,9 +117,7 @@ func TestLogin_WrongPassword(t *testing.T) {
 
 func TestLogin_UserNotFound(t *testing.T) {
 	setupTestDB(t)
-	os.Setenv("JWT_SECRET", "jwtsecret")
-	defer os.Unsetenv("JWT_SECRET")
-	_, err := auth.Login("nonexistent", "pass")
+	_, err := auth.Login("nonexistent", "pass", "jwtsecret")
 	if err == nil {
 		t.Fatal("expected error for invalid credentials, got nil")
 	}
@@ -0,0 +1,313 @@
+package config
+
+import (
+	"calculator/internal/cost"
+	"calculator/internal/queue"
+	"calculator/internal/quota"
+	rpcserver "calculator/internal/rpc"
+	"calculator/pkg/configload"
+	"calculator/pkg/loggers"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"time"
+)
+
+// Logs — файлы логов оркестратора.
+type Logs struct {
+	Server       string `json:"server"`
+	Orchestrator string `json:"orchestrator"`
+	General      string `json:"general"`
+}
+
+// Duration — длительность, которая в JSON-файле записывается строкой вида "30s".
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Scheduler — настройки очереди задач.
+type Scheduler struct {
+	PriorityAging   Duration     `json:"priority_aging"`
+	MaxTaskAttempts int          `json:"max_task_attempts"`
+	Weights         map[uint]int `json:"weights"`
+	// VerificationRate — доля задач, которые выполняют два агента (от 0 до 1).
+	VerificationRate float64 `json:"verification_rate"`
+}
+
+func (s Scheduler) Options() queue.Options {
+	return queue.Options{
+		Aging:            time.Duration(s.PriorityAging),
+		MaxAttempts:      s.MaxTaskAttempts,
+		Weights:          s.Weights,
+		VerificationRate: s.VerificationRate,
+	}
+}
+
+// TLS — сертификаты gRPC-сервера; ClientCA включает проверку сертификатов агентов.
+type TLS struct {
+	Cert     string `json:"cert"`
+	Key      string `json:"key"`
+	ClientCA string `json:"client_ca"`
+}
+
+// Agents — настройки gRPC-сервера и работы с агентами.
+type Agents struct {
+	Token              string   `json:"token"`
+	TLS                TLS      `json:"tls"`
+	HeartbeatInterval  Duration `json:"heartbeat_interval"`
+	LeaseTimeout       Duration `json:"lease_timeout"`
+	RoutingGracePeriod Duration `json:"routing_grace_period"`
+	MaxBatchSize       int      `json:"max_batch_size"`
+	StragglerFactor    float64  `json:"straggler_factor"`
+	StragglerMinDelay  Duration `json:"straggler_min_delay"`
+}
+
+func (a Agents) Options() rpcserver.Options {
+	return rpcserver.Options{
+		Token:             a.Token,
+		TLS:               rpcserver.TLSConfig{CertFile: a.TLS.Cert, KeyFile: a.TLS.Key, ClientCA: a.TLS.ClientCA},
+		HeartbeatInterval: time.Duration(a.HeartbeatInterval),
+		LeaseTimeout:      time.Duration(a.LeaseTimeout),
+		RoutingGrace:      time.Duration(a.RoutingGracePeriod),
+		MaxBatchSize:      a.MaxBatchSize,
+		StragglerFactor:   a.StragglerFactor,
+		StragglerMinDelay: time.Duration(a.StragglerMinDelay),
+	}
+}
+
+// Config — настройки оркестратора. Значения берутся по возрастанию
+// приоритета: значения по умолчанию, JSON-файл (-config или ORCHESTRATOR_CONFIG),
+// переменные окружения, флаги командной строки.
+type Config struct {
+	Port      int          `json:"port"`
+	GRPCAddr  string       `json:"grpc_addr"`
+	Database  string       `json:"database"`
+	JWTSecret string       `json:"jwt_secret"`
+	LogLevel  string       `json:"log_level"`
+	Logs      Logs         `json:"logs"`
+	Costs     cost.Costs   `json:"costs"`
+	Quotas    quota.Limits `json:"quotas"`
+	// ExpressionTimeout — лимит времени на выражение без собственного лимита; 0 — без лимита.
+	ExpressionTimeout Duration  `json:"expression_timeout"`
+	Scheduler         Scheduler `json:"scheduler"`
+	Agents            Agents    `json:"agents"`
+
+	// args — аргументы командной строки, с которыми конфигурация загружена; нужны Reload.
+	args []string
+}
+
+func Default() Config {
+	return Config{
+		Port:     8080,
+		GRPCAddr: ":50051",
+		Database: "sqlite.db",
+		LogLevel: "INFO",
+		Logs: Logs{
+			Server:       "server_logs.txt",
+			Orchestrator: "calculations_logs.txt",
+			General:      "general_logs.txt",
+		},
+		Costs:             cost.Defaults,
+		Quotas:            quota.Defaults,
+		ExpressionTimeout: Duration(10 * time.Minute),
+		Scheduler: Scheduler{
+			PriorityAging:   Duration(queue.Defaults.Aging),
+			MaxTaskAttempts: queue.Defaults.MaxAttempts,
+		},
+		Agents: Agents{
+			HeartbeatInterval:  Duration(rpcserver.Defaults.HeartbeatInterval),
+			LeaseTimeout:       Duration(rpcserver.Defaults.LeaseTimeout),
+			RoutingGracePeriod: Duration(rpcserver.Defaults.RoutingGrace),
+			MaxBatchSize:       rpcserver.Defaults.MaxBatchSize,
+			StragglerFactor:    rpcserver.Defaults.StragglerFactor,
+			StragglerMinDelay:  Duration(rpcserver.Defaults.StragglerMinDelay),
+		},
+	}
+}
+
+// Validate проверяет все значения сразу и возвращает все найденные ошибки.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", c.Port))
+	}
+	if _, _, err := net.SplitHostPort(c.GRPCAddr); err != nil {
+		errs = append(errs, fmt.Errorf("grpc_addr: %w", err))
+	}
+	if c.Database == "" {
+		errs = append(errs, errors.New("database must not be empty"))
+	}
+	if c.JWTSecret == "" {
+		errs = append(errs, errors.New("jwt_secret is required"))
+	}
+	if _, err := loggers.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+	if c.Logs.Server == "" || c.Logs.Orchestrator == "" || c.Logs.General == "" {
+		errs = append(errs, errors.New("log file names must not be empty"))
+	}
+	if err := c.Costs.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("costs: %w", err))
+	}
+	if err := c.Quotas.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("quotas: %w", err))
+	}
+	if c.ExpressionTimeout < 0 {
+		errs = append(errs, errors.New("expression_timeout must not be negative"))
+	}
+	if c.Scheduler.PriorityAging <= 0 {
+		errs = append(errs, errors.New("scheduler.priority_aging must be positive"))
+	}
+	if c.Scheduler.MaxTaskAttempts < 0 {
+		errs = append(errs, errors.New("scheduler.max_task_attempts must not be negative"))
+	}
+	for user, weight := range c.Scheduler.Weights {
+		if weight < 1 {
+			errs = append(errs, fmt.Errorf("scheduler.weights: weight of user %d must be a positive integer, got %d", user, weight))
+		}
+	}
+	if c.Scheduler.VerificationRate < 0 || c.Scheduler.VerificationRate > 1 {
+		errs = append(errs, fmt.Errorf("scheduler.verification_rate must be from 0 to 1, got %v", c.Scheduler.VerificationRate))
+	}
+	if c.Agents.HeartbeatInterval <= 0 || c.Agents.LeaseTimeout <= 0 || c.Agents.RoutingGracePeriod <= 0 {
+		errs = append(errs, errors.New("agents.heartbeat_interval, agents.lease_timeout and agents.routing_grace_period must be positive"))
+	}
+	if c.Agents.MaxBatchSize < 1 {
+		errs = append(errs, fmt.Errorf("agents.max_batch_size must be at least 1, got %d", c.Agents.MaxBatchSize))
+	}
+	if c.Agents.StragglerFactor < 0 || c.Agents.StragglerMinDelay < 0 {
+		errs = append(errs, errors.New("agents.straggler_factor and agents.straggler_min_delay must not be negative"))
+	}
+	if (c.Agents.TLS.Cert == "") != (c.Agents.TLS.Key == "") {
+		errs = append(errs, errors.New("agents.tls: cert and key must be set together"))
+	}
+	if c.Agents.TLS.ClientCA != "" && c.Agents.TLS.Cert == "" {
+		errs = append(errs, errors.New("agents.tls: client_ca requires a server certificate"))
+	}
+	return errors.Join(errs...)
+}
+
+func duration(field func(c *Config) *Duration) func(*Config, string) error {
+	return func(c *Config, raw string) error {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration, e.g. \"30s\"", raw)
+		}
+		*field(c) = Duration(d)
+		return nil
+	}
+}
+
+func weights(c *Config, raw string) error {
+	parsed, err := queue.ParseWeights(raw)
+	if err != nil {
+		return err
+	}
+	c.Scheduler.Weights = parsed
+	return nil
+}
+
+var settings = []configload.Setting[Config]{
+	{Env: "PORT", Flag: "port", Usage: "HTTP port", Set: configload.Integer(func(c *Config) *int { return &c.Port })},
+	{Env: "GRPC_ADDR", Flag: "grpc-addr", Usage: "gRPC listen address for agents", Set: configload.Text(func(c *Config) *string { return &c.GRPCAddr })},
+	{Env: "DB_PATH", Flag: "db", Usage: "SQLite database file", Set: configload.Text(func(c *Config) *string { return &c.Database })},
+	{Env: "JWT_SECRET", Set: configload.Text(func(c *Config) *string { return &c.JWTSecret })},
+	{Env: "LOG_LEVEL", Flag: "log-level", Usage: "log level: DEBUG, INFO, WARN or ERROR", Set: configload.Text(func(c *Config) *string { return &c.LogLevel })},
+	{Env: "SERVER_LOG", Flag: "server-log", Usage: "HTTP server log file", Set: configload.Text(func(c *Config) *string { return &c.Logs.Server })},
+	{Env: "ORCHESTRATOR_LOG", Flag: "orchestrator-log", Usage: "calculations log file", Set: configload.Text(func(c *Config) *string { return &c.Logs.Orchestrator })},
+	{Env: "GENERAL_LOG", Flag: "general-log", Usage: "general log file", Set: configload.Text(func(c *Config) *string { return &c.Logs.General })},
+	{Env: "TIME_ADDITION_MS", Set: configload.Integer(func(c *Config) *int { return &c.Costs.Addition })},
+	{Env: "TIME_SUBTRACTION_MS", Set: configload.Integer(func(c *Config) *int { return &c.Costs.Subtraction })},
+	{Env: "TIME_MULTIPLICATIONS_MS", Set: configload.Integer(func(c *Config) *int { return &c.Costs.Multiplication })},
+	{Env: "TIME_DIVISIONS_MS", Set: configload.Integer(func(c *Config) *int { return &c.Costs.Division })},
+	{Env: "QUOTA_MAX_CONCURRENT", Set: configload.Integer(func(c *Config) *int { return &c.Quotas.MaxConcurrent })},
+	{Env: "QUOTA_MAX_TASKS_PER_MINUTE", Set: configload.Integer(func(c *Config) *int { return &c.Quotas.MaxTasksPerMinute })},
+	{Env: "QUOTA_MAX_EXPRESSION_LENGTH", Set: configload.Integer(func(c *Config) *int { return &c.Quotas.MaxExpressionLength })},
+	{Env: "QUOTA_MAX_OPERATIONS", Set: configload.Integer(func(c *Config) *int { return &c.Quotas.MaxOperations })},
+	{Env: "EXPRESSION_TIMEOUT", Flag: "expression-timeout", Usage: "time limit for expressions without their own, 0 for none", Set: duration(func(c *Config) *Duration { return &c.ExpressionTimeout })},
+	{Env: "PRIORITY_AGING", Flag: "priority-aging", Usage: "waiting time that raises a task by one priority class", Set: duration(func(c *Config) *Duration { return &c.Scheduler.PriorityAging })},
+	{Env: "MAX_TASK_ATTEMPTS", Flag: "max-task-attempts", Usage: "dispatches before a task is dead-lettered, 0 for no limit", Set: configload.Integer(func(c *Config) *int { return &c.Scheduler.MaxTaskAttempts })},
+	{Env: "SCHEDULER_WEIGHTS", Flag: "scheduler-weights", Usage: "user weights, e.g. 1=3,2=1", Set: weights},
+	{Env: "VERIFICATION_RATE", Flag: "verification-rate", Usage: "share of tasks run by two agents, from 0 to 1", Set: configload.Number(func(c *Config) *float64 { return &c.Scheduler.VerificationRate })},
+	{Env: "AGENT_TOKEN", Set: configload.Text(func(c *Config) *string { return &c.Agents.Token })},
+	{Env: "GRPC_TLS_CERT", Flag: "grpc-tls-cert", Usage: "gRPC server certificate", Set: configload.Text(func(c *Config) *string { return &c.Agents.TLS.Cert })},
+	{Env: "GRPC_TLS_KEY", Flag: "grpc-tls-key", Usage: "gRPC server key", Set: configload.Text(func(c *Config) *string { return &c.Agents.TLS.Key })},
+	{Env: "GRPC_TLS_CLIENT_CA", Flag: "grpc-tls-client-ca", Usage: "CA of agent certificates, enables mTLS", Set: configload.Text(func(c *Config) *string { return &c.Agents.TLS.ClientCA })},
+	{Env: "AGENT_HEARTBEAT_INTERVAL", Flag: "heartbeat-interval", Usage: "how often agents send heartbeats", Set: duration(func(c *Config) *Duration { return &c.Agents.HeartbeatInterval })},
+	{Env: "TASK_LEASE_TIMEOUT", Flag: "lease-timeout", Usage: "time over the operation time before a task is requeued", Set: duration(func(c *Config) *Duration { return &c.Agents.LeaseTimeout })},
+	{Env: "ROUTING_GRACE_PERIOD", Flag: "routing-grace-period", Usage: "how long a task waits for a capable agent", Set: duration(func(c *Config) *Duration { return &c.Agents.RoutingGracePeriod })},
+	{Env: "MAX_BATCH_SIZE", Flag: "max-batch-size", Usage: "largest task batch sent to an agent", Set: configload.Integer(func(c *Config) *int { return &c.Agents.MaxBatchSize })},
+	{Env: "STRAGGLER_FACTOR", Flag: "straggler-factor", Usage: "operation time multiple after which a task is duplicated, 0 disables", Set: configload.Number(func(c *Config) *float64 { return &c.Agents.StragglerFactor })},
+	{Env: "STRAGGLER_MIN_DELAY", Flag: "straggler-min-delay", Usage: "minimum time before a task is duplicated", Set: duration(func(c *Config) *Duration { return &c.Agents.StragglerMinDelay })},
+}
+
+// Load собирает конфигурацию из файла, окружения и аргументов командной
+// строки args и проверяет её. На -help возвращает flag.ErrHelp.
+func Load(args []string, output io.Writer) (Config, error) {
+	c, err := configload.Load("orchestrator", "ORCHESTRATOR_CONFIG", Default(), settings, args, output)
+	if err != nil {
+		return Config{}, err
+	}
+	c.args = args
+	return c, nil
+}
+
+// Reload заново загружает конфигурацию из тех же источников, что и c.
+func (c Config) Reload() (Config, error) {
+	return Load(c.args, io.Discard)
+}
+
+// Reloadable возвращает next, в котором настройки, применяемые только при
+// запуске (порты, база, секрет, файлы логов, очередь и агенты), оставлены как в c, и имена
+// тех из них, что в next изменились. Остальное можно менять на ходу.
+func (c Config) Reloadable(next Config) (Config, []string) {
+	var restart []string
+	keep := func(name string, changed bool) {
+		if changed {
+			restart = append(restart, name)
+		}
+	}
+	keep("port", next.Port != c.Port)
+	keep("grpc_addr", next.GRPCAddr != c.GRPCAddr)
+	keep("database", next.Database != c.Database)
+	keep("jwt_secret", next.JWTSecret != c.JWTSecret)
+	keep("logs", next.Logs != c.Logs)
+	keep("scheduler", !reflect.DeepEqual(next.Scheduler, c.Scheduler))
+	keep("agents", next.Agents != c.Agents)
+	next.Port, next.GRPCAddr, next.Database, next.JWTSecret, next.Logs = c.Port, c.GRPCAddr, c.Database, c.JWTSecret, c.Logs
+	next.Scheduler, next.Agents = c.Scheduler, c.Agents
+	return next, restart
+}
+
+// Redacted возвращает копию конфигурации без секретов — для показа администратору.
+func (c Config) Redacted() Config {
+	if c.JWTSecret != "" {
+		c.JWTSecret = "[redacted]"
+	}
+	if c.Agents.Token != "" {
+		c.Agents.Token = "[redacted]"
+	}
+	return c
+}
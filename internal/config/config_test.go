@@ -0,0 +1,175 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `{"port":9000,"grpc_addr":":6000","jwt_secret":"from-file","costs":{"division_ms":7}}`)
+	t.Setenv("ORCHESTRATOR_CONFIG", path)
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("GRPC_ADDR", ":7000")
+	t.Setenv("TIME_ADDITION_MS", "3")
+	c, err := Load([]string{"-grpc-addr", ":8000", "-db", "test.db"}, io.Discard)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if c.Port != 9000 || c.JWTSecret != "from-file" {
+		t.Errorf("file values not applied: %+v", c)
+	}
+	if c.GRPCAddr != ":8000" || c.Database != "test.db" {
+		t.Errorf("flags must override env and file: %+v", c)
+	}
+	if c.Costs.Addition != 3 || c.Costs.Division != 7 || c.Costs.Multiplication != Default().Costs.Multiplication {
+		t.Errorf("costs = %+v, want env, file and default values merged", c.Costs)
+	}
+	if c.Logs != Default().Logs {
+		t.Errorf("logs = %+v, want defaults", c.Logs)
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	cases := []struct {
+		name, env, value string
+		args             []string
+		want             string
+	}{
+		{"env not a number", "PORT", "http", nil, "PORT"},
+		{"negative cost", "TIME_DIVISIONS_MS", "-1", nil, "costs"},
+		{"bad log level", "LOG_LEVEL", "verbose", nil, "log_level"},
+		{"flag not a number", "", "", []string{"-port", "x"}, "-port"},
+		{"port out of range", "", "", []string{"-port", "70000"}, "port"},
+		{"bad grpc address", "", "", []string{"-grpc-addr", "50051"}, "grpc_addr"},
+		{"duration without unit", "TASK_LEASE_TIMEOUT", "10", nil, "TASK_LEASE_TIMEOUT"},
+		{"zero heartbeat", "", "", []string{"-heartbeat-interval", "0s"}, "heartbeat_interval"},
+		{"bad weights", "SCHEDULER_WEIGHTS", "1=0", nil, "SCHEDULER_WEIGHTS"},
+		{"rate above one", "VERIFICATION_RATE", "1.5", nil, "verification_rate"},
+		{"rate not a number", "VERIFICATION_RATE", "half", nil, "VERIFICATION_RATE"},
+		{"zero batch", "", "", []string{"-max-batch-size", "0"}, "max_batch_size"},
+		{"negative expression timeout", "EXPRESSION_TIMEOUT", "-1s", nil, "expression_timeout"},
+		{"client CA without certificate", "GRPC_TLS_CLIENT_CA", "ca.pem", nil, "client_ca"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("JWT_SECRET", "secret")
+			if tc.env != "" {
+				t.Setenv(tc.env, tc.value)
+			}
+			_, err := Load(tc.args, io.Discard)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error = %v, want it to mention %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadRequiresSecret(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	if _, err := Load(nil, io.Discard); err == nil || !strings.Contains(err.Error(), "jwt_secret") {
+		t.Errorf("error = %v, want a missing secret", err)
+	}
+}
+
+func TestLoadRejectsUnknownFileFields(t *testing.T) {
+	path := writeConfig(t, `{"jwt_secret":"s","http_port":80}`)
+	if _, err := Load([]string{"-config", path}, io.Discard); err == nil || !strings.Contains(err.Error(), "http_port") {
+		t.Errorf("error = %v, want the unknown field named", err)
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	var usage strings.Builder
+	if _, err := Load([]string{"-help"}, &usage); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("error = %v, want flag.ErrHelp", err)
+	}
+	if !strings.Contains(usage.String(), "-grpc-addr") || !strings.Contains(usage.String(), "GRPC_ADDR") {
+		t.Errorf("usage does not describe flags:\n%s", usage.String())
+	}
+}
+
+func TestQuotasFromEnv(t *testing.T) {
+	t.Setenv("JWT_SECRET", "secret")
+	t.Setenv("QUOTA_MAX_CONCURRENT", "3")
+	c, err := Load(nil, io.Discard)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if c.Quotas.MaxConcurrent != 3 || c.Quotas.MaxOperations != Default().Quotas.MaxOperations {
+		t.Errorf("quotas = %+v", c.Quotas)
+	}
+	t.Setenv("QUOTA_MAX_OPERATIONS", "bad")
+	if _, err := Load(nil, io.Discard); err == nil || !strings.Contains(err.Error(), "QUOTA_MAX_OPERATIONS") {
+		t.Errorf("error = %v, want the bad quota named", err)
+	}
+}
+
+func TestSchedulerAndAgentSettings(t *testing.T) {
+	path := writeConfig(t, `{"jwt_secret":"s","expression_timeout":"1m","scheduler":{"weights":{"1":3},"verification_rate":0.5},"agents":{"token":"t","lease_timeout":"20s","tls":{"cert":"c.pem","key":"k.pem"}}}`)
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("MAX_TASK_ATTEMPTS", "2")
+	t.Setenv("STRAGGLER_FACTOR", "0")
+	c, err := Load([]string{"-config", path, "-lease-timeout", "30s"}, io.Discard)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	queueOpts := c.Scheduler.Options()
+	if queueOpts.Weights[1] != 3 || queueOpts.VerificationRate != 0.5 || queueOpts.MaxAttempts != 2 || queueOpts.Aging != Default().Scheduler.Options().Aging {
+		t.Errorf("queue options = %+v", queueOpts)
+	}
+	agentOpts := c.Agents.Options()
+	if agentOpts.LeaseTimeout != 30*time.Second || agentOpts.StragglerFactor != 0 || agentOpts.Token != "t" || agentOpts.TLS.CertFile != "c.pem" {
+		t.Errorf("agent options = %+v", agentOpts)
+	}
+	if c.ExpressionTimeout != Duration(time.Minute) {
+		t.Errorf("expression_timeout = %v, want 1m", c.ExpressionTimeout)
+	}
+	if c.Redacted().Agents.Token == "t" {
+		t.Error("Redacted kept the agent token")
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	path := writeConfig(t, `{"jwt_secret":"s","log_level":"INFO"}`)
+	c, err := Load([]string{"-config", path, "-port", "9000"}, io.Discard)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	os.WriteFile(path, []byte(`{"jwt_secret":"s2","log_level":"DEBUG","grpc_addr":":6000","costs":{"addition_ms":1}}`), 0o600)
+	next, err := c.Reload()
+	if err != nil {
+		t.Fatalf("Reload error: %v", err)
+	}
+	if next.Port != 9000 {
+		t.Errorf("Reload lost the command-line flags: port = %d", next.Port)
+	}
+	applied, restart := c.Reloadable(next)
+	if applied.LogLevel != "DEBUG" || applied.Costs.Addition != 1 {
+		t.Errorf("reloadable settings not applied: %+v", applied)
+	}
+	if applied.GRPCAddr != c.GRPCAddr || applied.JWTSecret != "s" {
+		t.Errorf("settings that need a restart changed: %+v", applied)
+	}
+	if strings.Join(restart, ",") != "grpc_addr,jwt_secret" {
+		t.Errorf("restart = %v, want grpc_addr and jwt_secret", restart)
+	}
+	if applied.Redacted().JWTSecret == "s" {
+		t.Error("Redacted kept the secret")
+	}
+}
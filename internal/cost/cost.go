@@ -0,0 +1,116 @@
+package cost
+
+import (
+	"fmt"
+	"sync"
+)
+
+// OperationCostModel сообщает, сколько миллисекунд агент тратит на операцию
+// в выражении пользователя. Это время уходит агенту в задаче и определяет
+// срок аренды задачи.
+type OperationCostModel interface {
+	Cost(userID uint, operation string) (int, error)
+}
+
+// Costs — время операций в миллисекундах.
+type Costs struct {
+	Addition       int `json:"addition_ms"`
+	Subtraction    int `json:"subtraction_ms"`
+	Multiplication int `json:"multiplication_ms"`
+	Division       int `json:"division_ms"`
+}
+
+func (c Costs) Validate() error {
+	for _, op := range []string{"+", "-", "*", "/"} {
+		if ms, _ := c.For(op); ms < 0 {
+			return fmt.Errorf("cost of %s must not be negative", op)
+		}
+	}
+	return nil
+}
+
+// For возвращает время операции; для неизвестного оператора — ошибку.
+func (c Costs) For(operation string) (int, error) {
+	switch operation {
+	case "+":
+		return c.Addition, nil
+	case "-":
+		return c.Subtraction, nil
+	case "*":
+		return c.Multiplication, nil
+	case "/":
+		return c.Division, nil
+	}
+	return 0, fmt.Errorf("unknown operator: %s", operation)
+}
+
+const defaultCost = 1000
+
+// Defaults — время операций, если оно не задано в конфигурации.
+var Defaults = Costs{
+	Addition:       defaultCost,
+	Subtraction:    defaultCost,
+	Multiplication: defaultCost,
+	Division:       defaultCost,
+}
+
+// Model — OperationCostModel с общим временем операций и персональным
+// временем для отдельных пользователей. И то, и другое можно менять на ходу:
+// новое время получают задачи, созданные после изменения.
+type Model struct {
+	mu        sync.Mutex
+	defaults  Costs
+	overrides map[uint]Costs
+}
+
+func NewModel(defaults Costs) *Model {
+	return &Model{defaults: defaults, overrides: make(map[uint]Costs)}
+}
+
+func (m *Model) Cost(userID uint, operation string) (int, error) {
+	costs, _ := m.Costs(userID)
+	return costs.For(operation)
+}
+
+// Costs возвращает действующее время операций пользователя и признак персональной настройки.
+func (m *Model) Costs(userID uint) (Costs, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.overrides[userID]; ok {
+		return c, true
+	}
+	return m.defaults, false
+}
+
+func (m *Model) Defaults() Costs {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.defaults
+}
+
+func (m *Model) SetDefaults(costs Costs) error {
+	if err := costs.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults = costs
+	return nil
+}
+
+func (m *Model) Set(userID uint, costs Costs) error {
+	if err := costs.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overrides[userID] = costs
+	return nil
+}
+
+// Reset возвращает пользователя к общему времени операций.
+func (m *Model) Reset(userID uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.overrides, userID)
+}
@@ -0,0 +1,32 @@
+package cost
+
+import "testing"
+
+func TestModelOverrides(t *testing.T) {
+	m := NewModel(Defaults)
+	if err := m.Set(7, Costs{Addition: 1, Subtraction: 2, Multiplication: 3, Division: 4}); err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+	if ms, err := m.Cost(7, "*"); err != nil || ms != 3 {
+		t.Errorf("Cost(7, *) = %d, %v, want 3", ms, err)
+	}
+	if ms, _ := m.Cost(8, "*"); ms != defaultCost {
+		t.Errorf("Cost(8, *) = %d, want the default", ms)
+	}
+	if err := m.SetDefaults(Costs{Multiplication: 50}); err != nil {
+		t.Fatalf("SetDefaults error: %v", err)
+	}
+	if ms, _ := m.Cost(8, "*"); ms != 50 {
+		t.Errorf("Cost(8, *) = %d after SetDefaults, want 50", ms)
+	}
+	m.Reset(7)
+	if ms, _ := m.Cost(7, "*"); ms != 50 {
+		t.Errorf("Cost(7, *) = %d after Reset, want 50", ms)
+	}
+	if _, err := m.Cost(7, "^"); err == nil {
+		t.Error("Cost accepted an unknown operator")
+	}
+	if err := m.Set(7, Costs{Division: -1}); err == nil {
+		t.Error("Set accepted a negative cost")
+	}
+}
@@ -8,6 +8,10 @@ func (s DBStore) UpdateExpressionStatus(id string, status string) error {
 	return UpdateExpressionStatus(id, status)
 }
 
+func (s DBStore) UpdateActiveExpressionStatus(id string, status string) (bool, error) {
+	return UpdateActiveExpressionStatus(id, status)
+}
+
 func (s DBStore) GetExpressionByID(id string) (*global.ExpressionDTO, error) {
 	return GetExpressionByID(id)
 }
@@ -15,3 +19,28 @@ func (s DBStore) GetExpressionByID(id string) (*global.ExpressionDTO, error) {
 func (s DBStore) UpdateExpressionResult(id string, res float64) error {
 	return UpdateExpressionResult(id, res)
 }
+
+func (s DBStore) GetTaskRecords(expressionID string) ([]global.TaskRecord, error) {
+	return GetTasksByExpression(expressionID)
+}
+
+func (s DBStore) SaveTaskRecord(record global.TaskRecord) error {
+	return SaveTask(record)
+}
+
+func (s DBStore) SaveDeadLetter(letter global.DeadLetter) error {
+	_, err := SaveDeadLetter(letter)
+	return err
+}
+
+func (s DBStore) GetExpressionsByStatus(status string) ([]global.ExpressionDTO, error) {
+	expressions, err := GetExpressionsByStatus(status)
+	if err != nil {
+		return nil, err
+	}
+	dtos := make([]global.ExpressionDTO, 0, len(expressions))
+	for _, expression := range expressions {
+		dtos = append(dtos, expression.ToDTO())
+	}
+	return dtos, nil
+}
@@ -1,7 +1,6 @@
 package database
 
 import (
-	"calculator/pkg/calculator"
 	"calculator/pkg/loggers"
 
 	"gorm.io/driver/sqlite"
@@ -10,28 +9,17 @@ import (
 
 var DB *gorm.DB
 
-func Init() {
+func Init(path string) {
 	var err error
 	logger := loggers.GetLogger("general")
-	DB, err = gorm.Open(sqlite.Open("sqlite.db?_pragma=foreign_keys(ON)"), &gorm.Config{})
+	DB, err = gorm.Open(sqlite.Open(path+"?_pragma=foreign_keys(ON)"), &gorm.Config{})
 	if err != nil {
 		logger.Error("failed to connect to DB", "err", err)
 		panic(err)
 	}
-	if err = DB.AutoMigrate(&Expression{}, &User{}); err != nil {
+	if err = DB.AutoMigrate(&Expression{}, &User{}, &Task{}, &DeadLetter{}); err != nil {
 		logger.Error("failed to connect to DB", "err", err)
 		panic(err)
 	}
-	var expressions []Expression
-	err = DB.Find(&expressions).Error
-	if err != nil {
-		logger.Error("failed to connect to DB", "err", err)
-		panic(err)
-	}
-	for _, expression := range expressions {
-		if expression.Status == "processing" {
-			go calculator.Calc(DBStore{}, expression.ID)
-		}
-	}
 	logger.Info("database initialized")
 }
@@ -2,7 +2,9 @@ package database_test
 
 import (
 	"calculator/internal/database"
+	"calculator/internal/global"
 	"testing"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -14,7 +16,7 @@ func setupTestDB(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to open in-memory db: %v", err)
 	}
-	if err := database.DB.AutoMigrate(&database.User{}, &database.Expression{}); err != nil {
+	if err := database.DB.AutoMigrate(&database.User{}, &database.Expression{}, &database.Task{}, &database.DeadLetter{}); err != nil {
 		t.Fatalf("failed to migrate models: %v", err)
 	}
 }
@@ -83,6 +85,18 @@ func TestCreateAndGetExpression(t *testing.T) {
 	}
 }
 
+func TestExpressionDefaultPriority(t *testing.T) {
+	setupTestDB(t)
+	database.CreateExpression(&database.Expression{ID: "p1", UserID: 1, Data: "1+1", Status: "pending"})
+	database.CreateExpression(&database.Expression{ID: "p2", UserID: 1, Data: "1+1", Status: "pending", Priority: global.PriorityLow})
+	if dto, _ := database.GetExpressionByID("p1"); dto.Priority != global.PriorityNormal {
+		t.Errorf("default Priority = %d, want %d", dto.Priority, global.PriorityNormal)
+	}
+	if dto, _ := database.GetExpressionByID("p2"); dto.Priority != global.PriorityLow {
+		t.Errorf("Priority = %d, want %d", dto.Priority, global.PriorityLow)
+	}
+}
+
 func TestUpdateExpressionStatusAndResult(t *testing.T) {
 	setupTestDB(t)
 	expr := &database.Expression{ID: "e2", UserID: 2, Data: "3*3", Status: "pending", Result: 0}
@@ -105,6 +119,34 @@ func TestUpdateExpressionStatusAndResult(t *testing.T) {
 	}
 }
 
+func TestUpdateActiveExpressionStatus(t *testing.T) {
+	setupTestDB(t)
+	database.CreateExpression(&database.Expression{ID: "e3", UserID: 2, Data: "1+1", Status: "processing"})
+	if ok, err := database.UpdateActiveExpressionStatus("e3", "cancelled"); !ok || err != nil {
+		t.Fatalf("UpdateActiveExpressionStatus = %v, %v, want the active expression updated", ok, err)
+	}
+	if ok, err := database.UpdateActiveExpressionStatus("e3", "completed"); ok || err != nil {
+		t.Errorf("UpdateActiveExpressionStatus = %v, %v, want a cancelled expression left alone", ok, err)
+	}
+	if dto, _ := database.GetExpressionByID("e3"); dto.Status != "cancelled" {
+		t.Errorf("Status = %q, want cancelled", dto.Status)
+	}
+}
+
+func TestRestartExpression(t *testing.T) {
+	setupTestDB(t)
+	database.CreateExpression(&database.Expression{ID: "e4", UserID: 2, Data: "1/0", Status: "calculation error"})
+	if ok, err := database.RestartExpression("e4"); !ok || err != nil {
+		t.Fatalf("RestartExpression = %v, %v, want the failed expression restarted", ok, err)
+	}
+	if ok, err := database.RestartExpression("e4"); ok || err != nil {
+		t.Errorf("RestartExpression = %v, %v, want a pending expression left alone", ok, err)
+	}
+	if dto, _ := database.GetExpressionByID("e4"); dto.Status != "pending" {
+		t.Errorf("Status = %q, want pending", dto.Status)
+	}
+}
+
 func TestGetAllExpressions(t *testing.T) {
 	setupTestDB(t)
 	database.CreateExpression(&database.Expression{ID: "a1", UserID: 1, Data: "x", Status: "s", Result: 0})
@@ -124,6 +166,44 @@ func TestGetAllExpressions(t *testing.T) {
 	}
 }
 
+func TestCountActiveExpressions(t *testing.T) {
+	setupTestDB(t)
+	database.CreateExpression(&database.Expression{ID: "c1", UserID: 1, Data: "1+1", Status: "pending"})
+	database.CreateExpression(&database.Expression{ID: "c2", UserID: 1, Data: "1+1", Status: "processing"})
+	database.CreateExpression(&database.Expression{ID: "c3", UserID: 1, Data: "1+1", Status: "completed"})
+	database.CreateExpression(&database.Expression{ID: "c4", UserID: 2, Data: "1+1", Status: "pending"})
+	count, err := database.CountActiveExpressions(1)
+	if err != nil {
+		t.Fatalf("CountActiveExpressions error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("count = %d, want 2", count)
+	}
+}
+
+func TestSaveAndGetTasks(t *testing.T) {
+	setupTestDB(t)
+	database.CreateExpression(&database.Expression{ID: "t1", UserID: 1, Data: "1+2*3", Status: "processing"})
+	database.SaveTask(global.TaskRecord{ExpressionID: "t1", Index: 1, ID: "add", Operation: "+", Arg1: 1, Arg2: 6})
+	database.SaveTask(global.TaskRecord{ExpressionID: "t1", Index: 0, ID: "mul", Operation: "*", Arg1: 2, Arg2: 3})
+	if err := database.SaveTask(global.TaskRecord{ExpressionID: "t1", Index: 0, ID: "mul", Operation: "*", Arg1: 2, Arg2: 3, Solved: true, Result: 6}); err != nil {
+		t.Fatalf("SaveTask update error: %v", err)
+	}
+	records, err := database.GetTasksByExpression("t1")
+	if err != nil {
+		t.Fatalf("GetTasksByExpression error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("len = %d, want 2", len(records))
+	}
+	if records[0].ID != "mul" || !records[0].Solved || records[0].Result != 6 {
+		t.Errorf("records[0] = %+v, want solved multiplication", records[0])
+	}
+	if records[1].ID != "add" || records[1].Solved {
+		t.Errorf("records[1] = %+v, want unfinished addition", records[1])
+	}
+}
+
 func TestDBStoreAdapter(t *testing.T) {
 	setupTestDB(t)
 	expr := &database.Expression{ID: "d1", UserID: 3, Data: "1+1", Status: "p", Result: 0}
@@ -143,3 +223,29 @@ func TestDBStoreAdapter(t *testing.T) {
 		t.Errorf("Adapter DTO = %+v, want status 'ok' and result 2", dto)
 	}
 }
+
+func TestDeadLetters(t *testing.T) {
+	setupTestDB(t)
+	history := []global.Attempt{{Agent: "a1", Reason: "lease expired", At: time.Now().UTC()}}
+	id, err := database.SaveDeadLetter(global.DeadLetter{TaskID: "t1", ExpressionID: "e1", Operation: "/", Attempts: 1, History: history, Error: "task t1 failed 1 attempts"})
+	if err != nil {
+		t.Fatalf("SaveDeadLetter error: %v", err)
+	}
+	letter, err := database.GetDeadLetter(id)
+	if err != nil {
+		t.Fatalf("GetDeadLetter error: %v", err)
+	}
+	if letter.TaskID != "t1" || len(letter.History) != 1 || letter.History[0].Agent != "a1" || letter.ReplayedAt != nil {
+		t.Errorf("letter = %+v", letter)
+	}
+	if marked, err := database.MarkDeadLetterReplayed(id, time.Now()); err != nil || !marked {
+		t.Fatalf("MarkDeadLetterReplayed = %v, %v, want marked", marked, err)
+	}
+	if marked, err := database.MarkDeadLetterReplayed(id, time.Now()); err != nil || marked {
+		t.Errorf("second MarkDeadLetterReplayed = %v, %v, want not marked", marked, err)
+	}
+	letters, err := database.GetDeadLetters()
+	if err != nil || len(letters) != 1 || letters[0].ReplayedAt == nil {
+		t.Errorf("GetDeadLetters = %+v, %v, want one replayed letter", letters, err)
+	}
+}
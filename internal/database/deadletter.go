@@ -0,0 +1,92 @@
+package database
+
+import (
+	"calculator/internal/global"
+	"encoding/json"
+	"time"
+)
+
+// DeadLetter — задача, снятая с очереди после исчерпания попыток. История
+// попыток хранится в JSON.
+type DeadLetter struct {
+	ID           uint   `gorm:"primaryKey"`
+	TaskID       string `gorm:"not null"`
+	ExpressionID string `gorm:"not null;index"`
+	UserID       uint
+	Operation    string `gorm:"not null"`
+	Arg1         float64
+	Arg2         float64
+	Attempts     int
+	History      string
+	Error        string
+	CreatedAt    time.Time
+	ReplayedAt   *time.Time
+}
+
+func (d *DeadLetter) ToDTO() global.DeadLetter {
+	letter := global.DeadLetter{
+		ID:           d.ID,
+		TaskID:       d.TaskID,
+		ExpressionID: d.ExpressionID,
+		UserID:       d.UserID,
+		Operation:    d.Operation,
+		Arg1:         d.Arg1,
+		Arg2:         d.Arg2,
+		Attempts:     d.Attempts,
+		Error:        d.Error,
+		CreatedAt:    d.CreatedAt,
+		ReplayedAt:   d.ReplayedAt,
+	}
+	json.Unmarshal([]byte(d.History), &letter.History)
+	return letter
+}
+
+func SaveDeadLetter(letter global.DeadLetter) (uint, error) {
+	history, err := json.Marshal(letter.History)
+	if err != nil {
+		return 0, err
+	}
+	record := DeadLetter{
+		TaskID:       letter.TaskID,
+		ExpressionID: letter.ExpressionID,
+		UserID:       letter.UserID,
+		Operation:    letter.Operation,
+		Arg1:         letter.Arg1,
+		Arg2:         letter.Arg2,
+		Attempts:     letter.Attempts,
+		History:      string(history),
+		Error:        letter.Error,
+	}
+	if err := DB.Create(&record).Error; err != nil {
+		return 0, err
+	}
+	return record.ID, nil
+}
+
+func GetDeadLetters() ([]global.DeadLetter, error) {
+	var records []DeadLetter
+	if err := DB.Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	letters := make([]global.DeadLetter, 0, len(records))
+	for _, record := range records {
+		letters = append(letters, record.ToDTO())
+	}
+	return letters, nil
+}
+
+func GetDeadLetter(id uint) (*global.DeadLetter, error) {
+	var record DeadLetter
+	if err := DB.First(&record, id).Error; err != nil {
+		return nil, err
+	}
+	letter := record.ToDTO()
+	return &letter, nil
+}
+
+// MarkDeadLetterReplayed отмечает, что задачу запустили заново, одним условным
+// UPDATE. false — задачу уже запустили раньше.
+func MarkDeadLetterReplayed(id uint, at time.Time) (bool, error) {
+	res := DB.Model(&DeadLetter{}).Where("id = ? AND replayed_at IS NULL", id).Update("replayed_at", at)
+	return res.RowsAffected > 0, res.Error
+}
@@ -2,19 +2,30 @@ package database
 
 import (
 	"calculator/internal/global"
+	"time"
 )
 
 type Expression struct {
-	ID     string `gorm:"primaryKey"`
-	UserID uint
-	User   User    `gorm:"constraint:OnDelete:CASCADE"`
-	Data   string  `gorm:"not null"`
-	Status string  `gorm:"not null"`
-	Result float64 `gorm:"not null"`
+	ID        string `gorm:"primaryKey"`
+	UserID    uint
+	User      User    `gorm:"constraint:OnDelete:CASCADE"`
+	Data      string  `gorm:"not null"`
+	Status    string  `gorm:"not null"`
+	Result    float64 `gorm:"not null"`
+	TimeoutMS int64
+	Priority  int `gorm:"not null;default:2"`
 }
 
 func (e *Expression) ToDTO() global.ExpressionDTO {
-	return global.ExpressionDTO{ID: e.ID, UserID: e.UserID, Data: e.Data, Status: e.Status, Result: e.Result}
+	return global.ExpressionDTO{
+		ID:       e.ID,
+		UserID:   e.UserID,
+		Data:     e.Data,
+		Status:   e.Status,
+		Result:   e.Result,
+		Timeout:  time.Duration(e.TimeoutMS) * time.Millisecond,
+		Priority: e.Priority,
+	}
 }
 
 func CreateExpression(expr *Expression) error {
@@ -35,6 +46,23 @@ func UpdateExpressionStatus(id string, status string) error {
 	return DB.Model(&Expression{}).Where("id = ?", id).Update("status", status).Error
 }
 
+// activeStatuses — статусы выражения, которое ещё ждёт или вычисляется.
+var activeStatuses = []string{"pending", "processing"}
+
+// UpdateActiveExpressionStatus меняет статус одним условным UPDATE, только если
+// выражение ещё активно. false — выражение уже завершено или отменено.
+func UpdateActiveExpressionStatus(id string, status string) (bool, error) {
+	res := DB.Model(&Expression{}).Where("id = ? AND status IN ?", id, activeStatuses).Update("status", status)
+	return res.RowsAffected > 0, res.Error
+}
+
+// RestartExpression возвращает завершённое выражение в "pending" одним условным
+// UPDATE. false — выражение уже ждёт или вычисляется.
+func RestartExpression(id string) (bool, error) {
+	res := DB.Model(&Expression{}).Where("id = ? AND status NOT IN ?", id, activeStatuses).Update("status", "pending")
+	return res.RowsAffected > 0, res.Error
+}
+
 func UpdateExpressionResult(id string, result float64) error {
 	return DB.Model(&Expression{}).Where("id = ?", id).Update("result", result).Error
 }
@@ -44,3 +72,18 @@ func GetAllExpressions(userID uint) ([]Expression, error) {
 	err := DB.Find(&expressions, "user_id = ?", userID).Error
 	return expressions, err
 }
+
+func GetExpressionsByStatus(status string) ([]Expression, error) {
+	var expressions []Expression
+	err := DB.Find(&expressions, "status = ?", status).Error
+	return expressions, err
+}
+
+// CountActiveExpressions — сколько выражений пользователя ещё ждут или вычисляются.
+func CountActiveExpressions(userID uint) (int, error) {
+	var count int64
+	err := DB.Model(&Expression{}).
+		Where("user_id = ? AND status IN ?", userID, activeStatuses).
+		Count(&count).Error
+	return int(count), err
+}
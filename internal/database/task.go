@@ -0,0 +1,60 @@
+package database
+
+import (
+	"calculator/internal/global"
+
+	"gorm.io/gorm/clause"
+)
+
+// Task — задача выражения; решённые задачи не пересчитываются после перезапуска.
+type Task struct {
+	ExpressionID string     `gorm:"primaryKey"`
+	Expression   Expression `gorm:"constraint:OnDelete:CASCADE"`
+	OpIndex      int        `gorm:"primaryKey;autoIncrement:false"`
+	ID           string     `gorm:"not null"`
+	Operation    string     `gorm:"not null"`
+	Arg1         float64
+	Arg2         float64
+	Solved       bool `gorm:"not null;default:false"`
+	Result       float64
+}
+
+func (t *Task) ToRecord() global.TaskRecord {
+	return global.TaskRecord{
+		ExpressionID: t.ExpressionID,
+		Index:        t.OpIndex,
+		ID:           t.ID,
+		Operation:    t.Operation,
+		Arg1:         t.Arg1,
+		Arg2:         t.Arg2,
+		Solved:       t.Solved,
+		Result:       t.Result,
+	}
+}
+
+// SaveTask создаёт или обновляет задачу выражения.
+func SaveTask(record global.TaskRecord) error {
+	task := Task{
+		ExpressionID: record.ExpressionID,
+		OpIndex:      record.Index,
+		ID:           record.ID,
+		Operation:    record.Operation,
+		Arg1:         record.Arg1,
+		Arg2:         record.Arg2,
+		Solved:       record.Solved,
+		Result:       record.Result,
+	}
+	return DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&task).Error
+}
+
+func GetTasksByExpression(expressionID string) ([]global.TaskRecord, error) {
+	var tasks []Task
+	if err := DB.Order("op_index").Find(&tasks, "expression_id = ?", expressionID).Error; err != nil {
+		return nil, err
+	}
+	records := make([]global.TaskRecord, 0, len(tasks))
+	for _, task := range tasks {
+		records = append(records, task.ToRecord())
+	}
+	return records, nil
+}
@@ -1,9 +1,13 @@
 package database
 
+import "errors"
+
 type User struct {
 	ID       uint   `gorm:"primaryKey"`
 	Login    string `gorm:"not null;unique"`
 	Password string `gorm:"not null"        json:"-"`
+	// Admin даёт доступ к /api/v1/admin/...; при регистрации не выдаётся.
+	Admin bool `gorm:"not null;default:false"`
 }
 
 func (u *User) SetPassword(hash string) {
@@ -35,3 +39,17 @@ func GetUserByID(id uint) (*User, error) {
 	}
 	return &expr, nil
 }
+
+var ErrUserNotFound = errors.New("user not found")
+
+// SetAdmin выдаёт или отбирает права администратора пользователя с логином login.
+func SetAdmin(login string, admin bool) error {
+	res := DB.Model(&User{}).Where("login = ?", login).Update("admin", admin)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package global
+
+import (
+	"fmt"
+	"time"
+)
+
+// Attempt — неудачная попытка выполнить задачу: агент не вернул результат вовремя или пропал.
+type Attempt struct {
+	Agent  string    `json:"agent"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+// PoisonTaskError — задача исчерпала попытки и снята с очереди. Выражение с
+// такой задачей завершается ошибкой, а задача попадает в dead-letter.
+type PoisonTaskError struct {
+	Task    Task
+	History []Attempt
+}
+
+func (e *PoisonTaskError) Error() string {
+	return fmt.Sprintf("task %s failed %d attempts", e.Task.ID, len(e.History))
+}
+
+// DeadLetter — задача, снятая с очереди после исчерпания попыток, с историей ошибок.
+type DeadLetter struct {
+	ID           uint       `json:"id"`
+	TaskID       string     `json:"task_id"`
+	ExpressionID string     `json:"expression_id"`
+	UserID       uint       `json:"user_id"`
+	Operation    string     `json:"operation"`
+	Arg1         float64    `json:"arg1"`
+	Arg2         float64    `json:"arg2"`
+	Attempts     int        `json:"attempts"`
+	History      []Attempt  `json:"history"`
+	Error        string     `json:"error"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ReplayedAt   *time.Time `json:"replayed_at,omitempty"`
+}
@@ -1,6 +1,9 @@
 package global
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -39,78 +42,121 @@ func TestFutureGetBlocksUntilSet(t *testing.T) {
 	<-done
 }
 
-// TestFuturesMapStoreLoad проверяет работу глобальной карты FuturesMap.
-func TestFuturesMapStoreLoad(t *testing.T) {
-	// Очистим карту перед тестом
-	FuturesMap.Range(func(key, _ any) bool { FuturesMap.Delete(key); return true })
-	f := NewFuture()
-	FuturesMap.Store("k1", f) // sync.Map citeturn20file0
-	v, ok := FuturesMap.Load("k1")
-	if !ok {
-		t.Fatal("expected key k1 in FuturesMap")
-	}
-	f2, ok := v.(*Future)
-	if !ok {
-		t.Fatalf("value type = %T, want *Future", v)
-	}
-	f2.SetResult(1.23)
-	if got := f2.Get(); got != 1.23 {
-		t.Errorf("Get after load = %v, want %v", got, 1.23)
-	}
-}
-
-// TestTasksMapStoreLoad проверяет работу глобальной карты TasksMap.
-func TestTasksMapStoreLoad(t *testing.T) {
-	// Очистим карту перед тестом
-	TasksMap.Range(func(key, _ any) bool { TasksMap.Delete(key); return true })
-	task := &Task{ID: "t1", Arg1: 10, Arg2: 5, Operation: "*", OperationTime: 100}
-	TasksMap.Store("t1", task) // sync.Map citeturn20file0
-	v, ok := TasksMap.Load("t1")
-	if !ok {
-		t.Fatal("expected key t1 in TasksMap")
-	}
-	t2, ok := v.(*Task)
-	if !ok {
-		t.Fatalf("value type = %T, want *Task", v)
-	}
-	if t2.ID != task.ID || t2.Arg1 != task.Arg1 || t2.Arg2 != task.Arg2 || t2.Operation != task.Operation {
-		t.Errorf("loaded task = %+v, want %+v", t2, task)
-	}
-}
-
-// TestConcurrentAccess проверяет конкурентный доступ к картам.
+// TestConcurrentAccess проверяет конкурентную работу с Future.
 func TestConcurrentAccess(t *testing.T) {
-	// Очистим обе карты
-	FuturesMap.Range(func(k, _ any) bool { FuturesMap.Delete(k); return true })
-	TasksMap.Range(func(k, _ any) bool { TasksMap.Delete(k); return true })
-
 	var wg sync.WaitGroup
 	n := 100
 	wg.Add(n * 2)
 	for i := 0; i < n; i++ {
+		f := NewFuture()
 		go func(i int) {
 			defer wg.Done()
-			f := NewFuture()
-			FuturesMap.Store(i, f)
 			f.SetResult(float64(i))
-			if got := f.Get(); got != float64(i) {
-				t.Errorf("concurrent future %d = %v", i, got)
-			}
 		}(i)
 		go func(i int) {
 			defer wg.Done()
-			tk := &Task{ID: string(rune(i)), Arg1: 0, Arg2: 0, Operation: "+", OperationTime: 0}
-			TasksMap.Store(i, tk)
-			v, ok := TasksMap.Load(i)
-			if !ok {
-				t.Errorf("missing task %d", i)
-				return
-			}
-			// тоже просто проверяем тип
-			if _, ok := v.(*Task); !ok {
-				t.Errorf("task %d type = %T", i, v)
+			if got := f.Get(); got != float64(i) {
+				t.Errorf("concurrent future %d = %v", i, got)
 			}
 		}(i)
 	}
 	wg.Wait()
 }
+
+// TestTraceLifecycle проверяет, что узел трассировки проходит все стадии и сериализуется деревом.
+func TestTraceLifecycle(t *testing.T) {
+	trace := NewTrace()
+	root := trace.Operation("+", trace.Number(1), trace.Number(2))
+	trace.SetRoot(root)
+	root.Queued(&Task{ID: "t1", Arg1: 1, Arg2: 2, Operation: "+"})
+	root.Sent("agent-1")
+	root.Solved(3)
+
+	data, err := json.Marshal(trace)
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	var got TraceNode
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if got.TaskID != "t1" || got.Status != "solved" || got.Agent != "agent-1" || got.Result != 3 {
+		t.Errorf("root = %+v", got)
+	}
+	if got.SentAt == nil || got.SolvedAt == nil {
+		t.Errorf("timings not recorded: %+v", got)
+	}
+	if len(got.Children) != 2 || got.Children[0].Result != 1 || got.Children[1].Status != "value" {
+		t.Errorf("children = %+v", got.Children)
+	}
+}
+
+// TestFutureResolvesOnce проверяет, что повторный результат не перезаписывает первый.
+func TestFutureResolvesOnce(t *testing.T) {
+	f := NewFuture()
+	if !f.SetResult(1) {
+		t.Fatal("first SetResult must resolve the future")
+	}
+	if f.SetResult(2) || f.SetError(errors.New("late")) {
+		t.Error("second resolution must be ignored")
+	}
+	got, err := f.Wait(context.Background())
+	if got != 1 || err != nil {
+		t.Errorf("Wait() = %v, %v, want 1, nil", got, err)
+	}
+}
+
+// TestFutureWaitCancelled проверяет, что Wait возвращается при отмене контекста.
+func TestFutureWaitCancelled(t *testing.T) {
+	f := NewFuture()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() error = %v, want context.Canceled", err)
+	}
+	wantErr := errors.New("boom")
+	f.SetError(wantErr)
+	if _, err := f.Wait(context.Background()); err != wantErr {
+		t.Errorf("Wait() error = %v, want %v", err, wantErr)
+	}
+}
+
+// TestCancellationHub проверяет доставку отмен подписчикам.
+func TestCancellationHub(t *testing.T) {
+	hub := NewCancellationHub()
+	ch, unsubscribe := hub.Subscribe()
+	hub.Publish(Cancellation{ExpressionID: "e1", TaskIDs: []string{"t1"}})
+	select {
+	case c := <-ch:
+		if c.ExpressionID != "e1" || len(c.TaskIDs) != 1 {
+			t.Errorf("got %+v", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancellation was not delivered")
+	}
+	unsubscribe()
+	hub.Publish(Cancellation{ExpressionID: "e2"})
+	select {
+	case c := <-ch:
+		t.Errorf("unsubscribed channel received %+v", c)
+	default:
+	}
+}
+
+func TestTraceStoreEvictsFinished(t *testing.T) {
+	s := NewTraceStore(10 * time.Millisecond)
+	s.Store("running", NewTrace())
+	s.Store("done", NewTrace())
+	s.Finish("done")
+	if _, ok := s.Load("done"); !ok {
+		t.Fatal("trace removed before its TTL")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := s.Load("done"); ok {
+		t.Error("expired trace is still served")
+	}
+	s.Store("next", NewTrace())
+	if _, ok := s.Load("running"); !ok || s.Len() != 2 {
+		t.Errorf("Len = %d, want the expired trace evicted and the running one kept", s.Len())
+	}
+}
@@ -1,38 +1,246 @@
 package global
 
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
 type ExpressionDTO struct {
-	ID     string
-	UserID uint
-	Data   string
-	Status string
-	Result float64
+	ID       string
+	UserID   uint
+	Data     string
+	Status   string
+	Result   float64
+	Timeout  time.Duration
+	Priority int
+}
+
+// Классы приоритета начинаются с 1, чтобы нулевое значение не путалось с "low".
+const (
+	PriorityLow = iota + 1
+	PriorityNormal
+	PriorityHigh
+)
+
+var PriorityClasses = map[string]int{
+	"low":    PriorityLow,
+	"normal": PriorityNormal,
+	"high":   PriorityHigh,
 }
 
 type Task struct {
 	ID            string  `json:"id"`
+	ExpressionID  string  `json:"expression_id"`
+	UserID        uint    `json:"user_id"`
 	Arg1          float64 `json:"arg1"`
 	Arg2          float64 `json:"arg2"`
 	Operation     string  `json:"operation"`
 	OperationTime int     `json:"operation_time"`
+	Priority      int     `json:"priority"`
+	Attempts      int     `json:"attempts"`
+}
+
+// TaskRecord — сохранённое состояние задачи выражения. Index — номер операции
+// в RPN: по нему после перезапуска решённые задачи сопоставляются с операциями.
+type TaskRecord struct {
+	ExpressionID string
+	Index        int
+	ID           string
+	Operation    string
+	Arg1         float64
+	Arg2         float64
+	Solved       bool
+	Result       float64
+}
+
+// Lease — задача, выданная агенту: пока аренда не истекла, задача принадлежит ему.
+type Lease struct {
+	Task    *Task
+	Agent   string
+	Started time.Time
+	Expires time.Time
 }
 
 type Result struct {
 	value float64
+	err   error
 }
 
 type Future struct {
-	result chan Result
+	once   sync.Once
+	done   chan struct{}
+	result Result
 }
 
 func NewFuture() *Future {
-	return &Future{make(chan Result, 1)}
+	return &Future{done: make(chan struct{})}
+}
+
+// SetResult и SetError срабатывают только один раз: повторные результаты игнорируются.
+func (f *Future) SetResult(val float64) bool {
+	return f.resolve(Result{value: val})
+}
+
+func (f *Future) SetError(err error) bool {
+	return f.resolve(Result{err: err})
 }
 
-func (f *Future) SetResult(val float64) {
-	f.result <- Result{val}
+func (f *Future) resolve(res Result) bool {
+	resolved := false
+	f.once.Do(func() {
+		f.result = res
+		close(f.done)
+		resolved = true
+	})
+	return resolved
 }
 
 func (f *Future) Get() float64 {
-	res := <-f.result
-	return res.value
+	<-f.done
+	return f.result.value
+}
+
+func (f *Future) Wait(ctx context.Context) (float64, error) {
+	select {
+	case <-f.done:
+		return f.result.value, f.result.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+type Cancellation struct {
+	ExpressionID string
+	TaskIDs      []string
+}
+
+type CancellationHub struct {
+	mu          sync.Mutex
+	subscribers map[chan Cancellation]struct{}
+}
+
+func NewCancellationHub() *CancellationHub {
+	return &CancellationHub{subscribers: make(map[chan Cancellation]struct{})}
+}
+
+func (h *CancellationHub) Subscribe() (<-chan Cancellation, func()) {
+	ch := make(chan Cancellation, 64)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers, ch)
+		h.mu.Unlock()
+	}
+}
+
+// Publish не блокируется: если подписчик не успевает читать, отмена для него
+// теряется, а поздний результат задачи всё равно будет отброшен оркестратором.
+func (h *CancellationHub) Publish(c Cancellation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- c:
+		default:
+		}
+	}
+}
+
+type TraceNode struct {
+	TaskID    string       `json:"task_id,omitempty"`
+	Operation string       `json:"operation,omitempty"`
+	Args      []float64    `json:"args,omitempty"`
+	Result    float64      `json:"result"`
+	Status    string       `json:"status"`
+	Agent     string       `json:"agent,omitempty"`
+	QueuedAt  *time.Time   `json:"queued_at,omitempty"`
+	SentAt    *time.Time   `json:"sent_at,omitempty"`
+	SolvedAt  *time.Time   `json:"solved_at,omitempty"`
+	Duration  int64        `json:"duration_ms,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Children  []*TraceNode `json:"children,omitempty"`
+	trace     *Trace
+}
+
+type Trace struct {
+	mu   sync.Mutex
+	root *TraceNode
+}
+
+func NewTrace() *Trace {
+	return &Trace{}
+}
+
+func (t *Trace) Number(val float64) *TraceNode {
+	return &TraceNode{Result: val, Status: "value", trace: t}
+}
+
+func (t *Trace) Operation(op string, left, right *TraceNode) *TraceNode {
+	return &TraceNode{Operation: op, Status: "waiting", Children: []*TraceNode{left, right}, trace: t}
+}
+
+func (t *Trace) SetRoot(root *TraceNode) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.root = root
+}
+
+func (t *Trace) MarshalJSON() ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return json.Marshal(t.root)
+}
+
+func (n *TraceNode) Queued(task *Task) {
+	n.trace.mu.Lock()
+	defer n.trace.mu.Unlock()
+	now := time.Now()
+	n.TaskID = task.ID
+	n.Args = []float64{task.Arg1, task.Arg2}
+	n.Status = "queued"
+	n.QueuedAt = &now
+}
+
+func (n *TraceNode) Sent(agent string) {
+	n.trace.mu.Lock()
+	defer n.trace.mu.Unlock()
+	now := time.Now()
+	n.Agent = agent
+	n.Status = "sent"
+	n.SentAt = &now
+}
+
+// Restored отмечает операцию, результат которой восстановлен из базы после перезапуска.
+func (n *TraceNode) Restored(record TaskRecord) {
+	n.trace.mu.Lock()
+	defer n.trace.mu.Unlock()
+	n.TaskID = record.ID
+	n.Args = []float64{record.Arg1, record.Arg2}
+	n.Result = record.Result
+	n.Status = "restored"
+}
+
+func (n *TraceNode) Solved(result float64) {
+	n.trace.mu.Lock()
+	defer n.trace.mu.Unlock()
+	now := time.Now()
+	n.Result = result
+	n.Status = "solved"
+	n.SolvedAt = &now
+	if n.SentAt != nil {
+		n.Duration = now.Sub(*n.SentAt).Milliseconds()
+	}
+}
+
+func (n *TraceNode) Failed(err error) {
+	n.trace.mu.Lock()
+	defer n.trace.mu.Unlock()
+	now := time.Now()
+	n.Error = err.Error()
+	n.Status = "failed"
+	n.SolvedAt = &now
 }
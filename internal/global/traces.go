@@ -0,0 +1,81 @@
+package global
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultTraceTTL — сколько хранится дерево вычислений после завершения выражения.
+const DefaultTraceTTL = time.Hour
+
+// TraceStore хранит деревья вычислений по ID выражения. Дерево выражения,
+// которое ещё вычисляется, хранится без срока; после Finish — ещё ttl, затем
+// удаляется при очередном Store.
+type TraceStore struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	traces map[string]*storedTrace
+}
+
+type storedTrace struct {
+	trace *Trace
+	// expires — когда дерево можно удалить; нулевое, пока выражение вычисляется.
+	expires time.Time
+}
+
+func NewTraceStore(ttl time.Duration) *TraceStore {
+	return &TraceStore{ttl: ttl, traces: make(map[string]*storedTrace)}
+}
+
+// SetTTL меняет срок хранения для выражений, которые завершатся после вызова.
+func (s *TraceStore) SetTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttl = ttl
+}
+
+func (s *TraceStore) Store(id string, trace *Trace) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(time.Now())
+	s.traces[id] = &storedTrace{trace: trace}
+}
+
+func (s *TraceStore) Load(id string) (*Trace, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.traces[id]
+	if !ok || (!stored.expires.IsZero() && time.Now().After(stored.expires)) {
+		return nil, false
+	}
+	return stored.trace, true
+}
+
+// Finish запускает отсчёт срока хранения дерева завершённого выражения.
+func (s *TraceStore) Finish(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.traces[id]; ok {
+		stored.expires = time.Now().Add(s.ttl)
+	}
+}
+
+func (s *TraceStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.traces, id)
+}
+
+func (s *TraceStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.traces)
+}
+
+func (s *TraceStore) evict(now time.Time) {
+	for id, stored := range s.traces {
+		if !stored.expires.IsZero() && now.After(stored.expires) {
+			delete(s.traces, id)
+		}
+	}
+}
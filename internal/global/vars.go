@@ -1,8 +1,8 @@
 package global
 
-import "sync"
-
 var (
-	TasksMap   sync.Map
-	FuturesMap sync.Map
+	// TracesMap хранит дерево вычислений по ID выражения.
+	TracesMap = NewTraceStore(DefaultTraceTTL)
+
+	Cancellations = NewCancellationHub()
 )
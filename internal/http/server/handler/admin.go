@@ -0,0 +1,456 @@
+package handler
+
+import (
+	"calculator/internal/config"
+	"calculator/internal/cost"
+	"calculator/internal/database"
+	"calculator/internal/queue"
+	"calculator/internal/quota"
+	"calculator/internal/recovery"
+	rpcserver "calculator/internal/rpc"
+	"calculator/pkg/calculator"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type queueStats struct {
+	Pending     int   `json:"pending"`
+	Leased      int   `json:"leased"`
+	OldestAgeMS int64 `json:"oldest_age_ms"`
+}
+
+type schedulerResponse struct {
+	Queue queueStats        `json:"queue"`
+	Users []queue.UserShare `json:"users"`
+}
+
+type weightRequest struct {
+	Weight int `json:"weight"`
+}
+
+func schedulerHandler(tasks *queue.Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only GET method is allowed"})
+			return
+		}
+		stats := tasks.Stats()
+		json.NewEncoder(w).Encode(schedulerResponse{
+			Queue: queueStats{stats.Pending, stats.Leased, stats.OldestAge.Milliseconds()},
+			Users: tasks.Shares(),
+		})
+	}
+}
+
+func schedulerWeightHandler(tasks *queue.Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only PUT method is allowed"})
+			return
+		}
+		userID, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/scheduler/weights/"), 10, 0)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(errorData{Error: "invalid user ID"})
+			return
+		}
+		var data weightRequest
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(errorData{Error: "invalid JSON"})
+			return
+		}
+		if data.Weight < 1 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(errorData{Error: "weight must be a positive integer"})
+			return
+		}
+		tasks.SetWeight(uint(userID), data.Weight)
+		for _, share := range tasks.Shares() {
+			if share.UserID == uint(userID) {
+				json.NewEncoder(w).Encode(share)
+				return
+			}
+		}
+	}
+}
+
+type quotaResponse struct {
+	UserID          uint         `json:"user_id"`
+	Limits          quota.Limits `json:"limits"`
+	Custom          bool         `json:"custom"`
+	Active          int          `json:"active"`
+	TasksLastMinute int          `json:"tasks_last_minute"`
+}
+
+func quotaHandler(quotas *quota.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		userID, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/quotas/"), 10, 0)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(errorData{Error: "invalid user ID"})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var limits quota.Limits
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&limits); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(errorData{Error: "invalid or unknown fields"})
+				return
+			}
+			if err := quotas.Set(uint(userID), limits); err != nil {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(errorData{Error: err.Error()})
+				return
+			}
+		case http.MethodDelete:
+			quotas.Reset(uint(userID))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only GET, PUT and DELETE methods are allowed"})
+			return
+		}
+		active, err := database.CountActiveExpressions(uint(userID))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(errorData{Error: err.Error()})
+			return
+		}
+		limits, custom := quotas.Limits(uint(userID))
+		json.NewEncoder(w).Encode(quotaResponse{
+			UserID:          uint(userID),
+			Limits:          limits,
+			Custom:          custom,
+			Active:          active,
+			TasksLastMinute: quotas.Used(uint(userID), time.Now()),
+		})
+	}
+}
+
+// decodeCosts накладывает JSON на текущее время операций: не указанные в
+// запросе операции сохраняют прежнее время.
+func decodeCosts(w http.ResponseWriter, r *http.Request, costs *cost.Costs) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(costs); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(errorData{Error: "invalid or unknown fields"})
+		return false
+	}
+	return true
+}
+
+// costsHandler обрабатывает GET и PUT /api/v1/admin/costs — общее время операций.
+func costsHandler(costs *cost.Model) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			defaults := costs.Defaults()
+			if !decodeCosts(w, r, &defaults) {
+				return
+			}
+			if err := costs.SetDefaults(defaults); err != nil {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(errorData{Error: err.Error()})
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only GET and PUT methods are allowed"})
+			return
+		}
+		json.NewEncoder(w).Encode(costs.Defaults())
+	}
+}
+
+type costsResponse struct {
+	UserID uint       `json:"user_id"`
+	Costs  cost.Costs `json:"costs"`
+	Custom bool       `json:"custom"`
+}
+
+// userCostsHandler обрабатывает /api/v1/admin/costs/{userID}: персональное время операций пользователя.
+func userCostsHandler(costs *cost.Model) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		userID, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/costs/"), 10, 0)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(errorData{Error: "invalid user ID"})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			current, _ := costs.Costs(uint(userID))
+			if !decodeCosts(w, r, &current) {
+				return
+			}
+			if err := costs.Set(uint(userID), current); err != nil {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(errorData{Error: err.Error()})
+				return
+			}
+		case http.MethodDelete:
+			costs.Reset(uint(userID))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only GET, PUT and DELETE methods are allowed"})
+			return
+		}
+		current, custom := costs.Costs(uint(userID))
+		json.NewEncoder(w).Encode(costsResponse{UserID: uint(userID), Costs: current, Custom: custom})
+	}
+}
+
+// configHandler показывает действующую конфигурацию оркестратора (без секретов).
+func configHandler(settings func() config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only GET method is allowed"})
+			return
+		}
+		json.NewEncoder(w).Encode(settings())
+	}
+}
+
+func recoveryHandler(recovered *recovery.Recovery) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only GET method is allowed"})
+			return
+		}
+		json.NewEncoder(w).Encode(recovered.Summary())
+	}
+}
+
+type agentResponse struct {
+	rpcserver.Agent
+	InFlight  int     `json:"in_flight"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+func newAgentResponse(agent rpcserver.Agent, inflight map[string]int) agentResponse {
+	return agentResponse{Agent: agent, InFlight: inflight[agent.ID], ErrorRate: agent.ErrorRate()}
+}
+
+func agentsHandler(tasks *queue.Queue, agents *rpcserver.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only GET method is allowed"})
+			return
+		}
+		inflight := tasks.InFlight()
+		response := []agentResponse{}
+		for _, agent := range agents.List() {
+			response = append(response, newAgentResponse(agent, inflight))
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// agentActionHandler обрабатывает POST /api/v1/admin/agents/{id}/{drain|disable|enable}.
+func agentActionHandler(tasks *queue.Queue, agents *rpcserver.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only POST method is allowed"})
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/agents/"), "/")
+		if len(parts) != 2 || parts[0] == "" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(errorData{Error: "unknown resource"})
+			return
+		}
+		id, action := parts[0], parts[1]
+		states := map[string]string{
+			"drain":   rpcserver.StateDraining,
+			"disable": rpcserver.StateDisabled,
+			"enable":  rpcserver.StateActive,
+		}
+		state, ok := states[action]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(errorData{Error: "unknown action: " + action})
+			return
+		}
+		agent, ok := agents.SetState(id, state)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(errorData{Error: "there is no such agent"})
+			return
+		}
+		if state == rpcserver.StateDisabled {
+			tasks.ReleaseAgent(id)
+		}
+		json.NewEncoder(w).Encode(newAgentResponse(agent, tasks.InFlight()))
+	}
+}
+
+func deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(errorData{Error: "only GET method is allowed"})
+		return
+	}
+	letters, err := database.GetDeadLetters()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(errorData{Error: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(letters)
+}
+
+// deadLetterHandler обрабатывает GET /api/v1/admin/deadletters/{id} и
+// POST /api/v1/admin/deadletters/{id}/replay. Replay заново запускает выражение:
+// решённые задачи берутся из сохранённых записей, задача из dead-letter
+// снова попадает в очередь с чистым счётчиком попыток. Квоты пользователя при
+// replay не проверяются: повторный запуск — решение администратора, а
+// выражение уже было принято по квоте при отправке.
+func deadLetterHandler(tasks *queue.Queue, costs cost.OperationCostModel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/deadletters/"), "/")
+		if len(parts) > 2 || (len(parts) == 2 && parts[1] != "replay") {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(errorData{Error: "unknown resource"})
+			return
+		}
+		replay := len(parts) == 2
+		if replay && r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only POST method is allowed"})
+			return
+		}
+		if !replay && r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only GET method is allowed"})
+			return
+		}
+		id, err := strconv.ParseUint(parts[0], 10, 0)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(errorData{Error: "invalid dead letter ID"})
+			return
+		}
+		letter, err := database.GetDeadLetter(uint(id))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(errorData{Error: "there is no such dead letter"})
+			return
+		}
+		if !replay {
+			json.NewEncoder(w).Encode(letter)
+			return
+		}
+		if letter.ReplayedAt != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(errorData{Error: "dead letter has already been replayed"})
+			return
+		}
+		expression, err := database.GetExpressionByID(letter.ExpressionID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(errorData{Error: "there is no such expression"})
+			return
+		}
+		if expression.Status == "pending" || expression.Status == "processing" {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(errorData{Error: "expression is already " + expression.Status})
+			return
+		}
+		// Проверки выше могли устареть: одновременный replay пройдёт только
+		// один, так как оба UPDATE условные.
+		restarted, err := database.RestartExpression(expression.ID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(errorData{Error: err.Error()})
+			return
+		}
+		if !restarted {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(errorData{Error: "expression is already being calculated"})
+			return
+		}
+		now := time.Now()
+		marked, err := database.MarkDeadLetterReplayed(letter.ID, now)
+		if err != nil || !marked {
+			database.UpdateExpressionStatus(expression.ID, expression.Status)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(errorData{Error: err.Error()})
+			return
+		}
+		if !marked {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(errorData{Error: "dead letter has already been replayed"})
+			return
+		}
+		letter.ReplayedAt = &now
+		go calculator.Calc(database.DBStore{}, tasks, costs, expression.ID)
+		json.NewEncoder(w).Encode(letter)
+	}
+}
+
+type adminResponse struct {
+	Login string `json:"login"`
+	Admin bool   `json:"admin"`
+}
+
+// adminsHandler обрабатывает /api/v1/admin/users/{login}/admin: PUT выдаёт
+// пользователю права администратора, DELETE отбирает.
+func adminsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	login, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/users/"), "/admin")
+	if !ok || login == "" || strings.Contains(login, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(errorData{Error: "not found"})
+		return
+	}
+	var admin bool
+	switch r.Method {
+	case http.MethodPut:
+		admin = true
+	case http.MethodDelete:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(errorData{Error: "only PUT and DELETE methods are allowed"})
+		return
+	}
+	if err := database.SetAdmin(login, admin); errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(errorData{Error: "there is no such user"})
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(errorData{Error: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(adminResponse{Login: login, Admin: admin})
+}
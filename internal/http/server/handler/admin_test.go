@@ -0,0 +1,427 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"calculator/internal/config"
+	"calculator/internal/cost"
+	"calculator/internal/database"
+	"calculator/internal/global"
+	"calculator/internal/queue"
+	"calculator/internal/quota"
+	"calculator/internal/recovery"
+	rpcserver "calculator/internal/rpc"
+
+	"gorm.io/gorm"
+)
+
+func TestSchedulerHandler(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	tasks.Submit(&global.Task{ID: "a", UserID: 7, OperationTime: 10}, nil)
+	tasks.Submit(&global.Task{ID: "b", UserID: 7, OperationTime: 10}, nil)
+	tasks.Next(context.Background(), "agent", 0)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/scheduler", nil)
+	rr := httptest.NewRecorder()
+	schedulerHandler(tasks)(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	var resp schedulerResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Queue.Pending != 1 || resp.Queue.Leased != 1 {
+		t.Errorf("queue = %+v, want 1 pending and 1 leased", resp.Queue)
+	}
+	if len(resp.Users) != 1 || resp.Users[0].UserID != 7 || resp.Users[0].Dispatched != 1 || resp.Users[0].Share != 1 {
+		t.Errorf("users = %+v", resp.Users)
+	}
+}
+
+func TestSchedulerWeightHandler(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/scheduler/weights/5", bytes.NewBufferString(`{"weight":4}`))
+	rr := httptest.NewRecorder()
+	schedulerWeightHandler(tasks)(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rr.Code, rr.Body.String())
+	}
+	var share queue.UserShare
+	json.NewDecoder(rr.Body).Decode(&share)
+	if share.UserID != 5 || share.Weight != 4 {
+		t.Errorf("share = %+v, want user 5 with weight 4", share)
+	}
+
+	cases := []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodGet, "/api/v1/admin/scheduler/weights/5", "", http.StatusMethodNotAllowed},
+		{http.MethodPut, "/api/v1/admin/scheduler/weights/abc", `{"weight":1}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/v1/admin/scheduler/weights/5", `{bad`, http.StatusBadRequest},
+		{http.MethodPut, "/api/v1/admin/scheduler/weights/5", `{"weight":0}`, http.StatusUnprocessableEntity},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+		rr := httptest.NewRecorder()
+		schedulerWeightHandler(tasks)(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("%s %s %s -> %d, want %d", tc.method, tc.path, tc.body, rr.Code, tc.code)
+		}
+	}
+}
+
+func TestQuotaHandler(t *testing.T) {
+	setupTestDB(t)
+	database.DB.Create(&database.Expression{ID: "q1", UserID: 3, Data: "1+1", Status: "pending"})
+	quotas := quota.NewManager(quota.Defaults)
+
+	do := func(method, path, body string) (*httptest.ResponseRecorder, quotaResponse) {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		quotaHandler(quotas)(rr, req)
+		var resp quotaResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return rr, resp
+	}
+
+	rr, resp := do(http.MethodPut, "/api/v1/admin/quotas/3", `{"max_concurrent":1,"max_operations":4}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PUT -> %d, want 200", rr.Code)
+	}
+	if !resp.Custom || resp.Limits.MaxConcurrent != 1 || resp.Limits.MaxOperations != 4 || resp.Active != 1 {
+		t.Errorf("PUT resp = %+v", resp)
+	}
+	if _, resp = do(http.MethodGet, "/api/v1/admin/quotas/3", ""); !resp.Custom || resp.Limits.MaxConcurrent != 1 {
+		t.Errorf("GET resp = %+v", resp)
+	}
+	if _, resp = do(http.MethodDelete, "/api/v1/admin/quotas/3", ""); resp.Custom || resp.Limits != quota.Defaults {
+		t.Errorf("DELETE resp = %+v", resp)
+	}
+
+	cases := []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodPost, "/api/v1/admin/quotas/3", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/v1/admin/quotas/x", "", http.StatusBadRequest},
+		{http.MethodPut, "/api/v1/admin/quotas/3", `{"max_jobs":1}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/v1/admin/quotas/3", `{"max_concurrent":-1}`, http.StatusUnprocessableEntity},
+	}
+	for _, tc := range cases {
+		if rr, _ := do(tc.method, tc.path, tc.body); rr.Code != tc.code {
+			t.Errorf("%s %s %s -> %d, want %d", tc.method, tc.path, tc.body, rr.Code, tc.code)
+		}
+	}
+}
+
+func TestRecoveryHandler(t *testing.T) {
+	setupTestDB(t)
+	database.DB.AutoMigrate(&database.Task{})
+	database.DB.Create(&database.Expression{ID: "r1", UserID: 1, Data: "1+1", Status: "pending"})
+	recovered := recovery.New(database.DBStore{}, func(string) {})
+	if _, err := recovered.Run(); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/recovery", nil)
+	rr := httptest.NewRecorder()
+	recoveryHandler(recovered)(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	var summary recovery.Summary
+	json.NewDecoder(rr.Body).Decode(&summary)
+	if summary.Total != 1 || len(summary.Expressions) != 1 || summary.Expressions[0].ID != "r1" {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestAgentsHandlers(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	agents := rpcserver.NewRegistry()
+	agents.Register(rpcserver.Agent{ID: "a1", Hostname: "host-1", ComputingPower: 4}, time.Now())
+	agents.Register(rpcserver.Agent{ID: "a2", Hostname: "host-2", ComputingPower: 2}, time.Now())
+	tasks.Submit(&global.Task{ID: "t1"}, nil)
+	tasks.Submit(&global.Task{ID: "t2"}, nil)
+	tasks.Next(context.Background(), "a1", time.Minute)
+	tasks.Next(context.Background(), "a2", time.Minute)
+	agents.Completed("a2")
+
+	rr := httptest.NewRecorder()
+	agentsHandler(tasks, agents)(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/agents", nil))
+	var list []agentResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list) != 2 || list[0].ID != "a1" || list[0].InFlight != 1 || list[1].Completed != 1 || list[1].State != rpcserver.StateActive {
+		t.Fatalf("agents = %+v", list)
+	}
+
+	action := func(path string) (*httptest.ResponseRecorder, agentResponse) {
+		rr := httptest.NewRecorder()
+		agentActionHandler(tasks, agents)(rr, httptest.NewRequest(http.MethodPost, path, nil))
+		var resp agentResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return rr, resp
+	}
+	if _, resp := action("/api/v1/admin/agents/a2/drain"); resp.State != rpcserver.StateDraining || resp.InFlight != 1 {
+		t.Errorf("drain resp = %+v, want draining with its task still in flight", resp)
+	}
+	_, resp := action("/api/v1/admin/agents/a1/disable")
+	if resp.State != rpcserver.StateDisabled || resp.InFlight != 0 || resp.Failed != 0 || resp.ErrorRate != 0 {
+		t.Errorf("disable resp = %+v, want disabled with its task requeued and no failure charged", resp)
+	}
+	if tasks.Len() != 1 {
+		t.Errorf("queue has %d pending tasks, want the disabled agent's task back", tasks.Len())
+	}
+	if task := tasks.TryNext("a2", time.Minute, nil); task == nil || task.Attempts != 0 {
+		t.Errorf("released task = %+v, want it back without a charged attempt", task)
+	}
+	if _, resp := action("/api/v1/admin/agents/a1/enable"); resp.State != rpcserver.StateActive {
+		t.Errorf("enable resp = %+v", resp)
+	}
+
+	cases := []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "/api/v1/admin/agents/a1/drain", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/v1/admin/agents/ghost/drain", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/admin/agents/a1/reboot", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/admin/agents/a1", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		agentActionHandler(tasks, agents)(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != tc.code {
+			t.Errorf("%s %s -> %d, want %d", tc.method, tc.path, rr.Code, tc.code)
+		}
+	}
+}
+
+func TestDeadLetterHandlers(t *testing.T) {
+	setupTestDB(t)
+	database.DB.AutoMigrate(&database.Task{}, &database.DeadLetter{})
+	database.DB.Create(&database.Expression{ID: "d1", UserID: 1, Data: "2*3", Status: "calculation error: task t1 failed 1 attempts"})
+	history := []global.Attempt{{Agent: "a1", Reason: "lease expired", At: time.Now()}}
+	id, err := database.SaveDeadLetter(global.DeadLetter{TaskID: "t1", ExpressionID: "d1", UserID: 1, Operation: "*", Arg1: 2, Arg2: 3, Attempts: 1, History: history})
+	if err != nil {
+		t.Fatalf("SaveDeadLetter error: %v", err)
+	}
+	tasks := queue.New(queue.Defaults)
+
+	rr := httptest.NewRecorder()
+	deadLettersHandler(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/deadletters", nil))
+	var list []global.DeadLetter
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list) != 1 || list[0].ID != id || len(list[0].History) != 1 || list[0].History[0].Agent != "a1" {
+		t.Fatalf("dead letters = %+v", list)
+	}
+
+	do := func(method, path string) (*httptest.ResponseRecorder, global.DeadLetter) {
+		rr := httptest.NewRecorder()
+		deadLetterHandler(tasks, cost.NewModel(cost.Defaults))(rr, httptest.NewRequest(method, path, nil))
+		var letter global.DeadLetter
+		json.NewDecoder(rr.Body).Decode(&letter)
+		return rr, letter
+	}
+	if rr, letter := do(http.MethodGet, "/api/v1/admin/deadletters/1"); rr.Code != http.StatusOK || letter.TaskID != "t1" {
+		t.Errorf("GET -> %d %+v", rr.Code, letter)
+	}
+	rr, letter := do(http.MethodPost, "/api/v1/admin/deadletters/1/replay")
+	if rr.Code != http.StatusOK || letter.ReplayedAt == nil {
+		t.Fatalf("replay -> %d %+v", rr.Code, letter)
+	}
+	task, err := tasks.Next(context.Background(), "a2", time.Minute)
+	if err != nil || task.Operation != "*" {
+		t.Fatalf("replayed task = %+v, %v", task, err)
+	}
+	tasks.Complete(task.ID, "a2", 6)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if dto, _ := database.GetExpressionByID("d1"); dto.Status == "completed" {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if dto, _ := database.GetExpressionByID("d1"); dto.Status != "completed" || dto.Result != 6 {
+		t.Errorf("expression after replay = %+v", dto)
+	}
+
+	cases := []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodPost, "/api/v1/admin/deadletters/1/replay", http.StatusConflict},
+		{http.MethodGet, "/api/v1/admin/deadletters/1/replay", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/v1/admin/deadletters/1", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/v1/admin/deadletters/x", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/admin/deadletters/42", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/admin/deadletters/1/retry", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		if rr, _ := do(tc.method, tc.path); rr.Code != tc.code {
+			t.Errorf("%s %s -> %d, want %d", tc.method, tc.path, rr.Code, tc.code)
+		}
+	}
+}
+
+func TestCostHandlers(t *testing.T) {
+	costs := cost.NewModel(cost.Defaults)
+	do := func(h http.HandlerFunc, method, path, body string, resp any) int {
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		json.NewDecoder(rr.Body).Decode(resp)
+		return rr.Code
+	}
+
+	var defaults cost.Costs
+	if code := do(costsHandler(costs), http.MethodPut, "/api/v1/admin/costs", `{"division_ms":50}`, &defaults); code != http.StatusOK {
+		t.Fatalf("PUT defaults -> %d, want 200", code)
+	}
+	if defaults.Division != 50 || defaults.Addition != cost.Defaults.Addition {
+		t.Errorf("defaults = %+v, want only division changed", defaults)
+	}
+
+	var resp costsResponse
+	do(userCostsHandler(costs), http.MethodPut, "/api/v1/admin/costs/4", `{"addition_ms":5}`, &resp)
+	if !resp.Custom || resp.Costs.Addition != 5 || resp.Costs.Division != 50 {
+		t.Errorf("PUT user resp = %+v", resp)
+	}
+	if ms, _ := costs.Cost(4, "+"); ms != 5 {
+		t.Errorf("Cost(4, +) = %d, want 5", ms)
+	}
+	if do(userCostsHandler(costs), http.MethodDelete, "/api/v1/admin/costs/4", "", &resp); resp.Custom || resp.Costs != costs.Defaults() {
+		t.Errorf("DELETE user resp = %+v", resp)
+	}
+
+	cases := []struct {
+		h                  http.HandlerFunc
+		method, path, body string
+		code               int
+	}{
+		{costsHandler(costs), http.MethodPost, "/api/v1/admin/costs", "", http.StatusMethodNotAllowed},
+		{costsHandler(costs), http.MethodPut, "/api/v1/admin/costs", `{"power_ms":1}`, http.StatusBadRequest},
+		{costsHandler(costs), http.MethodPut, "/api/v1/admin/costs", `{"addition_ms":-1}`, http.StatusUnprocessableEntity},
+		{userCostsHandler(costs), http.MethodGet, "/api/v1/admin/costs/x", "", http.StatusBadRequest},
+		{userCostsHandler(costs), http.MethodPost, "/api/v1/admin/costs/4", "", http.StatusMethodNotAllowed},
+	}
+	for _, tc := range cases {
+		var ignored any
+		if code := do(tc.h, tc.method, tc.path, tc.body, &ignored); code != tc.code {
+			t.Errorf("%s %s %s -> %d, want %d", tc.method, tc.path, tc.body, code, tc.code)
+		}
+	}
+	if costs.Defaults().Addition != cost.Defaults.Addition {
+		t.Error("a rejected PUT changed the defaults")
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	settings := func() config.Config {
+		cfg := config.Default()
+		cfg.JWTSecret = "secret"
+		return cfg.Redacted()
+	}
+	rr := httptest.NewRecorder()
+	configHandler(settings)(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/config", nil))
+	var cfg config.Config
+	json.NewDecoder(rr.Body).Decode(&cfg)
+	if rr.Code != http.StatusOK || cfg.Port != 8080 || cfg.Costs != cost.Defaults {
+		t.Errorf("GET -> %d %+v", rr.Code, cfg)
+	}
+	if cfg.JWTSecret == "secret" {
+		t.Error("the JWT secret was exposed")
+	}
+	rr = httptest.NewRecorder()
+	configHandler(settings)(rr, httptest.NewRequest(http.MethodPut, "/api/v1/admin/config", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT -> %d, want 405", rr.Code)
+	}
+}
+
+func TestAdminsHandler(t *testing.T) {
+	setupTestDB(t)
+	database.CreateUser(&database.User{Login: "bob", Password: "x"})
+	cases := []struct {
+		method, path string
+		code         int
+		admin        bool
+	}{
+		{http.MethodPut, "/api/v1/admin/users/bob/admin", http.StatusOK, true},
+		{http.MethodDelete, "/api/v1/admin/users/bob/admin", http.StatusOK, false},
+		{http.MethodPut, "/api/v1/admin/users/ghost/admin", http.StatusNotFound, false},
+		{http.MethodGet, "/api/v1/admin/users/bob/admin", http.StatusMethodNotAllowed, false},
+		{http.MethodPut, "/api/v1/admin/users/bob", http.StatusNotFound, false},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		adminsHandler(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != tc.code {
+			t.Errorf("%s %s -> %d, want %d", tc.method, tc.path, rr.Code, tc.code)
+			continue
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		if user, _ := database.GetUserByLogin("bob"); user.Admin != tc.admin {
+			t.Errorf("%s %s: admin = %v, want %v", tc.method, tc.path, user.Admin, tc.admin)
+		}
+	}
+}
+
+func TestDeadLetterReplayIsExclusive(t *testing.T) {
+	setupTestDB(t)
+	// Все запросы должны видеть одну базу в памяти.
+	sqlDB, _ := database.DB.DB()
+	sqlDB.SetMaxOpenConns(1)
+	database.DB.AutoMigrate(&database.Task{}, &database.DeadLetter{})
+	database.DB.Create(&database.Expression{ID: "d2", UserID: 1, Data: "2*3", Status: "calculation error: task t1 failed 1 attempts"})
+	if _, err := database.SaveDeadLetter(global.DeadLetter{TaskID: "t1", ExpressionID: "d2", UserID: 1, Operation: "*", Arg1: 2, Arg2: 3, Attempts: 1}); err != nil {
+		t.Fatalf("SaveDeadLetter error: %v", err)
+	}
+	// Задержка после каждого чтения, чтобы все запросы прошли проверки до первого UPDATE.
+	database.DB.Callback().Query().After("gorm:query").Register("test:delay", func(*gorm.DB) {
+		time.Sleep(5 * time.Millisecond)
+	})
+	tasks := queue.New(queue.Defaults)
+	handler := deadLetterHandler(tasks, cost.NewModel(cost.Defaults))
+
+	const requests = 8
+	codes := make(chan int, requests)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			rr := httptest.NewRecorder()
+			handler(rr, httptest.NewRequest(http.MethodPost, "/api/v1/admin/deadletters/1/replay", nil))
+			codes <- rr.Code
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(codes)
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusConflict] != requests-1 {
+		t.Fatalf("responses = %v, want one 200 and the rest 409", counts)
+	}
+	deadline := time.Now().Add(time.Second)
+	for tasks.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := tasks.Len(); n != 1 {
+		t.Errorf("queued tasks = %d, want the expression started once", n)
+	}
+}
@@ -3,13 +3,24 @@ package handler
 import (
 	"bytes"
 	"calculator/internal/auth"
+	"calculator/internal/config"
+	"calculator/internal/cost"
 	"calculator/internal/database"
+	"calculator/internal/global"
 	"calculator/internal/http/server/middleware"
+	"calculator/internal/queue"
+	"calculator/internal/quota"
+	"calculator/internal/recovery"
+	rpcserver "calculator/internal/rpc"
 	"calculator/pkg/calculator"
 	"context"
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -18,6 +29,8 @@ type decorator func(http.Handler) http.Handler
 
 type requestData struct {
 	Expression string `json:"expression"`
+	Timeout    string `json:"timeout"`
+	Priority   string `json:"priority"`
 }
 
 type responseData struct {
@@ -57,6 +70,18 @@ type expressionsResponse struct {
 	Expressions []expressionResponse `json:"expressions"`
 }
 
+type renderResponse struct {
+	ID     string `json:"id"`
+	Format string `json:"format"`
+	Render string `json:"render"`
+}
+
+type traceResponse struct {
+	ID     string        `json:"id"`
+	Status string        `json:"status"`
+	Trace  *global.Trace `json:"trace"`
+}
+
 type SolvedTaskResponse struct {
 	ID     string  `json:"id"`
 	Result float64 `json:"result"`
@@ -67,13 +92,71 @@ type credentials struct {
 	Password string `json:"password"`
 }
 
-func New(ctx context.Context) (http.Handler, error) {
+func New(
+	ctx context.Context,
+	secret string,
+	settings func() config.Config,
+	tasks *queue.Queue,
+	quotas *quota.Manager,
+	costs *cost.Model,
+	recovered *recovery.Recovery,
+	agents *rpcserver.Registry,
+) (http.Handler, error) {
+	jwt := middleware.JWTMiddleware(secret)
 	serveMux := http.NewServeMux()
-	serveMux.HandleFunc("/api/v1/calculate", middleware.JWTMiddleware()(calculatorAPIHandler))
-	serveMux.HandleFunc("/api/v1/expressions", middleware.JWTMiddleware()(expressionsHandler))
-	serveMux.HandleFunc("/api/v1/expressions/", middleware.JWTMiddleware()(expressionHandler))
+	serveMux.HandleFunc("/api/v1/calculate", jwt(calculatorAPIHandler(tasks, quotas, costs)))
+	serveMux.HandleFunc("/api/v1/expressions", jwt(expressionsHandler))
+	serveMux.HandleFunc("/api/v1/expressions/", jwt(expressionHandler))
+	serveMux.HandleFunc(
+		"/api/v1/admin/scheduler",
+		jwt(middleware.AdminMiddleware()(schedulerHandler(tasks))),
+	)
+	serveMux.HandleFunc(
+		"/api/v1/admin/scheduler/weights/",
+		jwt(middleware.AdminMiddleware()(schedulerWeightHandler(tasks))),
+	)
+	serveMux.HandleFunc(
+		"/api/v1/admin/quotas/",
+		jwt(middleware.AdminMiddleware()(quotaHandler(quotas))),
+	)
+	serveMux.HandleFunc(
+		"/api/v1/admin/costs",
+		jwt(middleware.AdminMiddleware()(costsHandler(costs))),
+	)
+	serveMux.HandleFunc(
+		"/api/v1/admin/costs/",
+		jwt(middleware.AdminMiddleware()(userCostsHandler(costs))),
+	)
+	serveMux.HandleFunc(
+		"/api/v1/admin/config",
+		jwt(middleware.AdminMiddleware()(configHandler(settings))),
+	)
+	serveMux.HandleFunc(
+		"/api/v1/admin/recovery",
+		jwt(middleware.AdminMiddleware()(recoveryHandler(recovered))),
+	)
+	serveMux.HandleFunc(
+		"/api/v1/admin/agents",
+		jwt(middleware.AdminMiddleware()(agentsHandler(tasks, agents))),
+	)
+	serveMux.HandleFunc(
+		"/api/v1/admin/agents/",
+		jwt(middleware.AdminMiddleware()(agentActionHandler(tasks, agents))),
+	)
+	serveMux.HandleFunc(
+		"/api/v1/admin/deadletters",
+		jwt(middleware.AdminMiddleware()(deadLettersHandler)),
+	)
+	serveMux.HandleFunc(
+		"/api/v1/admin/deadletters/",
+		jwt(middleware.AdminMiddleware()(deadLetterHandler(tasks, costs))),
+	)
+	serveMux.HandleFunc(
+		"/api/v1/admin/users/",
+		jwt(middleware.AdminMiddleware()(adminsHandler)),
+	)
 	serveMux.HandleFunc("/api/v1/register", registerHandler)
-	serveMux.HandleFunc("/api/v1/login", loginHandler)
+	serveMux.HandleFunc("/api/v1/login", loginHandler(secret))
 	return serveMux, nil
 }
 
@@ -85,49 +168,109 @@ func Decorate(next http.Handler, ds ...decorator) http.Handler {
 	return decorated
 }
 
-func calculatorAPIHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(errorData{Error: "only POST method is allowed"})
-		return
-	}
-	var data requestData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errorData{Error: "invalid JSON"})
+// setRateLimitHeaders сообщает клиенту лимит задач в минуту и сколько из него осталось.
+func setRateLimitHeaders(w http.ResponseWriter, quotas *quota.Manager, userID uint, now time.Time) {
+	limits, _ := quotas.Limits(userID)
+	if limits.MaxTasksPerMinute == 0 {
 		return
 	}
-	if data.Expression == "" {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		json.NewEncoder(w).Encode(errorData{Error: "no expression provided"})
-		return
-	}
-	expressionID := uuid.New().String()
-	userIDRaw := r.Context().Value(middleware.UserIDKey)
-	userID, ok := userIDRaw.(uint)
-	if !ok {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(errorData{Error: "user ID not found"})
-		return
+	remaining := max(limits.MaxTasksPerMinute-quotas.Used(userID, now), 0)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limits.MaxTasksPerMinute))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+}
+
+func quotaExceeded(w http.ResponseWriter, exceeded *quota.Exceeded) {
+	w.Header().Set("X-Quota-Limit", exceeded.Limit)
+	w.Header().Set("X-Quota-Max", strconv.Itoa(exceeded.Max))
+	w.Header().Set("X-Quota-Current", strconv.Itoa(exceeded.Current))
+	if exceeded.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(exceeded.RetryAfter.Seconds()))))
 	}
-	err = database.CreateExpression(
-		&database.Expression{
-			ID:     expressionID,
-			UserID: userID,
-			Data:   data.Expression,
-			Status: "pending",
-		},
-	)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(errorData{Error: err.Error()})
-		return
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(errorData{Error: exceeded.Error()})
+}
+
+func calculatorAPIHandler(tasks *queue.Queue, quotas *quota.Manager, costs cost.OperationCostModel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only POST method is allowed"})
+			return
+		}
+		var data requestData
+		err := json.NewDecoder(r.Body).Decode(&data)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(errorData{Error: "invalid JSON"})
+			return
+		}
+		if data.Expression == "" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(errorData{Error: "no expression provided"})
+			return
+		}
+		var timeout time.Duration
+		if data.Timeout != "" {
+			timeout, err = time.ParseDuration(data.Timeout)
+			if err != nil || timeout <= 0 {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(errorData{Error: "timeout must be a positive duration, e.g. \"30s\""})
+				return
+			}
+		}
+		priority := global.PriorityNormal
+		if data.Priority != "" {
+			var known bool
+			priority, known = global.PriorityClasses[data.Priority]
+			if !known {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(errorData{Error: "priority must be one of low, normal, high"})
+				return
+			}
+		}
+		expressionID := uuid.New().String()
+		userIDRaw := r.Context().Value(middleware.UserIDKey)
+		userID, ok := userIDRaw.(uint)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(errorData{Error: "user ID not found"})
+			return
+		}
+		// Ошибки разбора здесь не важны: их сообщит вычисление выражения.
+		operations, _ := calculator.CountOperations(data.Expression)
+		now := time.Now()
+		err = quotas.Submit(userID, len(data.Expression), operations, now,
+			func() (int, error) { return database.CountActiveExpressions(userID) },
+			func() error {
+				return database.CreateExpression(
+					&database.Expression{
+						ID:        expressionID,
+						UserID:    userID,
+						Data:      data.Expression,
+						Status:    "pending",
+						TimeoutMS: timeout.Milliseconds(),
+						Priority:  priority,
+					},
+				)
+			},
+		)
+		if err != nil {
+			var exceeded *quota.Exceeded
+			if errors.As(err, &exceeded) {
+				setRateLimitHeaders(w, quotas, userID, now)
+				quotaExceeded(w, exceeded)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(errorData{Error: err.Error()})
+			return
+		}
+		setRateLimitHeaders(w, quotas, userID, now)
+		w.WriteHeader(http.StatusCreated)
+		go calculator.Calc(database.DBStore{}, tasks, costs, expressionID)
+		json.NewEncoder(w).Encode(idResponse{expressionID})
 	}
-	w.WriteHeader(http.StatusCreated)
-	go calculator.Calc(database.DBStore{}, expressionID)
-	json.NewEncoder(w).Encode(idResponse{expressionID})
 }
 
 func expressionsHandler(w http.ResponseWriter, r *http.Request) {
@@ -162,12 +305,22 @@ func expressionsHandler(w http.ResponseWriter, r *http.Request) {
 
 func expressionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	if r.Method != http.MethodGet {
+	parts := strings.Split(r.URL.Path, "/")
+	resource := ""
+	if len(parts) > 5 {
+		resource = parts[5]
+	}
+	if resource == "cancel" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only POST method is allowed"})
+			return
+		}
+	} else if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		json.NewEncoder(w).Encode(errorData{Error: "only GET method is allowed"})
 		return
 	}
-	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) < 5 || parts[4] == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(errorData{Error: "ID not provided"})
@@ -191,38 +344,85 @@ func expressionHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(errorData{Error: "you do not have access to this information"})
 		return
 	}
+	if resource != "" {
+		switch resource {
+		case "render":
+			renderHandler(w, r, expression)
+		case "trace":
+			traceHandler(w, expression)
+		case "cancel":
+			cancelHandler(w, expression)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(errorData{Error: "unknown resource: " + resource})
+		}
+		return
+	}
 	json.NewEncoder(w).Encode(expressionResponse{parts[4], expression.Status, expression.Result})
 }
 
-func registerHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(errorData{Error: "only POST method is allowed"})
+func cancelHandler(w http.ResponseWriter, expression *global.ExpressionDTO) {
+	if expression.Status != "pending" && expression.Status != "processing" {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(errorData{Error: "expression is already " + expression.Status})
 		return
 	}
-	var creds credentials
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(&creds); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errorData{Error: "invalid or unknown fields"})
+	// Статус меняется условно: вычисление, успевшее завершиться, не перезаписывается.
+	cancelled, err := database.UpdateActiveExpressionStatus(expression.ID, "cancelled")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(errorData{Error: err.Error()})
 		return
 	}
-	if creds.Login == "" || creds.Password == "" {
+	if !cancelled {
+		status := "finished"
+		if current, err := database.GetExpressionByID(expression.ID); err == nil {
+			status = current.Status
+		}
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(errorData{Error: "expression is already " + status})
+		return
+	}
+	calculator.Cancel(expression.ID)
+	json.NewEncoder(w).Encode(expressionResponse{expression.ID, "cancelled", expression.Result})
+}
+
+func traceHandler(w http.ResponseWriter, expression *global.ExpressionDTO) {
+	trace, ok := global.TracesMap.Load(expression.ID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(errorData{Error: "no trace recorded for this expression"})
+		return
+	}
+	json.NewEncoder(w).Encode(traceResponse{expression.ID, expression.Status, trace})
+}
+
+func renderHandler(w http.ResponseWriter, r *http.Request, expression *global.ExpressionDTO) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = calculator.FormatLaTeX
+	}
+	if format != calculator.FormatLaTeX && format != calculator.FormatMathML {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errorData{Error: "login and password are required"})
+		json.NewEncoder(w).Encode(errorData{Error: "format must be latex or mathml"})
 		return
 	}
-	if err := auth.Register(creds.Login, creds.Password); err != nil {
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(errorData{Error: err.Error()})
+	var rendered string
+	var err error
+	if expression.Status == "completed" {
+		rendered, err = calculator.RenderWithResult(expression.Data, expression.Result, format)
+	} else {
+		rendered, err = calculator.Render(expression.Data, format)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(errorData{Error: "cannot render expression: " + err.Error()})
 		return
 	}
-	json.NewEncoder(w).Encode(infoData{Info: "OK"})
+	json.NewEncoder(w).Encode(renderResponse{expression.ID, format, rendered})
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+func registerHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -242,11 +442,41 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(errorData{Error: "login and password are required"})
 		return
 	}
-	token, err := auth.Login(creds.Login, creds.Password)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+	if err := auth.Register(creds.Login, creds.Password); err != nil {
+		w.WriteHeader(http.StatusConflict)
 		json.NewEncoder(w).Encode(errorData{Error: err.Error()})
 		return
 	}
-	json.NewEncoder(w).Encode(tokenData{Info: "OK", Token: token})
+	json.NewEncoder(w).Encode(infoData{Info: "OK"})
+}
+
+func loginHandler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(errorData{Error: "only POST method is allowed"})
+			return
+		}
+		var creds credentials
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&creds); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(errorData{Error: "invalid or unknown fields"})
+			return
+		}
+		if creds.Login == "" || creds.Password == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(errorData{Error: "login and password are required"})
+			return
+		}
+		token, err := auth.Login(creds.Login, creds.Password, secret)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(errorData{Error: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(tokenData{Info: "OK", Token: token})
+	}
 }
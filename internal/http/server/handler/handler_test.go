@@ -6,16 +6,30 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 
+	"calculator/internal/cost"
 	"calculator/internal/database"
+	"calculator/internal/global"
 	"calculator/internal/http/server/middleware"
+	"calculator/internal/queue"
+	"calculator/internal/quota"
+	"calculator/pkg/loggers"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+func TestMain(m *testing.M) {
+	loggers.InitLogger("orchestrator", os.DevNull)
+	loggers.InitLogger("general", os.DevNull)
+	code := m.Run()
+	loggers.CloseAllLoggers()
+	os.Exit(code)
+}
+
 func setupTestDB(t *testing.T) {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	if err != nil {
@@ -32,7 +46,7 @@ func TestCalculatorAPIHandler_MethodNotAllowed(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/calculate", nil)
 	rr := httptest.NewRecorder()
 
-	calculatorAPIHandler(rr, req)
+	calculatorAPIHandler(queue.New(queue.Defaults), quota.NewManager(quota.Defaults), cost.NewModel(cost.Defaults))(rr, req)
 	if rr.Code != http.StatusMethodNotAllowed {
 		t.Errorf("GET -> %d, want %d", rr.Code, http.StatusMethodNotAllowed)
 	}
@@ -48,7 +62,7 @@ func TestCalculatorAPIHandler_InvalidJSON(t *testing.T) {
 	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, uint(1)))
 	rr := httptest.NewRecorder()
 
-	calculatorAPIHandler(rr, req)
+	calculatorAPIHandler(queue.New(queue.Defaults), quota.NewManager(quota.Defaults), cost.NewModel(cost.Defaults))(rr, req)
 	if rr.Code != http.StatusBadRequest {
 		t.Errorf("Bad JSON -> %d, want %d", rr.Code, http.StatusBadRequest)
 	}
@@ -60,18 +74,42 @@ func TestCalculatorAPIHandler_NoExpression(t *testing.T) {
 	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, uint(1)))
 	rr := httptest.NewRecorder()
 
-	calculatorAPIHandler(rr, req)
+	calculatorAPIHandler(queue.New(queue.Defaults), quota.NewManager(quota.Defaults), cost.NewModel(cost.Defaults))(rr, req)
 	if rr.Code != http.StatusUnprocessableEntity {
 		t.Errorf("Empty expr -> %d, want %d", rr.Code, http.StatusUnprocessableEntity)
 	}
 }
 
+func TestCalculatorAPIHandler_InvalidTimeout(t *testing.T) {
+	body, _ := json.Marshal(map[string]string{"expression": "1+1", "timeout": "soon"})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, uint(1)))
+	rr := httptest.NewRecorder()
+
+	calculatorAPIHandler(queue.New(queue.Defaults), quota.NewManager(quota.Defaults), cost.NewModel(cost.Defaults))(rr, req)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Invalid timeout -> %d, want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestCalculatorAPIHandler_InvalidPriority(t *testing.T) {
+	body, _ := json.Marshal(map[string]string{"expression": "1+1", "priority": "urgent"})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, uint(1)))
+	rr := httptest.NewRecorder()
+
+	calculatorAPIHandler(queue.New(queue.Defaults), quota.NewManager(quota.Defaults), cost.NewModel(cost.Defaults))(rr, req)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Invalid priority -> %d, want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+}
+
 func TestCalculatorAPIHandler_Unauthorized(t *testing.T) {
 	body, _ := json.Marshal(map[string]string{"expression": "1+1"})
 	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
 	rr := httptest.NewRecorder()
 
-	calculatorAPIHandler(rr, req)
+	calculatorAPIHandler(queue.New(queue.Defaults), quota.NewManager(quota.Defaults), cost.NewModel(cost.Defaults))(rr, req)
 	if rr.Code != http.StatusUnauthorized {
 		t.Errorf("No userID -> %d, want %d", rr.Code, http.StatusUnauthorized)
 	}
@@ -120,3 +158,125 @@ func TestExpressionHandler_NotFoundAndForbidden(t *testing.T) {
 		t.Errorf("Forbidden -> %d, want %d", rr.Code, http.StatusForbidden)
 	}
 }
+
+func TestExpressionHandler_Render(t *testing.T) {
+	setupTestDB(t)
+	database.DB.Create(&database.Expression{ID: "r1", UserID: 1, Data: "(3+5)/2", Status: "completed", Result: 4})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/r1/render?format=latex", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, uint(1)))
+	rr := httptest.NewRecorder()
+
+	expressionHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Render -> %d, want %d", rr.Code, http.StatusOK)
+	}
+	var resp renderResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Render != `\frac{3 + 5}{2} = 4` {
+		t.Errorf("render = %q", resp.Render)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/r1/render?format=svg", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, uint(1)))
+	rr = httptest.NewRecorder()
+
+	expressionHandler(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Unknown format -> %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExpressionHandler_Trace(t *testing.T) {
+	setupTestDB(t)
+	database.DB.Create(&database.Expression{ID: "tr1", UserID: 1, Data: "1+2", Status: "processing"})
+	trace := global.NewTrace()
+	trace.SetRoot(trace.Operation("+", trace.Number(1), trace.Number(2)))
+	global.TracesMap.Store("tr1", trace)
+	defer global.TracesMap.Delete("tr1")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/tr1/trace", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, uint(1)))
+	rr := httptest.NewRecorder()
+
+	expressionHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Trace -> %d, want %d", rr.Code, http.StatusOK)
+	}
+	var resp struct {
+		Status string           `json:"status"`
+		Trace  global.TraceNode `json:"trace"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Status != "processing" || resp.Trace.Operation != "+" || len(resp.Trace.Children) != 2 {
+		t.Errorf("resp = %+v", resp)
+	}
+}
+
+func TestExpressionHandler_Cancel(t *testing.T) {
+	setupTestDB(t)
+	database.DB.Create(&database.Expression{ID: "c1", UserID: 1, Data: "1+1", Status: "pending"})
+	database.DB.Create(&database.Expression{ID: "c2", UserID: 1, Data: "1+1", Status: "completed", Result: 2})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/c1/cancel", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, uint(1)))
+	rr := httptest.NewRecorder()
+	expressionHandler(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET cancel -> %d, want %d", rr.Code, http.StatusMethodNotAllowed)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/expressions/c1/cancel", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, uint(1)))
+	rr = httptest.NewRecorder()
+	expressionHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Cancel -> %d, want %d", rr.Code, http.StatusOK)
+	}
+	if dto, _ := database.GetExpressionByID("c1"); dto.Status != "cancelled" {
+		t.Errorf("status = %q, want cancelled", dto.Status)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/expressions/c2/cancel", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, uint(1)))
+	rr = httptest.NewRecorder()
+	expressionHandler(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Cancel completed -> %d, want %d", rr.Code, http.StatusConflict)
+	}
+}
+
+func TestCalculatorAPIHandler_QuotaExceeded(t *testing.T) {
+	setupTestDB(t)
+	database.DB.Create(&database.Expression{ID: "q1", UserID: 1, Data: "1+1", Status: "processing"})
+	quotas := quota.NewManager(quota.Defaults)
+	quotas.Set(1, quota.Limits{MaxConcurrent: 2, MaxTasksPerMinute: 10, MaxOperations: 2})
+
+	cases := []struct {
+		expression string
+		pending    string
+		limit      string
+	}{
+		{"1+2*3-4", "", quota.LimitOperations},
+		{"1+2", "q2", quota.LimitConcurrent},
+	}
+	for _, tc := range cases {
+		if tc.pending != "" {
+			database.DB.Create(&database.Expression{ID: tc.pending, UserID: 1, Data: "1+1", Status: "pending"})
+		}
+		body := bytes.NewBufferString(`{"expression":"` + tc.expression + `"}`)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", body)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, uint(1)))
+		rr := httptest.NewRecorder()
+		calculatorAPIHandler(queue.New(queue.Defaults), quotas, cost.NewModel(cost.Defaults))(rr, req)
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("%q -> %d, want %d", tc.expression, rr.Code, http.StatusTooManyRequests)
+		}
+		if got := rr.Header().Get("X-Quota-Limit"); got != tc.limit {
+			t.Errorf("%q X-Quota-Limit = %q, want %q", tc.expression, got, tc.limit)
+		}
+		if got := rr.Header().Get("X-RateLimit-Remaining"); got != "10" {
+			t.Errorf("%q X-RateLimit-Remaining = %q, want 10", tc.expression, got)
+		}
+	}
+}
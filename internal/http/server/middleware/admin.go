@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"calculator/internal/database"
+	"encoding/json"
+	"net/http"
+)
+
+// AdminMiddleware пропускает только пользователей с правами администратора
+// (database.User.Admin); ставится после JWTMiddleware.
+func AdminMiddleware() func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			userID, ok := r.Context().Value(UserIDKey).(uint)
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(errorData{Error: "user ID not found"})
+				return
+			}
+			user, err := database.GetUserByID(userID)
+			if err != nil || !user.Admin {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(errorData{Error: "admin access required"})
+				return
+			}
+			next(w, r)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,7 +13,7 @@ type contextKey string
 
 const UserIDKey = contextKey("user_id")
 
-func JWTMiddleware() func(http.HandlerFunc) http.HandlerFunc {
+func JWTMiddleware(secret string) func(http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
@@ -26,7 +25,7 @@ func JWTMiddleware() func(http.HandlerFunc) http.HandlerFunc {
 			}
 			tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 			token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-				return []byte(os.Getenv("JWT_SECRET")), nil
+				return []byte(secret), nil
 			})
 			if err != nil || !token.Valid {
 				w.WriteHeader(http.StatusUnauthorized)
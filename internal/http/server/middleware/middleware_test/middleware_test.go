@@ -2,8 +2,10 @@ package middleware_test
 
 import (
 	"bytes"
+	"calculator/internal/database"
 	"calculator/internal/http/server/middleware"
 	"calculator/pkg/loggers"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -12,6 +14,8 @@ import (
 	"testing"
 
 	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func TestMain(m *testing.M) {
@@ -95,7 +99,7 @@ func TestJWTMiddleware_NoHeader(t *testing.T) {
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
 	})
-	wrapper := middleware.JWTMiddleware()(next)
+	wrapper := middleware.JWTMiddleware("secret")(next)
 	req := httptest.NewRequest("GET", "/", nil)
 	rr := httptest.NewRecorder()
 	wrapper.ServeHTTP(rr, req)
@@ -119,7 +123,7 @@ func TestJWTMiddleware_InvalidToken(t *testing.T) {
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
 	})
-	wrapper := middleware.JWTMiddleware()(next)
+	wrapper := middleware.JWTMiddleware("secret")(next)
 	req := httptest.NewRequest("GET", "/", nil)
 	req.Header.Set("Authorization", "Bearer invalid")
 	rr := httptest.NewRecorder()
@@ -140,15 +144,13 @@ func TestJWTMiddleware_InvalidToken(t *testing.T) {
 }
 
 func TestJWTMiddleware_InvalidClaims(t *testing.T) {
-	os.Setenv("JWT_SECRET", "secret")
-	defer os.Unsetenv("JWT_SECRET")
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"foo": "bar"})
 	tokenStr, _ := token.SignedString([]byte("secret"))
 	nextCalled := false
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
 	})
-	wrapper := middleware.JWTMiddleware()(next)
+	wrapper := middleware.JWTMiddleware("secret")(next)
 	req := httptest.NewRequest("GET", "/", nil)
 	req.Header.Set("Authorization", "Bearer "+tokenStr)
 	rr := httptest.NewRecorder()
@@ -169,8 +171,6 @@ func TestJWTMiddleware_InvalidClaims(t *testing.T) {
 }
 
 func TestJWTMiddleware_Success(t *testing.T) {
-	os.Setenv("JWT_SECRET", "secret")
-	defer os.Unsetenv("JWT_SECRET")
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": 42})
 	tokenStr, _ := token.SignedString([]byte("secret"))
 	var seenID uint
@@ -181,7 +181,7 @@ func TestJWTMiddleware_Success(t *testing.T) {
 		}
 		w.WriteHeader(http.StatusOK)
 	})
-	wrapper := middleware.JWTMiddleware()(next)
+	wrapper := middleware.JWTMiddleware("secret")(next)
 	req := httptest.NewRequest("GET", "/", nil)
 	req.Header.Set("Authorization", "Bearer "+tokenStr)
 	rr := httptest.NewRecorder()
@@ -193,3 +193,54 @@ func TestJWTMiddleware_Success(t *testing.T) {
 		t.Errorf("UserID = %d, want %d", seenID, 42)
 	}
 }
+
+func setupAdminDB(t *testing.T) {
+	var err error
+	database.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := database.DB.AutoMigrate(&database.User{}); err != nil {
+		t.Fatalf("failed to migrate User: %v", err)
+	}
+	for _, login := range []string{"root", "alice"} {
+		if err := database.CreateUser(&database.User{Login: login, Password: "x"}); err != nil {
+			t.Fatalf("CreateUser error: %v", err)
+		}
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	setupAdminDB(t)
+	if err := database.SetAdmin("root", true); err != nil {
+		t.Fatalf("SetAdmin error: %v", err)
+	}
+	root, _ := database.GetUserByLogin("root")
+	alice, _ := database.GetUserByLogin("alice")
+	cases := []struct {
+		name   string
+		userID any
+		code   int
+	}{
+		{"admin", root.ID, http.StatusOK},
+		{"regular user", alice.ID, http.StatusForbidden},
+		{"unknown user", uint(999), http.StatusForbidden},
+		{"no user", nil, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			wrapper := middleware.AdminMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			req := httptest.NewRequest("GET", "/", nil)
+			if tc.userID != nil {
+				req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, tc.userID))
+			}
+			rr := httptest.NewRecorder()
+			wrapper.ServeHTTP(rr, req)
+			if rr.Code != tc.code {
+				t.Errorf("StatusCode = %d, want %d", rr.Code, tc.code)
+			}
+		})
+	}
+}
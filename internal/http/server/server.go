@@ -1,18 +1,32 @@
 package server
 
 import (
+	"calculator/internal/config"
+	"calculator/internal/cost"
 	"calculator/internal/http/server/handler"
 	middleware2 "calculator/internal/http/server/middleware"
+	"calculator/internal/queue"
+	"calculator/internal/quota"
+	"calculator/internal/recovery"
+	rpcserver "calculator/internal/rpc"
 	"calculator/pkg/loggers"
 	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
 )
 
-func new(ctx context.Context) (http.Handler, error) {
-	muxHandler, err := handler.New(ctx)
+func new(
+	ctx context.Context,
+	secret string,
+	settings func() config.Config,
+	tasks *queue.Queue,
+	quotas *quota.Manager,
+	costs *cost.Model,
+	recovered *recovery.Recovery,
+	agents *rpcserver.Registry,
+) (http.Handler, error) {
+	muxHandler, err := handler.New(ctx, secret, settings, tasks, quotas, costs, recovered, agents)
 	if err != nil {
 		return nil, fmt.Errorf("handler initialization error: %w", err)
 	}
@@ -24,22 +38,27 @@ func new(ctx context.Context) (http.Handler, error) {
 	return muxHandler, nil
 }
 
-func Run(ctx context.Context) (func(context.Context) error, error) {
-	muxHandler, err := new(ctx)
+func Run(
+	ctx context.Context,
+	cfg config.Config,
+	settings func() config.Config,
+	tasks *queue.Queue,
+	quotas *quota.Manager,
+	costs *cost.Model,
+	recovered *recovery.Recovery,
+	agents *rpcserver.Registry,
+) (func(context.Context) error, error) {
+	muxHandler, err := new(ctx, cfg.JWTSecret, settings, tasks, quotas, costs, recovered, agents)
 	if err != nil {
 		return nil, err
 	}
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	srv := &http.Server{Addr: ":" + port, Handler: muxHandler}
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: muxHandler}
 	logger := loggers.GetLogger("server")
 	go func() {
 		if err := srv.ListenAndServe(); err != nil {
 			logger.Error("ListenAndServe", slog.String("err", err.Error()))
 		}
 	}()
-	fmt.Printf("The server is running at http://localhost:%s/", port)
+	fmt.Printf("The server is running at http://localhost:%d/", cfg.Port)
 	return srv.Shutdown, nil
 }
@@ -0,0 +1,717 @@
+package queue
+
+import (
+	"calculator/internal/global"
+	"container/list"
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	task     *global.Task
+	future   *global.Future
+	node     *global.TraceNode
+	enqueued time.Time
+	element  *list.Element
+	lease    *global.Lease
+	// group — проверка повторным выполнением, если запись — одна из реплик задачи.
+	group *verification
+	// primary и backup связывают задачу-отстающую и её спекулятивный дубликат (см. speculate.go).
+	primary *entry
+	backup  *entry
+	history []global.Attempt
+}
+
+type Stats struct {
+	Pending   int           `json:"pending"`
+	Leased    int           `json:"leased"`
+	OldestAge time.Duration `json:"oldest_age"`
+}
+
+// UserShare — доля пользователя в выдаче задач. Share считается по суммарному
+// времени выданных операций, так как именно его делит планировщик.
+type UserShare struct {
+	UserID     uint    `json:"user_id"`
+	Weight     int     `json:"weight"`
+	Pending    int     `json:"pending"`
+	Dispatched int64   `json:"dispatched"`
+	ComputeMS  int64   `json:"compute_ms"`
+	Share      float64 `json:"share"`
+}
+
+// userQueue — задачи одного пользователя, разложенные по классам приоритета.
+type userQueue struct {
+	classes map[int]*list.List
+	deficit int64
+}
+
+func (u *userQueue) len() int {
+	n := 0
+	for _, l := range u.classes {
+		n += l.Len()
+	}
+	return n
+}
+
+type usage struct {
+	dispatched int64
+	computeMS  int64
+}
+
+// Queue — очередь задач оркестратора. Первой выдаётся задача с наибольшим
+// эффективным приоритетом: класс приоритета плюс одна ступень за каждый интервал
+// aging ожидания, поэтому задачи низкого приоритета не голодают. При равенстве
+// задачи выдаются в порядке постановки (FIFO); задача, вернувшаяся после
+// истечения аренды, встаёт в начало своего класса, так как она старше остальных.
+//
+// Между пользователями одной ступени приоритета очередь делится по схеме deficit
+// round robin: за проход пользователь получает weight*quantum миллисекунд
+// вычислений, поэтому один пользователь с тысячами выражений не занимает всех агентов.
+type Queue struct {
+	mu      sync.Mutex
+	users   map[uint]*userQueue
+	ring    []uint
+	cursor  int
+	weights map[uint]int
+	usage   map[uint]*usage
+	entries map[string]*entry
+	// groups — проверяемые задачи по исходному ID (см. verify.go).
+	groups map[string]*verification
+	aging  time.Duration
+	wakeup chan struct{}
+	// verifyRate — доля задач, которые выполняют два агента (см. verify.go).
+	verifyRate float64
+	suspects   []string
+	// maxAttempts — после стольких неудачных выдач задача снимается с очереди; 0 — без ограничения.
+	maxAttempts int
+}
+
+// quantum — сколько миллисекунд вычислений получает пользователь с весом 1 за проход.
+const quantum = 100
+
+// Options — настройки очереди.
+type Options struct {
+	// Aging — время ожидания, за которое задача поднимается на один класс приоритета.
+	Aging time.Duration
+	// MaxAttempts — сколько раз задачу можно выдать агентам, прежде чем она
+	// попадёт в dead-letter; 0 — без ограничения.
+	MaxAttempts int
+	// Weights — веса пользователей в планировщике; вес по умолчанию — 1.
+	Weights map[uint]int
+	// VerificationRate — доля задач, которые проверяются повторным выполнением (см. verify.go).
+	VerificationRate float64
+}
+
+// Defaults — настройки по умолчанию, если они не заданы в конфигурации.
+var Defaults = Options{
+	Aging:       5 * time.Second,
+	MaxAttempts: 5,
+}
+
+// ParseWeights разбирает веса пользователей в формате "1=3,2=1" (SCHEDULER_WEIGHTS).
+func ParseWeights(raw string) (map[uint]int, error) {
+	weights := make(map[uint]int)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		user, weight, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid weight %q: want user=weight", pair)
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(user), 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user ID in %q", pair)
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil || w < 1 {
+			return nil, fmt.Errorf("invalid weight in %q: must be a positive integer", pair)
+		}
+		weights[uint(id)] = w
+	}
+	return weights, nil
+}
+
+func New(opts Options) *Queue {
+	weights := make(map[uint]int, len(opts.Weights))
+	maps.Copy(weights, opts.Weights)
+	return &Queue{
+		users:   make(map[uint]*userQueue),
+		weights: weights,
+		usage:   make(map[uint]*usage),
+		entries: make(map[string]*entry),
+		groups:  make(map[string]*verification),
+		aging:   opts.Aging,
+		wakeup:  make(chan struct{}),
+
+		verifyRate:  opts.VerificationRate,
+		maxAttempts: opts.MaxAttempts,
+	}
+}
+
+func (q *Queue) push(e *entry, front bool) {
+	u, ok := q.users[e.task.UserID]
+	if !ok {
+		u = &userQueue{classes: make(map[int]*list.List)}
+		q.users[e.task.UserID] = u
+		q.ring = append(q.ring, e.task.UserID)
+	}
+	l, ok := u.classes[e.task.Priority]
+	if !ok {
+		l = list.New()
+		u.classes[e.task.Priority] = l
+	}
+	if front {
+		e.element = l.PushFront(e)
+	} else {
+		e.element = l.PushBack(e)
+	}
+}
+
+func (q *Queue) remove(e *entry) {
+	if e.element == nil {
+		return
+	}
+	u := q.users[e.task.UserID]
+	u.classes[e.task.Priority].Remove(e.element)
+	e.element = nil
+	if u.len() == 0 {
+		q.dropUser(e.task.UserID)
+	}
+}
+
+// dropUser убирает пользователя без задач из кольца; его дефицит сгорает.
+func (q *Queue) dropUser(userID uint) {
+	delete(q.users, userID)
+	for i, id := range q.ring {
+		if id != userID {
+			continue
+		}
+		q.ring = append(q.ring[:i], q.ring[i+1:]...)
+		if i < q.cursor {
+			q.cursor--
+		}
+		break
+	}
+	if q.cursor >= len(q.ring) {
+		q.cursor = 0
+	}
+}
+
+func (q *Queue) effective(e *entry, now time.Time) float64 {
+	return float64(e.task.Priority) + float64(now.Sub(e.enqueued))/float64(q.aging)
+}
+
+// head возвращает задачу пользователя с наибольшим эффективным приоритетом из
+// тех, что принимает accept (nil — любые). Внутри класса первая подходящая
+// задача ждёт дольше остальных подходящих.
+func (q *Queue) head(u *userQueue, now time.Time, accept func(*global.Task) bool) *entry {
+	var best *entry
+	for _, l := range u.classes {
+		var e *entry
+		for el := l.Front(); el != nil; el = el.Next() {
+			if candidate := el.Value.(*entry); accept == nil || accept(candidate.task) {
+				e = candidate
+				break
+			}
+		}
+		if e == nil {
+			continue
+		}
+		if best == nil {
+			best = e
+			continue
+		}
+		pe, pb := q.effective(e, now), q.effective(best, now)
+		if pe > pb || (pe == pb && e.enqueued.Before(best.enqueued)) {
+			best = e
+		}
+	}
+	return best
+}
+
+func (q *Queue) weight(userID uint) int {
+	if w, ok := q.weights[userID]; ok {
+		return w
+	}
+	return 1
+}
+
+func cost(task *global.Task) int64 {
+	return max(int64(task.OperationTime), 1)
+}
+
+// pop выбирает задачу: сначала ступень приоритета (целая часть эффективного
+// приоритета), затем среди пользователей этой ступени — по deficit round robin.
+func (q *Queue) pop(now time.Time, accept func(*global.Task) bool) *entry {
+	heads := make(map[uint]*entry, len(q.ring))
+	levels := make(map[uint]int, len(q.ring))
+	top := math.MinInt
+	for _, id := range q.ring {
+		h := q.head(q.users[id], now, accept)
+		if h == nil {
+			continue
+		}
+		heads[id] = h
+		levels[id] = int(math.Floor(q.effective(h, now)))
+		top = max(top, levels[id])
+	}
+	if len(heads) == 0 {
+		return nil
+	}
+	for {
+		id := q.ring[q.cursor]
+		if h, ok := heads[id]; ok && levels[id] == top {
+			u := q.users[id]
+			if u.deficit >= cost(h.task) {
+				u.deficit -= cost(h.task)
+				q.remove(h)
+				q.account(h.task)
+				return h
+			}
+			u.deficit += int64(q.weight(id)) * quantum
+		}
+		q.cursor = (q.cursor + 1) % len(q.ring)
+	}
+}
+
+func (q *Queue) account(task *global.Task) {
+	u, ok := q.usage[task.UserID]
+	if !ok {
+		u = &usage{}
+		q.usage[task.UserID] = u
+	}
+	u.dispatched++
+	u.computeMS += cost(task)
+}
+
+func (q *Queue) pendingLen() int {
+	n := 0
+	for _, u := range q.users {
+		n += u.len()
+	}
+	return n
+}
+
+func (q *Queue) notify() {
+	close(q.wakeup)
+	q.wakeup = make(chan struct{})
+}
+
+// Submit ставит задачу в очередь и возвращает Future, который будет разрешён результатом агента.
+func (q *Queue) Submit(task *global.Task, node *global.TraceNode) *global.Future {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.notify()
+	if node != nil {
+		node.Queued(task)
+	}
+	if q.sampled() {
+		return q.submitVerified(task, node)
+	}
+	e := &entry{task: task, future: global.NewFuture(), node: node, enqueued: time.Now()}
+	q.push(e, false)
+	q.entries[task.ID] = e
+	return e.future
+}
+
+// Next блокируется до появления задачи и выдаёт её агенту в аренду на время
+// выполнения операции плюс grace.
+func (q *Queue) Next(ctx context.Context, agent string, grace time.Duration) (*global.Task, error) {
+	return q.NextMatching(ctx, agent, grace, nil)
+}
+
+// NextMatching как Next, но выдаёт только задачи, которые принимает accept,
+// например операции, поддерживаемые агентом.
+func (q *Queue) NextMatching(
+	ctx context.Context,
+	agent string,
+	grace time.Duration,
+	accept func(*global.Task) bool,
+) (*global.Task, error) {
+	for {
+		q.mu.Lock()
+		if task := q.take(agent, grace, accept); task != nil {
+			q.mu.Unlock()
+			return task, nil
+		}
+		wakeup := q.wakeup
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wakeup:
+		}
+	}
+}
+
+// TryNext — неблокирующий вариант NextMatching: nil, если подходящих задач нет.
+// Нужен, чтобы добрать пакет задач для агента.
+func (q *Queue) TryNext(agent string, grace time.Duration, accept func(*global.Task) bool) *global.Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.take(agent, grace, accept)
+}
+
+func (q *Queue) take(agent string, grace time.Duration, accept func(*global.Task) bool) *global.Task {
+	now := time.Now()
+	e := q.pop(now, func(task *global.Task) bool {
+		if accept != nil && !accept(task) {
+			return false
+		}
+		e := q.entries[task.ID]
+		if e.group != nil && q.involves(e.group, agent) {
+			return false
+		}
+		// Дубликат отстающей задачи должен достаться другому агенту.
+		other := e.sibling()
+		return other == nil || other.lease == nil || other.lease.Agent != agent
+	})
+	if e == nil {
+		return nil
+	}
+	e.lease = &global.Lease{
+		Task:    e.task,
+		Agent:   agent,
+		Started: now,
+		Expires: now.Add(time.Duration(e.task.OperationTime)*time.Millisecond + grace),
+	}
+	if e.node != nil {
+		e.node.Sent(agent)
+	}
+	return e.task
+}
+
+// Release возвращает выданную задачу в начало очереди, например если её не удалось отправить.
+func (q *Queue) Release(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[id]
+	if !ok || e.lease == nil {
+		return
+	}
+	e.lease = nil
+	q.push(e, true)
+	if e.node != nil {
+		e.node.Queued(e.task)
+	}
+	q.notify()
+}
+
+// ReleaseAgent возвращает в начало очереди все задачи, арендованные агентом,
+// не засчитывая неудачную попытку: задачи забирает администратор, а не агент
+// их потерял.
+func (q *Queue) ReleaseAgent(agent string) []*global.Lease {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var released []*global.Lease
+	for _, e := range q.entries {
+		if e.lease == nil || e.lease.Agent != agent {
+			continue
+		}
+		released = append(released, e.lease)
+		e.lease = nil
+		q.push(e, true)
+		if e.node != nil {
+			e.node.Queued(e.task)
+		}
+	}
+	if len(released) > 0 {
+		q.notify()
+	}
+	return released
+}
+
+// Complete разрешает Future задачи результатом, который прислал agent.
+// Повторные и поздние результаты игнорируются: resolved будет false.
+// Возвращается аренда, которая была на задаче в момент ответа.
+func (q *Queue) Complete(id, agent string, result float64) (lease *global.Lease, resolved bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[id]
+	if !ok {
+		return nil, false
+	}
+	if e.group != nil {
+		return e.lease, q.settle(e, agent, outcome{value: result})
+	}
+	delete(q.entries, id)
+	q.remove(e)
+	q.detach(e)
+	if !e.future.SetResult(result) {
+		return e.lease, false
+	}
+	if e.node != nil {
+		e.node.Solved(result)
+	}
+	return e.lease, true
+}
+
+// Fail закрывает задачу ошибкой, которую сообщил агент; как и Complete,
+// срабатывает только для первого ответа.
+func (q *Queue) Fail(id, agent string, err error) (lease *global.Lease, resolved bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[id]
+	if !ok {
+		return nil, false
+	}
+	if e.group != nil {
+		return e.lease, q.settle(e, agent, outcome{err: err.Error()})
+	}
+	delete(q.entries, id)
+	q.remove(e)
+	q.detach(e)
+	if !e.future.SetError(err) {
+		return e.lease, false
+	}
+	if e.node != nil {
+		e.node.Failed(err)
+	}
+	return e.lease, true
+}
+
+// Withdraw снимает задачи с очереди вместе со всеми их копиями (репликами
+// проверки и дубликатами отстающих задач) и освобождает их аренды.
+// Возвращает ID снятых копий: их агентам нужно разослать отмену.
+func (q *Queue) Withdraw(ids []string) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var withdrawn []string
+	take := func(id string) *entry {
+		e, ok := q.entries[id]
+		if !ok {
+			return nil
+		}
+		delete(q.entries, id)
+		q.remove(e)
+		withdrawn = append(withdrawn, id)
+		return e
+	}
+	for _, id := range ids {
+		if g, ok := q.groups[id]; ok {
+			for _, replica := range g.replicas {
+				take(replica)
+			}
+			delete(q.groups, id)
+			continue
+		}
+		// Исходная задача могла исчерпать попытки раньше своего дубликата.
+		if e := take(id); e == nil {
+			take(backupID(id))
+		} else if e.backup != nil {
+			take(e.backup.task.ID)
+		}
+	}
+	return withdrawn
+}
+
+// RequeueExpired возвращает в очередь задачи с истёкшей арендой.
+func (q *Queue) RequeueExpired(now time.Time) []*global.Lease {
+	return q.requeue("lease expired", func(lease *global.Lease) bool { return !now.Before(lease.Expires) })
+}
+
+// RequeueAgent возвращает в очередь все задачи, арендованные агентом, например
+// когда он перестал присылать heartbeat.
+func (q *Queue) RequeueAgent(agent string) []*global.Lease {
+	return q.requeue("agent removed", func(lease *global.Lease) bool { return lease.Agent == agent })
+}
+
+// requeue забирает у агентов задачи, аренда которых подходит под match, и
+// записывает неудачную попытку. Задача, исчерпавшая maxAttempts, не
+// возвращается в очередь: её Future завершается *global.PoisonTaskError.
+// В результат попадают аренды всех забранных задач.
+func (q *Queue) requeue(reason string, match func(*global.Lease) bool) []*global.Lease {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	var requeued []*global.Lease
+	for id, e := range q.entries {
+		if e.lease == nil || !match(e.lease) {
+			continue
+		}
+		requeued = append(requeued, e.lease)
+		e.history = append(e.history, global.Attempt{Agent: e.lease.Agent, Reason: reason, At: now})
+		e.lease = nil
+		e.task.Attempts++
+		if q.maxAttempts > 0 && e.task.Attempts >= q.maxAttempts {
+			delete(q.entries, id)
+			q.poison(e)
+			continue
+		}
+		q.push(e, true)
+		if e.node != nil {
+			e.node.Queued(e.task)
+		}
+	}
+	if len(requeued) > 0 {
+		q.notify()
+	}
+	return requeued
+}
+
+// poison снимает с очереди задачу, исчерпавшую попытки, вместе с её репликами.
+// Пока жива вторая копия отстающей задачи, снимается только исчерпавшая, а
+// Future решает оставшаяся; в dead-letter задача попадает, когда исчерпаны
+// обе, с историей попыток обеих.
+func (q *Queue) poison(e *entry) {
+	if other := e.sibling(); other != nil {
+		if _, alive := q.entries[other.task.ID]; alive {
+			if e.primary != nil {
+				e.primary.backup = nil
+			}
+			return
+		}
+	}
+	if e.group != nil {
+		q.drop(e.group)
+	}
+	task, history := *e.task, e.history
+	if e.primary != nil {
+		task = *e.primary.task
+		history = append(slices.Clone(e.primary.history), e.history...)
+	}
+	err := &global.PoisonTaskError{Task: task, History: history}
+	// Реплика несёт ID с суффиксом; в dead-letter попадает исходная задача.
+	if e.group != nil {
+		err.Task.ID = e.group.task.ID
+	}
+	e.future.SetError(err)
+	if e.node != nil {
+		e.node.Failed(err)
+	}
+}
+
+// InFlight возвращает число арендованных задач по агентам.
+func (q *Queue) InFlight() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	inflight := make(map[string]int)
+	for _, e := range q.entries {
+		if e.lease != nil {
+			inflight[e.lease.Agent]++
+		}
+	}
+	return inflight
+}
+
+// FailWaiting снимает с очереди невыданные задачи, для которых check вернул
+// ошибку, и завершает их Future этой ошибкой.
+func (q *Queue) FailWaiting(check func(task *global.Task, waited time.Duration) error) []*global.Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	var failed []*global.Task
+	for id, e := range q.entries {
+		if e.element == nil {
+			continue
+		}
+		err := check(e.task, now.Sub(e.enqueued))
+		if err == nil {
+			continue
+		}
+		delete(q.entries, id)
+		q.remove(e)
+		if e.group != nil {
+			q.drop(e.group)
+		}
+		q.detach(e)
+		e.future.SetError(err)
+		failed = append(failed, e.task)
+	}
+	return failed
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pendingLen()
+}
+
+// OldestAge — сколько ждёт самая старая невыданная задача.
+func (q *Queue) OldestAge() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.oldestAge(time.Now())
+}
+
+func (q *Queue) oldestAge(now time.Time) time.Duration {
+	var oldest time.Time
+	for _, u := range q.users {
+		for _, l := range u.classes {
+			for e := l.Front(); e != nil; e = e.Next() {
+				enqueued := e.Value.(*entry).enqueued
+				if oldest.IsZero() || enqueued.Before(oldest) {
+					oldest = enqueued
+				}
+			}
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return now.Sub(oldest)
+}
+
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := q.pendingLen()
+	return Stats{
+		Pending:   pending,
+		Leased:    len(q.entries) - pending,
+		OldestAge: q.oldestAge(time.Now()),
+	}
+}
+
+// SetWeight задаёт вес пользователя в планировщике; вес по умолчанию — 1.
+func (q *Queue) SetWeight(userID uint, weight int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.weights[userID] = weight
+}
+
+// Shares возвращает долю каждого пользователя, у которого есть вес, ожидающие
+// или уже выданные задачи, в порядке возрастания ID.
+func (q *Queue) Shares() []UserShare {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := make(map[uint]struct{})
+	for id := range q.weights {
+		ids[id] = struct{}{}
+	}
+	for id := range q.users {
+		ids[id] = struct{}{}
+	}
+	var total int64
+	for id, u := range q.usage {
+		ids[id] = struct{}{}
+		total += u.computeMS
+	}
+	shares := make([]UserShare, 0, len(ids))
+	for id := range ids {
+		share := UserShare{UserID: id, Weight: q.weight(id)}
+		if u, ok := q.users[id]; ok {
+			share.Pending = u.len()
+		}
+		if u, ok := q.usage[id]; ok {
+			share.Dispatched = u.dispatched
+			share.ComputeMS = u.computeMS
+			if total > 0 {
+				share.Share = float64(u.computeMS) / float64(total)
+			}
+		}
+		shares = append(shares, share)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].UserID < shares[j].UserID })
+	return shares
+}
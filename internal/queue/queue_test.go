@@ -0,0 +1,435 @@
+package queue
+
+import (
+	"calculator/internal/global"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNextIsFIFO(t *testing.T) {
+	q := New(Defaults)
+	for _, id := range []string{"a", "b", "c"} {
+		q.Submit(&global.Task{ID: id}, nil)
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		task, err := q.Next(context.Background(), "agent", time.Second)
+		if err != nil {
+			t.Fatalf("Next error: %v", err)
+		}
+		if task.ID != want {
+			t.Errorf("Next = %q, want %q", task.ID, want)
+		}
+	}
+}
+
+func TestNextBlocksUntilSubmit(t *testing.T) {
+	q := New(Defaults)
+	got := make(chan string, 1)
+	go func() {
+		task, err := q.Next(context.Background(), "agent", time.Second)
+		if err == nil {
+			got <- task.ID
+		}
+	}()
+	select {
+	case id := <-got:
+		t.Fatalf("Next returned %q from an empty queue", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.Submit(&global.Task{ID: "x"}, nil)
+	select {
+	case id := <-got:
+		if id != "x" {
+			t.Errorf("Next = %q, want x", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next was not woken up by Submit")
+	}
+}
+
+func TestNextRespectsContext(t *testing.T) {
+	q := New(Defaults)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Next(ctx, "agent", time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Next error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestReleaseAndRequeueGoToFront(t *testing.T) {
+	q := New(Defaults)
+	q.Submit(&global.Task{ID: "first"}, nil)
+	q.Submit(&global.Task{ID: "second"}, nil)
+	task, _ := q.Next(context.Background(), "agent", time.Second)
+	q.Release(task.ID)
+	if task, _ = q.Next(context.Background(), "agent", -time.Second); task.ID != "first" {
+		t.Fatalf("after Release Next = %q, want first", task.ID)
+	}
+	expired := q.RequeueExpired(time.Now())
+	if len(expired) != 1 || expired[0].Task.ID != "first" || expired[0].Agent != "agent" {
+		t.Fatalf("RequeueExpired = %+v", expired)
+	}
+	if task.Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", task.Attempts)
+	}
+	if task, _ = q.Next(context.Background(), "agent", time.Second); task.ID != "first" {
+		t.Errorf("after requeue Next = %q, want first", task.ID)
+	}
+}
+
+func TestCompleteIsIdempotent(t *testing.T) {
+	q := New(Defaults)
+	trace := global.NewTrace()
+	node := trace.Operation("+", trace.Number(1), trace.Number(2))
+	fut := q.Submit(&global.Task{ID: "t"}, node)
+	q.Next(context.Background(), "agent", time.Second)
+	lease, resolved := q.Complete("t", "agent", 3)
+	if !resolved || lease == nil || lease.Agent != "agent" {
+		t.Fatalf("Complete = %+v, %v", lease, resolved)
+	}
+	if _, resolved := q.Complete("t", "agent", 4); resolved {
+		t.Error("second Complete must be ignored")
+	}
+	if got := fut.Get(); got != 3 {
+		t.Errorf("Future.Get() = %v, want 3", got)
+	}
+	if node.Status != "solved" || node.Agent != "agent" {
+		t.Errorf("trace node = %+v", node)
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	q := New(Defaults)
+	q.Submit(&global.Task{ID: "a"}, nil)
+	q.Submit(&global.Task{ID: "b"}, nil)
+	q.Next(context.Background(), "agent", -time.Second)
+	q.Withdraw([]string{"a", "b"})
+	if stats := q.Stats(); stats.Pending != 0 || stats.Leased != 0 {
+		t.Errorf("Stats = %+v, want empty queue", stats)
+	}
+	if expired := q.RequeueExpired(time.Now()); len(expired) != 0 {
+		t.Errorf("withdrawn lease was requeued: %+v", expired)
+	}
+	if _, resolved := q.Complete("a", "agent", 1); resolved {
+		t.Error("withdrawn task must not be completed")
+	}
+}
+
+func TestOldestAge(t *testing.T) {
+	q := New(Defaults)
+	if age := q.OldestAge(); age != 0 {
+		t.Errorf("OldestAge of empty queue = %v", age)
+	}
+	q.Submit(&global.Task{ID: "a"}, nil)
+	time.Sleep(10 * time.Millisecond)
+	q.Submit(&global.Task{ID: "b"}, nil)
+	if age := q.OldestAge(); age < 10*time.Millisecond {
+		t.Errorf("OldestAge = %v, want at least 10ms", age)
+	}
+	if q.Len() != 2 {
+		t.Errorf("Len = %d, want 2", q.Len())
+	}
+}
+
+func TestConcurrentSubmitAndNext(t *testing.T) {
+	q := New(Defaults)
+	const n = 100
+	var wg sync.WaitGroup
+	seen := make(chan string, n)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			for {
+				task, err := q.Next(ctx, "agent", time.Second)
+				if err != nil {
+					return
+				}
+				seen <- task.ID
+				q.Complete(task.ID, "agent", 0)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		q.Submit(&global.Task{ID: fmt.Sprint(i)}, nil)
+	}
+	unique := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		select {
+		case id := <-seen:
+			if unique[id] {
+				t.Fatalf("task %s dispatched twice", id)
+			}
+			unique[id] = true
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of %d tasks dispatched", i, n)
+		}
+	}
+	wg.Wait()
+}
+
+func TestNextPrefersHigherPriority(t *testing.T) {
+	q := New(Defaults)
+	q.Submit(&global.Task{ID: "low", Priority: global.PriorityLow}, nil)
+	q.Submit(&global.Task{ID: "normal", Priority: global.PriorityNormal}, nil)
+	q.Submit(&global.Task{ID: "high", Priority: global.PriorityHigh}, nil)
+	for _, want := range []string{"high", "normal", "low"} {
+		task, _ := q.Next(context.Background(), "agent", time.Second)
+		if task.ID != want {
+			t.Errorf("Next = %q, want %q", task.ID, want)
+		}
+	}
+}
+
+func TestAgingPreventsStarvation(t *testing.T) {
+	q := New(Defaults)
+	q.aging = 10 * time.Millisecond
+	q.Submit(&global.Task{ID: "old-low", Priority: global.PriorityLow}, nil)
+	time.Sleep(30 * time.Millisecond)
+	q.Submit(&global.Task{ID: "fresh-high", Priority: global.PriorityHigh}, nil)
+	task, _ := q.Next(context.Background(), "agent", time.Second)
+	if task.ID != "old-low" {
+		t.Errorf("Next = %q, want the aged low-priority task", task.ID)
+	}
+}
+
+func dispatchOrder(t *testing.T, q *Queue, n int) []uint {
+	t.Helper()
+	var users []uint
+	for range n {
+		task, err := q.Next(context.Background(), "agent", time.Second)
+		if err != nil {
+			t.Fatalf("Next error: %v", err)
+		}
+		users = append(users, task.UserID)
+	}
+	return users
+}
+
+func TestUsersShareDispatchFairly(t *testing.T) {
+	q := New(Defaults)
+	for i := range 10 {
+		q.Submit(&global.Task{ID: fmt.Sprintf("heavy-%d", i), UserID: 1, OperationTime: 100}, nil)
+	}
+	q.Submit(&global.Task{ID: "light-0", UserID: 2, OperationTime: 100}, nil)
+	q.Submit(&global.Task{ID: "light-1", UserID: 2, OperationTime: 100}, nil)
+	users := dispatchOrder(t, q, 12)
+	last := 0
+	for i, user := range users {
+		if user == 2 {
+			last = i
+		}
+	}
+	if last >= 4 {
+		t.Errorf("dispatch order = %v, user 2 waited behind user 1's backlog", users)
+	}
+}
+
+func TestWeightsSplitDispatch(t *testing.T) {
+	q := New(Defaults)
+	q.SetWeight(1, 3)
+	for i := range 20 {
+		q.Submit(&global.Task{ID: fmt.Sprintf("a-%d", i), UserID: 1, OperationTime: 1000}, nil)
+		q.Submit(&global.Task{ID: fmt.Sprintf("b-%d", i), UserID: 2, OperationTime: 1000}, nil)
+	}
+	counts := map[uint]int{}
+	for _, user := range dispatchOrder(t, q, 16) {
+		counts[user]++
+	}
+	if counts[1] != 12 || counts[2] != 4 {
+		t.Errorf("dispatched per user = %v, want 12:4", counts)
+	}
+}
+
+func TestPriorityBeatsFairness(t *testing.T) {
+	q := New(Defaults)
+	q.Submit(&global.Task{ID: "low", UserID: 1, Priority: global.PriorityLow}, nil)
+	q.Submit(&global.Task{ID: "high", UserID: 2, Priority: global.PriorityHigh}, nil)
+	task, _ := q.Next(context.Background(), "agent", time.Second)
+	if task.ID != "high" {
+		t.Errorf("Next = %q, want high", task.ID)
+	}
+}
+
+func TestShares(t *testing.T) {
+	q := New(Options{Aging: Defaults.Aging, Weights: map[uint]int{3: 2}})
+	q.Submit(&global.Task{ID: "a", UserID: 1, OperationTime: 300}, nil)
+	q.Submit(&global.Task{ID: "b", UserID: 2, OperationTime: 100}, nil)
+	q.Submit(&global.Task{ID: "c", UserID: 2, OperationTime: 100}, nil)
+	dispatchOrder(t, q, 3)
+	shares := q.Shares()
+	if len(shares) != 3 {
+		t.Fatalf("Shares = %+v, want 3 users", shares)
+	}
+	want := []UserShare{
+		{UserID: 1, Weight: 1, Dispatched: 1, ComputeMS: 300, Share: 0.6},
+		{UserID: 2, Weight: 1, Dispatched: 2, ComputeMS: 200, Share: 0.4},
+		{UserID: 3, Weight: 2},
+	}
+	for i := range want {
+		if shares[i] != want[i] {
+			t.Errorf("Shares[%d] = %+v, want %+v", i, shares[i], want[i])
+		}
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	weights, err := ParseWeights(" 1=3, 2=1 ,")
+	if err != nil {
+		t.Fatalf("ParseWeights error: %v", err)
+	}
+	if len(weights) != 2 || weights[1] != 3 || weights[2] != 1 {
+		t.Errorf("ParseWeights = %v", weights)
+	}
+	for _, raw := range []string{"1", "x=2", "1=0", "1=abc"} {
+		if _, err := ParseWeights(raw); err == nil {
+			t.Errorf("ParseWeights(%q) returned no error", raw)
+		}
+	}
+}
+
+func TestRequeueAgent(t *testing.T) {
+	q := New(Defaults)
+	q.Submit(&global.Task{ID: "a"}, nil)
+	q.Submit(&global.Task{ID: "b"}, nil)
+	q.Next(context.Background(), "agent-1", time.Minute)
+	q.Next(context.Background(), "agent-2", time.Minute)
+	if inflight := q.InFlight(); inflight["agent-1"] != 1 || inflight["agent-2"] != 1 {
+		t.Errorf("InFlight = %v, want one task per agent", inflight)
+	}
+	requeued := q.RequeueAgent("agent-1")
+	if len(requeued) != 1 || requeued[0].Task.ID != "a" {
+		t.Fatalf("RequeueAgent = %+v, want only task a", requeued)
+	}
+	if stats := q.Stats(); stats.Pending != 1 || stats.Leased != 1 {
+		t.Errorf("Stats = %+v, want 1 pending and 1 leased", stats)
+	}
+	if task, _ := q.Next(context.Background(), "agent-2", time.Minute); task.ID != "a" || task.Attempts != 1 {
+		t.Errorf("Next = %+v, want task a on its second attempt", task)
+	}
+}
+
+func TestReleaseAgentChargesNoAttempt(t *testing.T) {
+	q := New(Options{Aging: Defaults.Aging, MaxAttempts: 1})
+	fut := q.Submit(&global.Task{ID: "a"}, nil)
+	q.Next(context.Background(), "agent-1", time.Minute)
+	for range 3 {
+		if released := q.ReleaseAgent("agent-1"); len(released) != 1 {
+			t.Fatalf("ReleaseAgent = %+v, want task a", released)
+		}
+		q.Next(context.Background(), "agent-1", time.Minute)
+	}
+	q.ReleaseAgent("agent-1")
+	task, _ := q.Next(context.Background(), "agent-2", time.Minute)
+	if task.ID != "a" || task.Attempts != 0 {
+		t.Errorf("Next = %+v, want task a without charged attempts", task)
+	}
+	q.Complete("a", "agent-2", 1)
+	if v, err := fut.Wait(context.Background()); err != nil || v != 1 {
+		t.Errorf("future = %v, %v, want 1", v, err)
+	}
+}
+
+func TestNextMatchingSkipsUnacceptedTasks(t *testing.T) {
+	q := New(Defaults)
+	q.Submit(&global.Task{ID: "div", Operation: "/"}, nil)
+	q.Submit(&global.Task{ID: "add", Operation: "+"}, nil)
+	q.Submit(&global.Task{ID: "sub", Operation: "-"}, nil)
+	onlyAddSub := func(task *global.Task) bool { return task.Operation != "/" }
+	for _, want := range []string{"add", "sub"} {
+		task, err := q.NextMatching(context.Background(), "agent", time.Second, onlyAddSub)
+		if err != nil || task.ID != want {
+			t.Fatalf("NextMatching = %v, %v, want %s", task, err, want)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.NextMatching(ctx, "agent", time.Second, onlyAddSub); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("NextMatching error = %v, want it to wait for an acceptable task", err)
+	}
+	if task, _ := q.Next(context.Background(), "agent", time.Second); task.ID != "div" {
+		t.Errorf("Next = %q, want div", task.ID)
+	}
+}
+
+func TestFailWaiting(t *testing.T) {
+	q := New(Defaults)
+	div := q.Submit(&global.Task{ID: "div", Operation: "/"}, nil)
+	add := q.Submit(&global.Task{ID: "add", Operation: "+"}, nil)
+	unsupported := errors.New("no agent supports /")
+	failed := q.FailWaiting(func(task *global.Task, _ time.Duration) error {
+		if task.Operation == "/" {
+			return unsupported
+		}
+		return nil
+	})
+	if len(failed) != 1 || failed[0].ID != "div" {
+		t.Fatalf("FailWaiting = %+v, want only div", failed)
+	}
+	if _, err := div.Wait(context.Background()); !errors.Is(err, unsupported) {
+		t.Errorf("div future error = %v, want %v", err, unsupported)
+	}
+	if q.Len() != 1 {
+		t.Errorf("Len = %d, want 1", q.Len())
+	}
+	q.Complete("add", "agent", 1)
+	if v, err := add.Wait(context.Background()); err != nil || v != 1 {
+		t.Errorf("add future = %v, %v", v, err)
+	}
+}
+
+func TestFail(t *testing.T) {
+	q := New(Defaults)
+	trace := global.NewTrace()
+	node := trace.Operation("*", trace.Number(1e308), trace.Number(10))
+	fut := q.Submit(&global.Task{ID: "mul", Operation: "*"}, node)
+	if _, err := q.Next(context.Background(), "agent", time.Second); err != nil {
+		t.Fatalf("Next error: %v", err)
+	}
+	overflow := errors.New("overflow")
+	if _, resolved := q.Fail("mul", "agent", overflow); !resolved {
+		t.Fatal("Fail did not resolve the task")
+	}
+	if _, err := fut.Wait(context.Background()); !errors.Is(err, overflow) {
+		t.Errorf("future error = %v, want %v", err, overflow)
+	}
+	if node.Status != "failed" || node.Error != "overflow" {
+		t.Errorf("trace node = %+v, want failed with the agent error", node)
+	}
+	if _, resolved := q.Complete("mul", "agent", 1); resolved {
+		t.Error("a late result resolved a failed task")
+	}
+}
+
+func TestRequeueDeadLettersAfterMaxAttempts(t *testing.T) {
+	q := New(Defaults)
+	q.maxAttempts = 2
+	fut := q.Submit(&global.Task{ID: "poison", Operation: "/"}, nil)
+	q.Next(context.Background(), "a1", time.Second)
+	if requeued := q.RequeueAgent("a1"); len(requeued) != 1 || q.Len() != 1 {
+		t.Fatalf("first failure: requeued %d, Len %d, want the task back in the queue", len(requeued), q.Len())
+	}
+	q.Next(context.Background(), "a2", time.Second)
+	if requeued := q.RequeueExpired(time.Now().Add(time.Minute)); len(requeued) != 1 {
+		t.Fatalf("second failure: requeued %d leases, want 1", len(requeued))
+	}
+	if n := len(q.entries); n != 0 {
+		t.Fatalf("%d entries left, want the poison task removed", n)
+	}
+	_, err := fut.Wait(context.Background())
+	var poison *global.PoisonTaskError
+	if !errors.As(err, &poison) {
+		t.Fatalf("future error = %v, want *global.PoisonTaskError", err)
+	}
+	if poison.Task.ID != "poison" || len(poison.History) != 2 ||
+		poison.History[0].Agent != "a1" || poison.History[0].Reason != "agent removed" ||
+		poison.History[1].Agent != "a2" || poison.History[1].Reason != "lease expired" {
+		t.Errorf("poison = %+v", poison)
+	}
+}
@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"calculator/internal/global"
+	"time"
+)
+
+func backupID(id string) string {
+	return id + "/spec"
+}
+
+func (e *entry) sibling() *entry {
+	if e.primary != nil {
+		return e.primary
+	}
+	return e.backup
+}
+
+// detach снимает с очереди парную запись отстающей задачи, когда на одну из
+// них пришёл ответ. Если парная запись уже выдана, её агенту рассылается отмена.
+func (q *Queue) detach(e *entry) {
+	other := e.sibling()
+	if other == nil {
+		return
+	}
+	if _, ok := q.entries[other.task.ID]; !ok {
+		return
+	}
+	delete(q.entries, other.task.ID)
+	q.remove(other)
+	if other.lease != nil {
+		global.Cancellations.Publish(global.Cancellation{
+			ExpressionID: other.task.ExpressionID,
+			TaskIDs:      []string{other.task.ID},
+		})
+	}
+}
+
+// Speculate ставит в начало очереди дубликат каждой выданной задачи, для
+// которой due вернул true (outstanding — сколько задача уже у агента). Дубликат
+// получает ID с суффиксом "/spec" и уходит другому агенту; Future разрешает
+// первый пришедший ответ, а второй агент получает отмену. Реплики проверки и
+// задачи, у которых дубликат уже есть, не дублируются.
+func (q *Queue) Speculate(now time.Time, due func(lease *global.Lease, outstanding time.Duration) bool) []*global.Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var stragglers []*entry
+	for _, e := range q.entries {
+		if e.lease == nil || e.group != nil || e.sibling() != nil {
+			continue
+		}
+		if due(e.lease, now.Sub(e.lease.Started)) {
+			stragglers = append(stragglers, e)
+		}
+	}
+	backups := make([]*global.Task, 0, len(stragglers))
+	for _, e := range stragglers {
+		task := *e.task
+		task.ID = backupID(e.task.ID)
+		// Попытки считаются для каждой копии отдельно: неудачи дубликата не должны снимать исходную задачу.
+		task.Attempts = 0
+		backup := &entry{task: &task, future: e.future, node: e.node, enqueued: e.enqueued, primary: e}
+		e.backup = backup
+		q.push(backup, true)
+		q.entries[task.ID] = backup
+		backups = append(backups, backup.task)
+	}
+	if len(backups) > 0 {
+		q.notify()
+	}
+	return backups
+}
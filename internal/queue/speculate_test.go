@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"calculator/internal/global"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSpeculateDuplicatesStragglers(t *testing.T) {
+	cancellations, unsubscribe := global.Cancellations.Subscribe()
+	defer unsubscribe()
+	q := New(Defaults)
+	fut := q.Submit(&global.Task{ID: "slow", ExpressionID: "e1", Operation: "+", OperationTime: 100}, nil)
+	q.Submit(&global.Task{ID: "fast", Operation: "+", OperationTime: 100}, nil)
+	mustTake(t, q, "a1")
+	mustTake(t, q, "a1")
+
+	now := time.Now().Add(time.Second)
+	backups := q.Speculate(now, func(lease *global.Lease, outstanding time.Duration) bool {
+		return lease.Task.ID == "slow" && outstanding >= 500*time.Millisecond
+	})
+	if len(backups) != 1 || backups[0].ID != "slow/spec" {
+		t.Fatalf("Speculate = %+v, want a duplicate of slow", backups)
+	}
+	if again := q.Speculate(now, func(*global.Lease, time.Duration) bool { return true }); len(again) != 1 || again[0].ID != "fast/spec" {
+		t.Fatalf("second Speculate = %+v, want only fast (slow already has a duplicate)", again)
+	}
+	if q.TryNext("a1", time.Second, nil) != nil {
+		t.Fatal("a duplicate went to the agent that holds the original")
+	}
+	backup := mustTake(t, q, "a2")
+	if backup.ID != "slow/spec" {
+		backup = mustTake(t, q, "a2")
+	}
+	if _, resolved := q.Complete(backup.ID, "a2", 7); !resolved {
+		t.Fatal("duplicate result was not accepted")
+	}
+	if got := fut.Get(); got != 7 {
+		t.Errorf("Future.Get() = %v, want 7", got)
+	}
+	select {
+	case c := <-cancellations:
+		if c.ExpressionID != "e1" || len(c.TaskIDs) != 1 || c.TaskIDs[0] != "slow" {
+			t.Errorf("cancellation = %+v, want the original on the slow agent", c)
+		}
+	default:
+		t.Error("the slow agent was not told to cancel")
+	}
+	if _, resolved := q.Complete("slow", "a1", 3); resolved {
+		t.Error("the late original result was accepted")
+	}
+}
+
+func TestWithdrawRemovesDuplicate(t *testing.T) {
+	q := New(Defaults)
+	q.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	mustTake(t, q, "a1")
+	q.Speculate(time.Now(), func(*global.Lease, time.Duration) bool { return true })
+	if withdrawn := q.Withdraw([]string{"t1"}); len(withdrawn) != 2 {
+		t.Errorf("Withdraw = %v, want the task and its duplicate", withdrawn)
+	}
+	if n := len(q.entries); n != 0 {
+		t.Errorf("%d entries left after Withdraw", n)
+	}
+}
+
+func TestPoisonedDuplicateKeepsPrimary(t *testing.T) {
+	q := New(Defaults)
+	q.maxAttempts = 2
+	fut := q.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	q.TryNext("a1", -time.Second, nil)
+	q.RequeueExpired(time.Now())
+	mustTake(t, q, "a1")
+	q.Speculate(time.Now(), func(*global.Lease, time.Duration) bool { return true })
+	backup := mustTake(t, q, "a2")
+	if backup.Attempts != 0 {
+		t.Errorf("duplicate Attempts = %d, want 0", backup.Attempts)
+	}
+	for range 2 {
+		q.RequeueAgent("a2")
+		if q.TryNext("a2", time.Second, nil) == nil {
+			break
+		}
+	}
+	if _, ok := q.entries["t1/spec"]; ok {
+		t.Fatal("duplicate that exhausted its attempts is still queued")
+	}
+	if _, ok := q.entries["t1"]; !ok {
+		t.Fatal("the primary was removed with its duplicate")
+	}
+	if _, resolved := q.Complete("t1", "a1", 5); !resolved {
+		t.Fatal("primary result was not accepted")
+	}
+	if v, err := fut.Wait(context.Background()); err != nil || v != 5 {
+		t.Errorf("future = %v, %v, want 5", v, err)
+	}
+}
+
+func TestPoisonedPrimaryLeavesDecisionToDuplicate(t *testing.T) {
+	cancellations, unsubscribe := global.Cancellations.Subscribe()
+	defer unsubscribe()
+	q := New(Defaults)
+	q.maxAttempts = 2
+	fut := q.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	q.TryNext("a1", -time.Second, nil)
+	q.RequeueExpired(time.Now())
+	mustTake(t, q, "a1")
+	q.Speculate(time.Now(), func(*global.Lease, time.Duration) bool { return true })
+	mustTake(t, q, "a2")
+
+	q.RequeueAgent("a1")
+	if _, ok := q.entries["t1/spec"]; !ok {
+		t.Fatal("the duplicate was removed with the exhausted primary")
+	}
+	select {
+	case c := <-cancellations:
+		t.Fatalf("cancellation %+v sent to the healthy duplicate", c)
+	default:
+	}
+	if _, resolved := q.Complete("t1/spec", "a2", 5); !resolved {
+		t.Fatal("duplicate result was not accepted")
+	}
+	if v, err := fut.Wait(context.Background()); err != nil || v != 5 {
+		t.Errorf("future = %v, %v, want 5", v, err)
+	}
+}
+
+func TestPoisonWaitsForBothCopies(t *testing.T) {
+	q := New(Defaults)
+	q.maxAttempts = 1
+	fut := q.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	mustTake(t, q, "a1")
+	q.Speculate(time.Now(), func(*global.Lease, time.Duration) bool { return true })
+	mustTake(t, q, "a2")
+
+	q.RequeueAgent("a1")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := fut.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("future error = %v, want it to wait for the duplicate", err)
+	}
+	q.RequeueAgent("a2")
+	_, err := fut.Wait(context.Background())
+	var poison *global.PoisonTaskError
+	if !errors.As(err, &poison) {
+		t.Fatalf("future error = %v, want PoisonTaskError", err)
+	}
+	if poison.Task.ID != "t1" || len(poison.History) != 2 || poison.History[0].Agent != "a1" || poison.History[1].Agent != "a2" {
+		t.Errorf("poison = %+v, want t1 with the attempts of both copies", poison)
+	}
+	if n := len(q.entries); n != 0 {
+		t.Errorf("%d entries left", n)
+	}
+}
+
+func TestWithdrawRemovesOrphanedDuplicate(t *testing.T) {
+	q := New(Defaults)
+	q.maxAttempts = 1
+	q.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	mustTake(t, q, "a1")
+	q.Speculate(time.Now(), func(*global.Lease, time.Duration) bool { return true })
+	q.RequeueAgent("a1")
+	if withdrawn := q.Withdraw([]string{"t1"}); len(withdrawn) != 1 || withdrawn[0] != "t1/spec" {
+		t.Errorf("Withdraw = %v, want the duplicate of the exhausted task", withdrawn)
+	}
+}
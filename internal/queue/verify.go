@@ -0,0 +1,166 @@
+package queue
+
+import (
+	"calculator/internal/global"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// verification — задача, которую независимо выполняют несколько агентов.
+// Каждая копия (реплика) лежит в очереди отдельной записью; Future задачи
+// разрешается, только когда два агента сошлись в результате. При расхождении
+// задача уходит третьему агенту, и решает большинство.
+type verification struct {
+	task     *global.Task
+	future   *global.Future
+	node     *global.TraceNode
+	replicas []string
+	outcomes map[string]outcome
+}
+
+// outcome — ответ агента: результат или текст ошибки.
+type outcome struct {
+	value float64
+	err   string
+}
+
+const (
+	// replicas — сколько агентов выполняют проверяемую задачу сразу.
+	replicas = 2
+	// arbiters — сколько реплик можно запустить всего, включая арбитра при расхождении.
+	arbiters = 3
+)
+
+func (q *Queue) sampled() bool {
+	return q.verifyRate > 0 && rand.Float64() < q.verifyRate
+}
+
+// submitVerified ставит в очередь реплики задачи. Первая реплика сохраняет
+// исходный ID, остальные получают суффикс "/n".
+func (q *Queue) submitVerified(task *global.Task, node *global.TraceNode) *global.Future {
+	g := &verification{task: task, future: global.NewFuture(), node: node, outcomes: make(map[string]outcome)}
+	q.groups[task.ID] = g
+	for range replicas {
+		q.addReplica(g, false)
+	}
+	return g.future
+}
+
+func (q *Queue) addReplica(g *verification, front bool) {
+	replica := *g.task
+	if n := len(g.replicas); n > 0 {
+		replica.ID = fmt.Sprintf("%s/%d", g.task.ID, n+1)
+	}
+	e := &entry{task: &replica, future: g.future, node: g.node, enqueued: time.Now(), group: g}
+	q.push(e, front)
+	q.entries[replica.ID] = e
+	g.replicas = append(g.replicas, replica.ID)
+}
+
+// involves сообщает, выполнял ли агент уже какую-то реплику задачи: реплики
+// должны достаться разным агентам.
+func (q *Queue) involves(g *verification, agent string) bool {
+	if _, ok := g.outcomes[agent]; ok {
+		return true
+	}
+	for _, id := range g.replicas {
+		if e, ok := q.entries[id]; ok && e.lease != nil && e.lease.Agent == agent {
+			return true
+		}
+	}
+	return false
+}
+
+// drop снимает с очереди все оставшиеся реплики задачи.
+func (q *Queue) drop(g *verification) {
+	delete(q.groups, g.task.ID)
+	for _, id := range g.replicas {
+		if e, ok := q.entries[id]; ok {
+			delete(q.entries, id)
+			q.remove(e)
+		}
+	}
+}
+
+// settle учитывает ответ агента по реплике. Засчитывается только ответ того,
+// кто держит аренду реплики, и только один ответ от агента: иначе агент, у
+// которого истекла аренда одной реплики, мог бы сам с собой «согласиться».
+func (q *Queue) settle(e *entry, agent string, o outcome) bool {
+	g := e.group
+	if e.lease == nil || e.lease.Agent != agent {
+		return false
+	}
+	if _, answered := g.outcomes[agent]; answered {
+		return false
+	}
+	delete(q.entries, e.task.ID)
+	q.remove(e)
+	g.outcomes[agent] = o
+	q.decide(g)
+	return true
+}
+
+// decide разрешает Future, как только два агента дали одинаковый ответ, и
+// помечает подозрительными агентов, ответивших иначе.
+func (q *Queue) decide(g *verification) {
+	votes := make(map[outcome]int)
+	for _, o := range g.outcomes {
+		votes[o]++
+	}
+	for o, n := range votes {
+		if n < 2 {
+			continue
+		}
+		q.drop(g)
+		for agent, other := range g.outcomes {
+			if other != o {
+				q.suspects = append(q.suspects, agent)
+			}
+		}
+		if o.err != "" {
+			err := errors.New(o.err)
+			g.future.SetError(err)
+			if g.node != nil {
+				g.node.Failed(err)
+			}
+			return
+		}
+		g.future.SetResult(o.value)
+		if g.node != nil {
+			g.node.Solved(o.value)
+		}
+		return
+	}
+	if len(g.replicas) < arbiters {
+		if len(g.outcomes) == len(g.replicas) {
+			// Агенты разошлись: задачу решит третий.
+			q.addReplica(g, true)
+			q.notify()
+		}
+		return
+	}
+	if len(g.outcomes) < arbiters {
+		return
+	}
+	q.drop(g)
+	for agent := range g.outcomes {
+		q.suspects = append(q.suspects, agent)
+	}
+	err := fmt.Errorf("agents disagree on the result of %s", g.task.ID)
+	g.future.SetError(err)
+	if g.node != nil {
+		g.node.Failed(err)
+	}
+}
+
+// Suspects возвращает и очищает список агентов, чей результат разошёлся с
+// большинством при проверке.
+func (q *Queue) Suspects() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	suspects := q.suspects
+	q.suspects = nil
+	return suspects
+}
@@ -0,0 +1,152 @@
+package queue
+
+import (
+	"calculator/internal/global"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func verifiedQueue() *Queue {
+	q := New(Defaults)
+	q.verifyRate = 1
+	return q
+}
+
+func mustTake(t *testing.T, q *Queue, agent string) *global.Task {
+	t.Helper()
+	task := q.TryNext(agent, time.Second, nil)
+	if task == nil {
+		t.Fatalf("no task for %s", agent)
+	}
+	return task
+}
+
+func TestVerificationResolvesOnAgreement(t *testing.T) {
+	q := verifiedQueue()
+	trace := global.NewTrace()
+	node := trace.Operation("+", trace.Number(1), trace.Number(2))
+	fut := q.Submit(&global.Task{ID: "t1", Operation: "+"}, node)
+	first := mustTake(t, q, "a1")
+	if q.TryNext("a1", time.Second, nil) != nil {
+		t.Fatal("the same agent received both replicas")
+	}
+	second := mustTake(t, q, "a2")
+	if first.ID == second.ID {
+		t.Fatalf("replicas share ID %s", first.ID)
+	}
+	if _, resolved := q.Complete(first.ID, "a1", 3); !resolved {
+		t.Fatal("first replica result was not accepted")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := fut.Wait(ctx); err == nil {
+		t.Fatal("future resolved after a single result")
+	}
+	q.Complete(second.ID, "a2", 3)
+	if v, err := fut.Wait(context.Background()); err != nil || v != 3 {
+		t.Errorf("future = %v, %v, want 3", v, err)
+	}
+	if node.Status != "solved" {
+		t.Errorf("trace node status = %q, want solved", node.Status)
+	}
+	if suspects := q.Suspects(); len(suspects) != 0 {
+		t.Errorf("suspects = %v, want none", suspects)
+	}
+	if n := len(q.entries); n != 0 {
+		t.Errorf("%d entries left", n)
+	}
+}
+
+func TestVerificationArbitratesDisagreement(t *testing.T) {
+	q := verifiedQueue()
+	fut := q.Submit(&global.Task{ID: "t1", Operation: "*"}, nil)
+	q.Complete(mustTake(t, q, "honest").ID, "honest", 6)
+	q.Complete(mustTake(t, q, "liar").ID, "liar", 7)
+	if q.TryNext("honest", time.Second, nil) != nil || q.TryNext("liar", time.Second, nil) != nil {
+		t.Fatal("the arbiter replica went to an agent that already answered")
+	}
+	arbiter := mustTake(t, q, "third")
+	if arbiter.ID != "t1/3" {
+		t.Errorf("arbiter replica ID = %q, want t1/3", arbiter.ID)
+	}
+	q.Complete(arbiter.ID, "third", 6)
+	if v, err := fut.Wait(context.Background()); err != nil || v != 6 {
+		t.Errorf("future = %v, %v, want the majority result 6", v, err)
+	}
+	if suspects := q.Suspects(); !reflect.DeepEqual(suspects, []string{"liar"}) {
+		t.Errorf("suspects = %v, want [liar]", suspects)
+	}
+	if suspects := q.Suspects(); len(suspects) != 0 {
+		t.Errorf("Suspects did not clear the list: %v", suspects)
+	}
+}
+
+func TestVerificationFailsWithoutMajority(t *testing.T) {
+	q := verifiedQueue()
+	fut := q.Submit(&global.Task{ID: "t1", Operation: "-"}, nil)
+	q.Complete(mustTake(t, q, "a1").ID, "a1", 1)
+	q.Complete(mustTake(t, q, "a2").ID, "a2", 2)
+	q.Fail(mustTake(t, q, "a3").ID, "a3", errors.New("overflow"))
+	if _, err := fut.Wait(context.Background()); err == nil {
+		t.Fatal("future resolved although all agents disagree")
+	}
+	if suspects := q.Suspects(); len(suspects) != 3 {
+		t.Errorf("suspects = %v, want all three agents", suspects)
+	}
+}
+
+func TestVerificationWithdraw(t *testing.T) {
+	q := verifiedQueue()
+	q.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	mustTake(t, q, "a1")
+	q.Withdraw([]string{"t1"})
+	if n := len(q.entries); n != 0 {
+		t.Errorf("%d replicas left after Withdraw", n)
+	}
+}
+
+func TestVerificationWithdrawAfterFirstReplica(t *testing.T) {
+	q := verifiedQueue()
+	q.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	first, second := mustTake(t, q, "a1"), mustTake(t, q, "a2")
+	q.Complete(first.ID, "a1", 3)
+	withdrawn := q.Withdraw([]string{"t1"})
+	if !reflect.DeepEqual(withdrawn, []string{second.ID}) {
+		t.Errorf("Withdraw = %v, want the outstanding replica %s", withdrawn, second.ID)
+	}
+	if stats := q.Stats(); stats.Pending != 0 || stats.Leased != 0 {
+		t.Errorf("Stats = %+v, want empty queue", stats)
+	}
+}
+
+func TestVerificationCountsOnlyTheLeaseHolder(t *testing.T) {
+	q := verifiedQueue()
+	fut := q.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	q.TryNext("cheat", -time.Second, nil)
+	q.RequeueExpired(time.Now())
+	first := mustTake(t, q, "honest")
+	second := mustTake(t, q, "cheat")
+	if _, resolved := q.Complete(first.ID, "cheat", 999); resolved {
+		t.Fatal("result from an agent without the lease was accepted")
+	}
+	q.Complete(second.ID, "cheat", 999)
+	if _, resolved := q.Complete(second.ID, "cheat", 999); resolved {
+		t.Fatal("the same agent voted twice")
+	}
+	q.Complete(first.ID, "honest", 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if v, err := fut.Wait(ctx); err == nil {
+		t.Fatalf("future resolved to %v without agreement of two agents", v)
+	}
+	q.Complete(mustTake(t, q, "third").ID, "third", 3)
+	if v, err := fut.Wait(context.Background()); err != nil || v != 3 {
+		t.Errorf("future = %v, %v, want 3", v, err)
+	}
+	if suspects := q.Suspects(); !reflect.DeepEqual(suspects, []string{"cheat"}) {
+		t.Errorf("suspects = %v, want [cheat]", suspects)
+	}
+}
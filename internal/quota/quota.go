@@ -0,0 +1,239 @@
+package quota
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limits — ограничения пользователя; 0 означает «без ограничения».
+type Limits struct {
+	MaxConcurrent       int `json:"max_concurrent"`
+	MaxTasksPerMinute   int `json:"max_tasks_per_minute"`
+	MaxExpressionLength int `json:"max_expression_length"`
+	MaxOperations       int `json:"max_operations"`
+}
+
+func (l Limits) Validate() error {
+	if l.MaxConcurrent < 0 || l.MaxTasksPerMinute < 0 || l.MaxExpressionLength < 0 || l.MaxOperations < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
+
+const (
+	LimitConcurrent       = "max_concurrent"
+	LimitTasksPerMinute   = "max_tasks_per_minute"
+	LimitExpressionLength = "max_expression_length"
+	LimitOperations       = "max_operations"
+)
+
+// Exceeded описывает нарушенное ограничение. RetryAfter известен только для
+// лимита задач в минуту.
+type Exceeded struct {
+	Limit      string
+	Max        int
+	Current    int
+	RetryAfter time.Duration
+}
+
+func (e *Exceeded) Error() string {
+	return fmt.Sprintf("quota exceeded: %s is %d, requested %d", e.Limit, e.Max, e.Current)
+}
+
+const window = time.Minute
+
+// Defaults — ограничения по умолчанию, если они не заданы в конфигурации.
+var Defaults = Limits{
+	MaxConcurrent:       50,
+	MaxTasksPerMinute:   1000,
+	MaxExpressionLength: 10000,
+	MaxOperations:       500,
+}
+
+type usage struct {
+	at    time.Time
+	tasks int
+}
+
+// Manager хранит персональные квоты и учитывает задачи каждого пользователя
+// в скользящем окне длиной в минуту.
+type Manager struct {
+	mu        sync.Mutex
+	defaults  Limits
+	overrides map[uint]Limits
+	usage     map[uint][]usage
+	// submitting — по блокировке на пользователя: его отправки выражений идут по одной (см. Submit).
+	submitting map[uint]*sync.Mutex
+}
+
+func NewManager(defaults Limits) *Manager {
+	return &Manager{
+		defaults:   defaults,
+		overrides:  make(map[uint]Limits),
+		usage:      make(map[uint][]usage),
+		submitting: make(map[uint]*sync.Mutex),
+	}
+}
+
+// Limits возвращает действующие ограничения пользователя и признак персональной квоты.
+func (m *Manager) Limits(userID uint) (Limits, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.limits(userID)
+}
+
+func (m *Manager) limits(userID uint) (Limits, bool) {
+	if l, ok := m.overrides[userID]; ok {
+		return l, true
+	}
+	return m.defaults, false
+}
+
+// Defaults — ограничения для пользователей без персональной квоты.
+func (m *Manager) Defaults() Limits {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.defaults
+}
+
+func (m *Manager) SetDefaults(limits Limits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults = limits
+	return nil
+}
+
+func (m *Manager) Set(userID uint, limits Limits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overrides[userID] = limits
+	return nil
+}
+
+// Reset возвращает пользователя к ограничениям по умолчанию.
+func (m *Manager) Reset(userID uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.overrides, userID)
+}
+
+// used отбрасывает записи старше окна и возвращает число задач в окне.
+func (m *Manager) used(userID uint, now time.Time) int {
+	records := m.usage[userID]
+	i := 0
+	for i < len(records) && now.Sub(records[i].at) >= window {
+		i++
+	}
+	records = records[i:]
+	if len(records) == 0 {
+		delete(m.usage, userID)
+	} else {
+		m.usage[userID] = records
+	}
+	total := 0
+	for _, r := range records {
+		total += r.tasks
+	}
+	return total
+}
+
+// Used — сколько задач пользователь отправил за последнюю минуту.
+func (m *Manager) Used(userID uint, now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.used(userID, now)
+}
+
+// retryAfter — через сколько в окне освободится место под tasks задач.
+func (m *Manager) retryAfter(userID uint, limit, tasks int, now time.Time) time.Duration {
+	used := m.used(userID, now)
+	for _, r := range m.usage[userID] {
+		used -= r.tasks
+		if used+tasks <= limit {
+			return r.at.Add(window).Sub(now)
+		}
+	}
+	return window
+}
+
+// Admit проверяет выражение пользователя: active — сколько его выражений уже
+// вычисляется, length — длина выражения, tasks — число операций в нём. При
+// успехе задачи засчитываются в минутное окно; при отказе возвращается *Exceeded.
+func (m *Manager) Admit(userID uint, active, length, tasks int, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.admit(userID, active, length, tasks, now); err != nil {
+		return err
+	}
+	m.record(userID, tasks, now)
+	return nil
+}
+
+// Submit как Admit, но сам считает активные выражения функцией active и при
+// успехе вызывает create. Подсчёт и create для одного пользователя выполняются
+// по очереди, поэтому параллельные отправки не превышают MaxConcurrent. Задачи
+// засчитываются в окно, только если create завершился без ошибки.
+func (m *Manager) Submit(userID uint, length, tasks int, now time.Time, active func() (int, error), create func() error) error {
+	m.mu.Lock()
+	lock, ok := m.submitting[userID]
+	if !ok {
+		lock = new(sync.Mutex)
+		m.submitting[userID] = lock
+	}
+	m.mu.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	n, err := active()
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	err = m.admit(userID, n, length, tasks, now)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := create(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record(userID, tasks, now)
+	return nil
+}
+
+func (m *Manager) admit(userID uint, active, length, tasks int, now time.Time) error {
+	limits, _ := m.limits(userID)
+	if limits.MaxExpressionLength > 0 && length > limits.MaxExpressionLength {
+		return &Exceeded{Limit: LimitExpressionLength, Max: limits.MaxExpressionLength, Current: length}
+	}
+	if limits.MaxOperations > 0 && tasks > limits.MaxOperations {
+		return &Exceeded{Limit: LimitOperations, Max: limits.MaxOperations, Current: tasks}
+	}
+	if limits.MaxConcurrent > 0 && active >= limits.MaxConcurrent {
+		return &Exceeded{Limit: LimitConcurrent, Max: limits.MaxConcurrent, Current: active + 1}
+	}
+	used := m.used(userID, now)
+	if limits.MaxTasksPerMinute > 0 && used+tasks > limits.MaxTasksPerMinute {
+		exceeded := &Exceeded{Limit: LimitTasksPerMinute, Max: limits.MaxTasksPerMinute, Current: used + tasks}
+		if tasks <= limits.MaxTasksPerMinute {
+			exceeded.RetryAfter = m.retryAfter(userID, limits.MaxTasksPerMinute, tasks, now)
+		}
+		return exceeded
+	}
+	return nil
+}
+
+func (m *Manager) record(userID uint, tasks int, now time.Time) {
+	if tasks > 0 {
+		m.usage[userID] = append(m.usage[userID], usage{at: now, tasks: tasks})
+	}
+}
@@ -0,0 +1,144 @@
+package quota
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"calculator/pkg/loggers"
+)
+
+func TestMain(m *testing.M) {
+	loggers.InitLogger("orchestrator", os.DevNull)
+	code := m.Run()
+	loggers.CloseAllLoggers()
+	os.Exit(code)
+}
+
+func exceededLimit(t *testing.T, err error) *Exceeded {
+	t.Helper()
+	var exceeded *Exceeded
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("Admit error = %v, want *Exceeded", err)
+	}
+	return exceeded
+}
+
+func TestSetDefaults(t *testing.T) {
+	m := NewManager(Defaults)
+	m.Set(2, Limits{MaxConcurrent: 9})
+	if err := m.SetDefaults(Limits{MaxConcurrent: 3}); err != nil {
+		t.Fatalf("SetDefaults error: %v", err)
+	}
+	if limits, custom := m.Limits(1); custom || limits.MaxConcurrent != 3 {
+		t.Errorf("Limits(1) = %+v, %v, want the new defaults", limits, custom)
+	}
+	if limits, _ := m.Limits(2); limits.MaxConcurrent != 9 {
+		t.Errorf("Limits(2) = %+v, want the personal quota kept", limits)
+	}
+	if err := m.SetDefaults(Limits{MaxOperations: -1}); err == nil || m.Defaults().MaxConcurrent != 3 {
+		t.Errorf("SetDefaults accepted a negative limit: %v", err)
+	}
+}
+
+func TestAdmitRejectsEachLimit(t *testing.T) {
+	m := NewManager(Defaults)
+	m.Set(1, Limits{MaxConcurrent: 2, MaxTasksPerMinute: 10, MaxExpressionLength: 20, MaxOperations: 5})
+	now := time.Now()
+	cases := []struct {
+		active, length, tasks int
+		limit                 string
+	}{
+		{0, 21, 1, LimitExpressionLength},
+		{0, 10, 6, LimitOperations},
+		{2, 10, 1, LimitConcurrent},
+	}
+	for _, tc := range cases {
+		if got := exceededLimit(t, m.Admit(1, tc.active, tc.length, tc.tasks, now)).Limit; got != tc.limit {
+			t.Errorf("Admit(%d, %d, %d) violated %s, want %s", tc.active, tc.length, tc.tasks, got, tc.limit)
+		}
+	}
+	if used := m.Used(1, now); used != 0 {
+		t.Errorf("rejected submissions were counted: used = %d", used)
+	}
+}
+
+func TestTasksPerMinuteWindow(t *testing.T) {
+	m := NewManager(Defaults)
+	m.Set(1, Limits{MaxTasksPerMinute: 5})
+	start := time.Now()
+	if err := m.Admit(1, 0, 1, 3, start); err != nil {
+		t.Fatalf("first Admit: %v", err)
+	}
+	if err := m.Admit(1, 0, 1, 2, start.Add(20*time.Second)); err != nil {
+		t.Fatalf("second Admit: %v", err)
+	}
+	exceeded := exceededLimit(t, m.Admit(1, 0, 1, 3, start.Add(30*time.Second)))
+	if exceeded.Limit != LimitTasksPerMinute || exceeded.Current != 8 {
+		t.Errorf("exceeded = %+v", exceeded)
+	}
+	if exceeded.RetryAfter != 30*time.Second {
+		t.Errorf("RetryAfter = %v, want 30s", exceeded.RetryAfter)
+	}
+	if err := m.Admit(1, 0, 1, 3, start.Add(time.Minute)); err != nil {
+		t.Errorf("Admit after the window moved: %v", err)
+	}
+	if err := m.Admit(2, 0, 1, 3, start); err != nil {
+		t.Errorf("other user was limited: %v", err)
+	}
+}
+
+func TestSetAndReset(t *testing.T) {
+	t.Setenv("QUOTA_MAX_CONCURRENT", "")
+	m := NewManager(Defaults)
+	if err := m.Set(1, Limits{MaxConcurrent: -1}); err == nil {
+		t.Error("Set accepted a negative limit")
+	}
+	m.Set(1, Limits{MaxConcurrent: 1})
+	if limits, custom := m.Limits(1); !custom || limits.MaxConcurrent != 1 {
+		t.Errorf("Limits = %+v, %v", limits, custom)
+	}
+	m.Reset(1)
+	if limits, custom := m.Limits(1); custom || limits != Defaults {
+		t.Errorf("after Reset Limits = %+v, %v", limits, custom)
+	}
+}
+
+func TestSubmitSerializesConcurrentSubmissions(t *testing.T) {
+	m := NewManager(Limits{MaxConcurrent: 3})
+	var active atomic.Int32
+	count := func() (int, error) {
+		n := active.Load()
+		time.Sleep(time.Millisecond)
+		return int(n), nil
+	}
+	create := func() error {
+		active.Add(1)
+		return nil
+	}
+	var wg sync.WaitGroup
+	var admitted atomic.Int32
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m.Submit(1, 1, 1, time.Now(), count, create) == nil {
+				admitted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if admitted.Load() != 3 || active.Load() != 3 {
+		t.Errorf("admitted %d submissions, %d active, want 3", admitted.Load(), active.Load())
+	}
+	failing := errors.New("insert failed")
+	if err := m.Submit(2, 1, 5, time.Now(), func() (int, error) { return 0, nil }, func() error { return failing }); !errors.Is(err, failing) {
+		t.Fatalf("Submit error = %v, want the create error", err)
+	}
+	if used := m.Used(2, time.Now()); used != 0 {
+		t.Errorf("Used = %d after a failed create, want 0", used)
+	}
+}
@@ -0,0 +1,94 @@
+package recovery
+
+import (
+	"calculator/internal/global"
+	"calculator/pkg/loggers"
+	"sync"
+	"time"
+)
+
+// Нетерминальные статусы: выражения в них после перезапуска нужно довычислить.
+var statuses = []string{"pending", "processing"}
+
+type store interface {
+	GetExpressionsByStatus(status string) ([]global.ExpressionDTO, error)
+	GetTaskRecords(expressionID string) ([]global.TaskRecord, error)
+}
+
+type Resumed struct {
+	ID              string `json:"id"`
+	UserID          uint   `json:"user_id"`
+	Status          string `json:"status"`
+	SolvedTasks     int    `json:"solved_tasks"`
+	UnfinishedTasks int    `json:"unfinished_tasks"`
+}
+
+type Summary struct {
+	StartedAt   time.Time      `json:"started_at"`
+	FinishedAt  time.Time      `json:"finished_at"`
+	Total       int            `json:"total"`
+	ByStatus    map[string]int `json:"by_status"`
+	Expressions []Resumed      `json:"expressions"`
+}
+
+// Recovery при старте находит выражения, прерванные перезапуском, и передаёт их
+// в resume. Итог последнего запуска доступен через Summary.
+type Recovery struct {
+	store   store
+	resume  func(expressionID string)
+	mu      sync.Mutex
+	summary Summary
+}
+
+func New(store store, resume func(expressionID string)) *Recovery {
+	return &Recovery{store: store, resume: resume}
+}
+
+func (r *Recovery) Run() (Summary, error) {
+	logger := loggers.GetLogger("general")
+	summary := Summary{StartedAt: time.Now(), ByStatus: make(map[string]int), Expressions: []Resumed{}}
+	for _, status := range statuses {
+		expressions, err := r.store.GetExpressionsByStatus(status)
+		if err != nil {
+			return Summary{}, err
+		}
+		for _, expression := range expressions {
+			records, err := r.store.GetTaskRecords(expression.ID)
+			if err != nil {
+				return Summary{}, err
+			}
+			resumed := Resumed{ID: expression.ID, UserID: expression.UserID, Status: status}
+			for _, record := range records {
+				if record.Solved {
+					resumed.SolvedTasks++
+				} else {
+					resumed.UnfinishedTasks++
+				}
+			}
+			logger.Info(
+				"resuming expression",
+				"id", resumed.ID,
+				"status", resumed.Status,
+				"solved_tasks", resumed.SolvedTasks,
+				"unfinished_tasks", resumed.UnfinishedTasks,
+			)
+			r.resume(expression.ID)
+			summary.Expressions = append(summary.Expressions, resumed)
+			summary.ByStatus[status]++
+			summary.Total++
+		}
+	}
+	summary.FinishedAt = time.Now()
+	logger.Info("recovery finished", "total", summary.Total, "by_status", summary.ByStatus)
+	r.mu.Lock()
+	r.summary = summary
+	r.mu.Unlock()
+	return summary, nil
+}
+
+// Summary возвращает итог последнего восстановления.
+func (r *Recovery) Summary() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.summary
+}
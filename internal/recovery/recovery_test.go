@@ -0,0 +1,75 @@
+package recovery
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"calculator/internal/global"
+	"calculator/pkg/loggers"
+)
+
+func TestMain(m *testing.M) {
+	loggers.InitLogger("general", os.DevNull)
+	code := m.Run()
+	loggers.CloseAllLoggers()
+	os.Exit(code)
+}
+
+type fakeStore struct {
+	expressions map[string][]global.ExpressionDTO
+	records     map[string][]global.TaskRecord
+	err         error
+}
+
+func (f *fakeStore) GetExpressionsByStatus(status string) ([]global.ExpressionDTO, error) {
+	return f.expressions[status], f.err
+}
+
+func (f *fakeStore) GetTaskRecords(expressionID string) ([]global.TaskRecord, error) {
+	return f.records[expressionID], nil
+}
+
+func TestRunResumesAllNonTerminalExpressions(t *testing.T) {
+	store := &fakeStore{
+		expressions: map[string][]global.ExpressionDTO{
+			"pending":    {{ID: "p1", UserID: 1}},
+			"processing": {{ID: "w1", UserID: 2}},
+			"completed":  {{ID: "c1", UserID: 1}},
+		},
+		records: map[string][]global.TaskRecord{
+			"w1": {{Index: 0, Solved: true}, {Index: 1, Solved: true}, {Index: 2}},
+		},
+	}
+	var resumed []string
+	r := New(store, func(id string) { resumed = append(resumed, id) })
+	summary, err := r.Run()
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if !reflect.DeepEqual(resumed, []string{"p1", "w1"}) {
+		t.Errorf("resumed = %v, want [p1 w1]", resumed)
+	}
+	want := []Resumed{
+		{ID: "p1", UserID: 1, Status: "pending"},
+		{ID: "w1", UserID: 2, Status: "processing", SolvedTasks: 2, UnfinishedTasks: 1},
+	}
+	if !reflect.DeepEqual(summary.Expressions, want) {
+		t.Errorf("Expressions = %+v, want %+v", summary.Expressions, want)
+	}
+	if summary.Total != 2 || summary.ByStatus["pending"] != 1 || summary.ByStatus["processing"] != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if got := r.Summary(); !reflect.DeepEqual(got, summary) {
+		t.Errorf("Summary() = %+v, want the result of Run", got)
+	}
+}
+
+func TestRunReportsStoreErrors(t *testing.T) {
+	store := &fakeStore{err: errors.New("db is down")}
+	r := New(store, func(string) { t.Error("nothing should be resumed") })
+	if _, err := r.Run(); err == nil {
+		t.Error("Run returned no error")
+	}
+}
@@ -0,0 +1,102 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TLSConfig — настройки TLS gRPC-сервера. Без сертификата сервер работает
+// без шифрования; с ClientCA он требует от агентов клиентские сертификаты (mTLS).
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	ClientCA string
+}
+
+func (c TLSConfig) credentials() (credentials.TransportCredentials, error) {
+	if c.CertFile == "" && c.KeyFile == "" {
+		if c.ClientCA != "" {
+			return nil, errors.New("client CA requires a server certificate")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.ClientCA != "" {
+		pem, err := os.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA %s has no certificates", c.ClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// authorize проверяет общий секрет агентов из заголовка authorization ("Bearer <token>").
+func authorize(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		got, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid or missing agent token")
+}
+
+func tokenUnaryInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := authorize(ctx, token); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func tokenStreamInterceptor(token string) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorize(stream.Context(), token); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// serverOptions собирает TLS и проверку токена агентов для gRPC-сервера.
+func serverOptions(config TLSConfig, token string) ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+	creds, err := config.credentials()
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if token != "" {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(tokenUnaryInterceptor(token)),
+			grpc.ChainStreamInterceptor(tokenStreamInterceptor(token)),
+		)
+	}
+	return opts, nil
+}
@@ -0,0 +1,193 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"calculator/internal/queue"
+	"calculator/internal/task/taskpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+// newTestCA создаёт в dir самоподписанный CA для тестовых сертификатов.
+func newTestCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	file := filepath.Join(dir, "ca.pem")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue выпускает подписанный CA сертификат для localhost и возвращает пути к сертификату и ключу.
+func (ca *testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, kind string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func startTestServer(t *testing.T, opts []grpc.ServerOption) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer(opts...)
+	taskpb.RegisterOrchestratorServer(grpcServer, &server{shutdownCtx: context.Background(), tasks: queue.New(queue.Defaults), agents: NewRegistry(), opts: Defaults})
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+	return lis.Addr().String()
+}
+
+func TestServerOptions_MutualTLSAndToken(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "agent", x509.ExtKeyUsageClientAuth)
+	opts, err := serverOptions(TLSConfig{CertFile: serverCert, KeyFile: serverKey, ClientCA: ca.file}, "secret")
+	if err != nil {
+		t.Fatalf("serverOptions error: %v", err)
+	}
+	addr := startTestServer(t, opts)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	register := func(t *testing.T, withCert bool, token string) error {
+		t.Helper()
+		config := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if withCert {
+			cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+		_, err = taskpb.NewOrchestratorClient(conn).RegisterAgent(ctx, &taskpb.AgentInfo{AgentId: "a1"})
+		return err
+	}
+
+	if err := register(t, true, "secret"); err != nil {
+		t.Errorf("agent with certificate and token was rejected: %v", err)
+	}
+	if err := register(t, true, "wrong"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("wrong token error = %v, want Unauthenticated", err)
+	}
+	if err := register(t, false, "secret"); err == nil {
+		t.Error("agent without a client certificate was accepted")
+	}
+}
+
+func TestServerOptions_TokenGuardsStreams(t *testing.T) {
+	opts, err := serverOptions(TLSConfig{}, "secret")
+	if err != nil {
+		t.Fatalf("serverOptions error: %v", err)
+	}
+	addr := startTestServer(t, opts)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := taskpb.NewOrchestratorClient(conn).Work(ctx)
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Work without token error = %v, want Unauthenticated", err)
+	}
+}
+
+func TestServerOptions_InvalidConfig(t *testing.T) {
+	if _, err := serverOptions(TLSConfig{ClientCA: "ca.pem"}, ""); err == nil {
+		t.Error("client CA without a server certificate was accepted")
+	}
+	if _, err := serverOptions(TLSConfig{CertFile: "missing.pem", KeyFile: "missing-key.pem"}, ""); err == nil {
+		t.Error("missing certificate files were accepted")
+	}
+	if opts, err := serverOptions(TLSConfig{}, ""); err != nil || len(opts) != 0 {
+		t.Errorf("serverOptions() = %v, %v, want plaintext without options", opts, err)
+	}
+}
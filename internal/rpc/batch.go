@@ -0,0 +1,7 @@
+package rpc
+
+// negotiateBatchSize согласует размер пакета, который запросил агент: не меньше
+// одной задачи и не больше limit.
+func negotiateBatchSize(requested, limit int) int {
+	return max(1, min(requested, limit))
+}
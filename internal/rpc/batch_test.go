@@ -0,0 +1,12 @@
+package rpc
+
+import "testing"
+
+func TestNegotiateBatchSize(t *testing.T) {
+	tests := map[int]int{0: 1, -3: 1, 8: 8, 16: 16, 500: 16}
+	for requested, want := range tests {
+		if got := negotiateBatchSize(requested, 16); got != want {
+			t.Errorf("negotiateBatchSize(%d, 16) = %d, want %d", requested, got, want)
+		}
+	}
+}
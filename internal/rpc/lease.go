@@ -0,0 +1,25 @@
+package rpc
+
+import (
+	"calculator/internal/queue"
+	"calculator/pkg/loggers"
+	"context"
+	"time"
+)
+
+func reapLeases(ctx context.Context, tasks *queue.Queue, agents *Registry, interval time.Duration) {
+	logger := loggers.GetLogger("orchestrator")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, lease := range tasks.RequeueExpired(now) {
+				agents.Failed(lease.Agent, 1)
+				logger.Warn("lease expired, task requeued", "id", lease.Task.ID, "agent", lease.Agent, "attempts", lease.Task.Attempts)
+			}
+		}
+	}
+}
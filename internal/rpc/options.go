@@ -0,0 +1,36 @@
+package rpc
+
+import "time"
+
+// Options — настройки gRPC-сервера и работы с агентами.
+type Options struct {
+	// Token — общий секрет агентов; пустой — агенты подключаются без токена.
+	Token string
+	TLS   TLSConfig
+	// HeartbeatInterval — как часто агенты присылают heartbeat.
+	HeartbeatInterval time.Duration
+	// LeaseTimeout — запас времени сверх OperationTime, после которого задача
+	// считается потерянной и возвращается в очередь.
+	LeaseTimeout time.Duration
+	// RoutingGrace — сколько задача может ждать агента, умеющего выполнять её
+	// операцию, прежде чем выражение завершится ошибкой.
+	RoutingGrace time.Duration
+	// MaxBatchSize — верхняя граница размера пакета WorkBatch.
+	MaxBatchSize int
+	// StragglerFactor — во сколько раз задача должна превысить своё OperationTime,
+	// чтобы считаться отстающей; 0 выключает дублирование.
+	StragglerFactor float64
+	// StragglerMinDelay — минимальное время у агента, после которого задачу можно
+	// дублировать; защищает быстрые операции от лишних дубликатов.
+	StragglerMinDelay time.Duration
+}
+
+// Defaults — настройки по умолчанию, если они не заданы в конфигурации.
+var Defaults = Options{
+	HeartbeatInterval: 2 * time.Second,
+	LeaseTimeout:      10 * time.Second,
+	RoutingGrace:      30 * time.Second,
+	MaxBatchSize:      100,
+	StragglerFactor:   3,
+	StragglerMinDelay: 2 * time.Second,
+}
@@ -0,0 +1,338 @@
+package rpc
+
+import (
+	"calculator/internal/global"
+	"calculator/internal/queue"
+	"calculator/pkg/loggers"
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Состояния агента: новые задачи получает только активный агент. Draining
+// доделывает уже выданные задачи, у disabled они сразу возвращаются в очередь.
+const (
+	StateActive   = "active"
+	StateDraining = "draining"
+	StateDisabled = "disabled"
+)
+
+// Agent — зарегистрированный агент и его последнее известное состояние.
+type Agent struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Hostname       string            `json:"hostname"`
+	Version        string            `json:"version"`
+	Address        string            `json:"address"`
+	ComputingPower int               `json:"computing_power"`
+	Operations     []string          `json:"operations"`
+	BatchSize      int               `json:"batch_size"`
+	Load           int               `json:"load"`
+	State          string            `json:"state"`
+	Completed      int               `json:"completed"`
+	Failed         int               `json:"failed"`
+	Mismatches     int               `json:"mismatches"`
+	Flagged        bool              `json:"flagged"`
+	RegisteredAt   time.Time         `json:"registered_at"`
+	LastSeen       time.Time         `json:"last_seen"`
+	// Session — случайный ключ процесса, который зарегистрировал агента (см. server.claim).
+	Session string `json:"-"`
+}
+
+func (a Agent) Supports(operation string) bool {
+	return slices.Contains(a.Operations, operation)
+}
+
+// ErrorRate — доля задач агента, которые не завершились результатом.
+func (a Agent) ErrorRate() float64 {
+	if a.Completed+a.Failed == 0 {
+		return 0
+	}
+	return float64(a.Failed) / float64(a.Completed+a.Failed)
+}
+
+// Registry — живые агенты. Агент, от которого давно не было heartbeat, удаляется
+// из реестра, а его задачи возвращаются в очередь. Состояние (drain, disable)
+// переживает перерегистрацию агента.
+type Registry struct {
+	mu     sync.Mutex
+	agents map[string]*Agent
+	states map[string]string
+	// expired — агенты, удалённые по отсутствию heartbeat; до новой регистрации
+	// задачи им не выдаются.
+	expired map[string]bool
+	// streams — число открытых потоков задач по ID агента, в том числе
+	// старых клиентов, которые не регистрируются.
+	streams map[string]int
+	changed chan struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		agents:  make(map[string]*Agent),
+		states:  make(map[string]string),
+		expired: make(map[string]bool),
+		streams: make(map[string]int),
+		changed: make(chan struct{}),
+	}
+}
+
+func (r *Registry) state(id string) string {
+	if state, ok := r.states[id]; ok {
+		return state
+	}
+	return StateActive
+}
+
+// Accepting сообщает, можно ли выдавать агенту новые задачи. Если нельзя,
+// возвращённый канал закроется при следующей смене состояния любого агента.
+func (r *Registry) Accepting(id string) (bool, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state(id) == StateActive && !r.expired[id], r.changed
+}
+
+// Expired сообщает, что агент был зарегистрирован, но удалён по отсутствию
+// heartbeat и с тех пор не зарегистрировался заново.
+func (r *Registry) Expired(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.expired[id]
+}
+
+// Accepts возвращает фильтр задач для агента: только операции, которые он
+// объявил при регистрации. Агенты, которые никогда не регистрировались,
+// получают любые задачи, а удалённые по отсутствию heartbeat — никаких.
+func (r *Registry) Accepts(id string) func(*global.Task) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.expired[id] {
+		return func(*global.Task) bool { return false }
+	}
+	agent, ok := r.agents[id]
+	if !ok {
+		return nil
+	}
+	operations := agent.Operations
+	return func(task *global.Task) bool { return slices.Contains(operations, task.Operation) }
+}
+
+// Capable сообщает, может ли кто-то выполнить операцию: её объявил агент из
+// реестра (в том числе draining или disabled — их задачи ждут, а не
+// отклоняются) или подключён незарегистрированный клиент, который берёт
+// любые задачи.
+func (r *Registry) Capable(operation string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, agent := range r.agents {
+		if agent.Supports(operation) {
+			return true
+		}
+	}
+	for id := range r.streams {
+		if _, ok := r.agents[id]; !ok && !r.expired[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// Connect отмечает открытый поток задач агента id; возвращённая функция
+// отмечает его закрытие.
+func (r *Registry) Connect(id string) (disconnect func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.streams[id]++
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.streams[id]--; r.streams[id] == 0 {
+			delete(r.streams, id)
+		}
+	}
+}
+
+// Idle сообщает, есть ли кроме except активный агент со свободной мощностью,
+// умеющий выполнять операцию. Мощность агента без ComputingPower не ограничена.
+func (r *Registry) Idle(operation, except string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, agent := range r.agents {
+		if id == except || r.state(id) != StateActive || !agent.Supports(operation) {
+			continue
+		}
+		if agent.ComputingPower == 0 || agent.Load < agent.ComputingPower {
+			return true
+		}
+	}
+	return false
+}
+
+// SetState меняет состояние зарегистрированного агента.
+func (r *Registry) SetState(id, state string) (Agent, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[id]
+	if !ok {
+		return Agent{}, false
+	}
+	if state == StateActive {
+		delete(r.states, id)
+	} else {
+		r.states[id] = state
+	}
+	agent.State = state
+	r.notify()
+	return *agent, true
+}
+
+// notify будит всех, кто ждёт смены состояния агентов (см. Accepting).
+func (r *Registry) notify() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Completed засчитывает агенту принятый результат.
+func (r *Registry) Completed(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if agent, ok := r.agents[id]; ok {
+		agent.Completed++
+	}
+}
+
+// Failed засчитывает агенту задачи, которые пришлось у него забрать.
+func (r *Registry) Failed(id string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if agent, ok := r.agents[id]; ok {
+		agent.Failed += n
+	}
+}
+
+// Flag помечает агента подозрительным: при проверке повторным выполнением
+// его результат разошёлся с большинством.
+func (r *Registry) Flag(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if agent, ok := r.agents[id]; ok {
+		agent.Mismatches++
+		agent.Flagged = true
+	}
+}
+
+// basicOperations — операции, которые умеют все агенты; их получает агент, не
+// объявивший список операций при регистрации.
+var basicOperations = []string{"+", "-", "*", "/"}
+
+// Register добавляет агента или обновляет данные уже известного.
+func (r *Registry) Register(agent Agent, now time.Time) Agent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if known, ok := r.agents[agent.ID]; ok {
+		agent.RegisteredAt = known.RegisteredAt
+		agent.Completed, agent.Failed = known.Completed, known.Failed
+		agent.Mismatches, agent.Flagged = known.Mismatches, known.Flagged
+	} else {
+		agent.RegisteredAt = now
+	}
+	if len(agent.Operations) == 0 {
+		agent.Operations = basicOperations
+	}
+	if agent.Name == "" {
+		agent.Name = agent.Hostname
+	}
+	agent.State = r.state(agent.ID)
+	agent.LastSeen = now
+	r.agents[agent.ID] = &agent
+	if r.expired[agent.ID] {
+		delete(r.expired, agent.ID)
+		r.notify()
+	}
+	return agent
+}
+
+// Heartbeat отмечает, что агент жив. false — агент неизвестен и должен зарегистрироваться заново.
+func (r *Registry) Heartbeat(id string, load int, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[id]
+	if !ok {
+		return false
+	}
+	agent.Load = load
+	agent.LastSeen = now
+	return true
+}
+
+// Expire удаляет агентов, последний heartbeat которых был раньше deadline.
+func (r *Registry) Expire(deadline time.Time) []Agent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var expired []Agent
+	for id, agent := range r.agents {
+		if agent.LastSeen.Before(deadline) {
+			expired = append(expired, *agent)
+			delete(r.agents, id)
+			r.expired[id] = true
+		}
+	}
+	if len(expired) > 0 {
+		r.notify()
+	}
+	return expired
+}
+
+// Owns сообщает, может ли вызов с ключом session действовать от имени агента
+// id: агент не зарегистрирован, зарегистрирован без ключа или с тем же ключом.
+func (r *Registry) Owns(id, session string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[id]
+	return !ok || agent.Session == "" || agent.Session == session
+}
+
+func (r *Registry) Get(id string) (Agent, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[id]
+	if !ok {
+		return Agent{}, false
+	}
+	return *agent, true
+}
+
+func (r *Registry) List() []Agent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agents := make([]Agent, 0, len(r.agents))
+	for _, agent := range r.agents {
+		agents = append(agents, *agent)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	return agents
+}
+
+// missedHeartbeats — сколько heartbeat подряд агент может пропустить, прежде чем его сочтут потерянным.
+const missedHeartbeats = 3
+
+func expireAgents(ctx context.Context, agents *Registry, tasks *queue.Queue, interval, heartbeat time.Duration) {
+	logger := loggers.GetLogger("orchestrator")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deadline := now.Add(-missedHeartbeats * heartbeat)
+			for _, agent := range agents.Expire(deadline) {
+				requeued := tasks.RequeueAgent(agent.ID)
+				logger.Warn("agent expired", "id", agent.ID, "hostname", agent.Hostname, "last_seen", agent.LastSeen, "requeued", len(requeued))
+			}
+		}
+	}
+}
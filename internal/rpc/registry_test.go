@@ -0,0 +1,121 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"calculator/internal/global"
+	"calculator/internal/queue"
+)
+
+func TestRegistryLifecycle(t *testing.T) {
+	r := NewRegistry()
+	start := time.Now()
+	r.Register(Agent{ID: "b", Hostname: "host-b", ComputingPower: 4}, start)
+	r.Register(Agent{ID: "a", Hostname: "host-a", ComputingPower: 2}, start)
+	r.Register(Agent{ID: "a", Name: "calc-a", Hostname: "host-a", ComputingPower: 8}, start.Add(time.Second))
+
+	a, ok := r.Get("a")
+	if !ok || a.ComputingPower != 8 || !a.RegisteredAt.Equal(start) || !a.LastSeen.Equal(start.Add(time.Second)) {
+		t.Errorf("re-registered agent = %+v", a)
+	}
+	if b, _ := r.Get("b"); a.Name != "calc-a" || b.Name != "host-b" {
+		t.Errorf("names = %q, %q, want calc-a and the hostname of b", a.Name, b.Name)
+	}
+	if !r.Heartbeat("b", 3, start.Add(5*time.Second)) {
+		t.Error("Heartbeat from a registered agent returned false")
+	}
+	if r.Heartbeat("ghost", 0, start) {
+		t.Error("Heartbeat from an unknown agent returned true")
+	}
+	expired := r.Expire(start.Add(2 * time.Second))
+	if len(expired) != 1 || expired[0].ID != "a" {
+		t.Fatalf("Expire = %+v, want only agent a", expired)
+	}
+	list := r.List()
+	if len(list) != 1 || list[0].ID != "b" || list[0].Load != 3 {
+		t.Errorf("List = %+v, want agent b with load 3", list)
+	}
+}
+
+func TestExpireAgentsRequeuesTasks(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	agents := NewRegistry()
+	agents.Register(Agent{ID: "silent"}, time.Now())
+	tasks.Submit(&global.Task{ID: "t1"}, nil)
+	tasks.Next(context.Background(), "silent", time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go expireAgents(ctx, agents, tasks, 5*time.Millisecond, 10*time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for tasks.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if tasks.Len() != 1 {
+		t.Fatal("task of the expired agent was not requeued")
+	}
+	if _, ok := agents.Get("silent"); ok {
+		t.Error("expired agent is still registered")
+	}
+}
+
+func TestRegistryStateSurvivesReregistration(t *testing.T) {
+	r := NewRegistry()
+	now := time.Now()
+	if _, ok := r.SetState("ghost", StateDisabled); ok {
+		t.Error("SetState succeeded for an unknown agent")
+	}
+	r.Register(Agent{ID: "a"}, now)
+	_, changed := r.Accepting("a")
+	r.SetState("a", StateDisabled)
+	select {
+	case <-changed:
+	default:
+		t.Error("state change did not wake up waiters")
+	}
+	r.Completed("a")
+	r.Failed("a", 3)
+	r.Expire(now.Add(time.Second))
+	r.Register(Agent{ID: "a"}, now.Add(2*time.Second))
+	agent, _ := r.Get("a")
+	if accepting, _ := r.Accepting("a"); accepting || agent.State != StateDisabled {
+		t.Errorf("re-registered agent = %+v, want it to stay disabled", agent)
+	}
+	if rate := (Agent{Completed: 1, Failed: 3}).ErrorRate(); rate != 0.75 {
+		t.Errorf("ErrorRate = %v, want 0.75", rate)
+	}
+}
+
+func TestExpiredAgentGetsNoTasks(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	agents := NewRegistry()
+	srv := &server{tasks: tasks, agents: agents, opts: Defaults}
+	now := time.Now()
+	agents.Register(Agent{ID: "adder", Operations: []string{"+"}}, now)
+	agents.Expire(now.Add(time.Second))
+	tasks.Submit(&global.Task{ID: "div", Operation: "/"}, nil)
+
+	if accepts := agents.Accepts("adder"); accepts == nil || accepts(&global.Task{Operation: "+"}) {
+		t.Error("an expired agent accepts tasks")
+	}
+	if accepts := agents.Accepts("legacy"); accepts != nil {
+		t.Error("a client that never registered got a filter")
+	}
+	if _, err := srv.next(context.Background(), "adder"); !errors.Is(err, errAgentExpired) {
+		t.Fatalf("next = %v, want errAgentExpired", err)
+	}
+	if tasks.Len() != 1 {
+		t.Fatal("the task was handed to the expired agent")
+	}
+
+	agents.Register(Agent{ID: "adder", Operations: []string{"+", "/"}}, now.Add(2*time.Second))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if task, err := srv.next(ctx, "adder"); err != nil || task.ID != "div" {
+		t.Errorf("next after registering again = %+v, %v, want the division", task, err)
+	}
+}
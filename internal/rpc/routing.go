@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	"calculator/internal/global"
+	"calculator/internal/queue"
+	"calculator/pkg/loggers"
+	"context"
+	"fmt"
+	"time"
+)
+
+// failUnroutable завершает ошибкой задачи, операцию которых дольше grace
+// не может выполнить ни один известный агент (см. Registry.Capable).
+func failUnroutable(ctx context.Context, tasks *queue.Queue, agents *Registry, interval, grace time.Duration) {
+	logger := loggers.GetLogger("orchestrator")
+	lastCapable := make(map[string]time.Time)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			failed := tasks.FailWaiting(func(task *global.Task, waited time.Duration) error {
+				if agents.Capable(task.Operation) {
+					lastCapable[task.Operation] = now
+					return nil
+				}
+				if waited < grace || now.Sub(lastCapable[task.Operation]) < grace {
+					return nil
+				}
+				return fmt.Errorf("no connected agent supports operation %q", task.Operation)
+			})
+			for _, task := range failed {
+				logger.Warn("no capable agent, task failed", "id", task.ID, "expression", task.ExpressionID, "operation", task.Operation)
+			}
+		}
+	}
+}
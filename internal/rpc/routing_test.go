@@ -0,0 +1,135 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"calculator/internal/global"
+	"calculator/internal/queue"
+	"calculator/internal/task/taskpb"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRegistryCapabilities(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Agent{ID: "basic"}, time.Now())
+	r.Register(Agent{ID: "adder", Operations: []string{"+"}}, time.Now())
+	if accepts := r.Accepts("adder"); accepts(&global.Task{Operation: "*"}) || !accepts(&global.Task{Operation: "+"}) {
+		t.Error("adder must accept only additions")
+	}
+	if r.Accepts("legacy") != nil {
+		t.Error("unregistered agents must not be filtered")
+	}
+	if !r.Capable("*") {
+		t.Error("an agent without declared operations must support the basic ones")
+	}
+	r.SetState("basic", StateDisabled)
+	if !r.Capable("*") {
+		t.Error("a disabled agent must keep its operations routable")
+	}
+	r.Expire(time.Now().Add(time.Minute))
+	if r.Capable("*") {
+		t.Error("expired agents must not count as capable")
+	}
+	disconnect := r.Connect("legacy")
+	if !r.Capable("^") {
+		t.Error("a connected unregistered client must count as capable of any operation")
+	}
+	disconnect()
+	if r.Capable("*") {
+		t.Error("a disconnected client must not count as capable")
+	}
+}
+
+func TestFailUnroutable_WaitsForPausedAndLegacyAgents(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	agents := NewRegistry()
+	agents.Register(Agent{ID: "adder", Operations: []string{"+"}}, time.Now())
+	agents.SetState("adder", StateDraining)
+	tasks.Submit(&global.Task{ID: "add", Operation: "+"}, nil)
+
+	// Старый клиент подключён, но занят: задачи ему пока не выдаются.
+	disconnect := agents.Connect("unknown")
+	tasks.Submit(&global.Task{ID: "pow", Operation: "^"}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go failUnroutable(ctx, tasks, agents, 5*time.Millisecond, 20*time.Millisecond)
+	time.Sleep(60 * time.Millisecond)
+	if tasks.Len() != 2 {
+		t.Fatalf("Len = %d, tasks of a drained agent and a legacy client must keep waiting", tasks.Len())
+	}
+	disconnect()
+	time.Sleep(60 * time.Millisecond)
+	if tasks.Len() != 1 {
+		t.Errorf("Len = %d, the power must fail once the legacy client is gone", tasks.Len())
+	}
+}
+
+func TestGetTasks_CountsLegacyStream(t *testing.T) {
+	agents := NewRegistry()
+	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+	srv := &server{shutdownCtx: shutdownCtx, tasks: queue.New(queue.Defaults), agents: agents, opts: Defaults}
+	done := make(chan error, 1)
+	go func() { done <- srv.GetTasks(&taskpb.Empty{}, &fakeStream{ctx: context.Background()}) }()
+	time.Sleep(20 * time.Millisecond)
+	if !agents.Capable("/") {
+		t.Error("a connected legacy client must make any operation routable")
+	}
+	shutdownCancel()
+	<-done
+	if agents.Capable("/") {
+		t.Error("a closed stream must not count as capable")
+	}
+}
+
+func TestGetTasks_RoutesByOperation(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	agents := NewRegistry()
+	agents.Register(Agent{ID: "adder", Operations: []string{"+"}}, time.Now())
+	tasks.Submit(&global.Task{ID: "mul", Operation: "*"}, nil)
+	tasks.Submit(&global.Task{ID: "add", Operation: "+"}, nil)
+	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+	srv := &server{shutdownCtx: shutdownCtx, tasks: tasks, agents: agents, opts: Defaults}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(agentIDKey, "adder"))
+	stream := &fakeStream{ctx: ctx}
+	done := make(chan error, 1)
+	go func() { done <- srv.GetTasks(&taskpb.Empty{}, stream) }()
+	time.Sleep(30 * time.Millisecond)
+	shutdownCancel()
+	if err := <-done; err != nil {
+		t.Fatalf("GetTasks returned error: %v", err)
+	}
+	if len(stream.Sent) != 1 || stream.Sent[0].Id != "add" {
+		t.Errorf("Sent = %+v, want only the addition", stream.Sent)
+	}
+}
+
+func TestFailUnroutable(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	agents := NewRegistry()
+	agents.Register(Agent{ID: "adder", Operations: []string{"+"}}, time.Now())
+	add := tasks.Submit(&global.Task{ID: "add", Operation: "+"}, nil)
+	div := tasks.Submit(&global.Task{ID: "div", Operation: "/"}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go failUnroutable(ctx, tasks, agents, 5*time.Millisecond, 20*time.Millisecond)
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Second)
+	defer waitCancel()
+	_, err := div.Wait(waitCtx)
+	if err == nil || !strings.Contains(err.Error(), `no connected agent supports operation "/"`) {
+		t.Fatalf("div error = %v, want no capable agent", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if tasks.Len() != 1 {
+		t.Fatalf("Len = %d, the addition must keep waiting for its agent", tasks.Len())
+	}
+	tasks.Complete("add", "adder", 2)
+	if v, err := add.Wait(context.Background()); err != nil || v != 2 {
+		t.Errorf("add = %v, %v", v, err)
+	}
+}
@@ -2,80 +2,413 @@ package rpc
 
 import (
 	"calculator/internal/global"
+	"calculator/internal/queue"
 	"calculator/internal/task/taskpb"
 	"calculator/pkg/loggers"
 	"context"
 	"errors"
+	"io"
 	"log"
 	"net"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 type server struct {
 	taskpb.UnimplementedOrchestratorServer
 	shutdownCtx context.Context
+	tasks       *queue.Queue
+	agents      *Registry
+	opts        Options
+}
+
+// agentIDKey — ключ метаданных, в котором зарегистрированный агент передаёт свой ID.
+const agentIDKey = "agent-id"
+
+// agentSessionKey — ключ метаданных со случайным ключом, который агент создаёт
+// при запуске и передаёт с каждым вызовом.
+const agentSessionKey = "agent-session"
+
+func sessionOf(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(agentSessionKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// claim проверяет, что вызов от имени агента id пришёл от процесса, который
+// этого агента зарегистрировал. Проверка действует только вместе с токеном
+// агентов: без него вызовы ничем не подтверждены и ID можно подделать.
+func (s *server) claim(ctx context.Context, id string) error {
+	if s.opts.Token == "" || s.agents.Owns(id, sessionOf(ctx)) {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "agent %s is registered by another process", id)
+}
+
+func peerAddress(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return "unknown"
+}
+
+// agentName — ID агента из метаданных, а для агентов без регистрации — адрес соединения.
+func agentName(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(agentIDKey); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+	return peerAddress(ctx)
+}
+
+func (s *server) RegisterAgent(ctx context.Context, in *taskpb.AgentInfo) (*taskpb.Registration, error) {
+	if in.GetAgentId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "agent_id is required")
+	}
+	if err := s.claim(ctx, in.GetAgentId()); err != nil {
+		return nil, err
+	}
+	agent := s.agents.Register(Agent{
+		ID:             in.GetAgentId(),
+		Name:           in.GetName(),
+		Labels:         in.GetLabels(),
+		Hostname:       in.GetHostname(),
+		Version:        in.GetVersion(),
+		Address:        peerAddress(ctx),
+		ComputingPower: int(in.GetComputingPower()),
+		Operations:     in.GetOperations(),
+		BatchSize:      negotiateBatchSize(int(in.GetBatchSize()), s.opts.MaxBatchSize),
+		Load:           int(in.GetLoad()),
+		Session:        sessionOf(ctx),
+	}, time.Now())
+	loggers.GetLogger("orchestrator").Info(
+		"agent registered",
+		"id", in.GetAgentId(),
+		"name", agent.Name,
+		"hostname", in.GetHostname(),
+		"version", in.GetVersion(),
+		"computing_power", in.GetComputingPower(),
+		"operations", agent.Operations,
+		"batch_size", agent.BatchSize,
+	)
+	return &taskpb.Registration{
+		HeartbeatIntervalMs: s.opts.HeartbeatInterval.Milliseconds(),
+		BatchSize:           int32(agent.BatchSize),
+	}, nil
+}
+
+func (s *server) Heartbeat(ctx context.Context, in *taskpb.AgentHeartbeat) (*taskpb.HeartbeatAck, error) {
+	if err := s.claim(ctx, in.GetAgentId()); err != nil {
+		return nil, err
+	}
+	registered := s.agents.Heartbeat(in.GetAgentId(), int(in.GetLoad()), time.Now())
+	return &taskpb.HeartbeatAck{Registered: registered}, nil
+}
+
+// errAgentExpired — агент удалён по отсутствию heartbeat; задачи он получит
+// только после новой регистрации.
+var errAgentExpired = status.Error(codes.FailedPrecondition, "agent registration expired, register again")
+
+// next ждёт, пока агент принимает задачи, и выдаёт ему следующую подходящую.
+// Ошибка означает, что ждать больше нечего: поток закрыт, агент удалён из
+// реестра или оркестратор останавливается.
+func (s *server) next(ctx context.Context, agent string) (*global.Task, error) {
+	for {
+		if s.agents.Expired(agent) {
+			return nil, errAgentExpired
+		}
+		if accepting, changed := s.agents.Accepting(agent); !accepting {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-changed:
+			}
+			continue
+		}
+		task, err := s.tasks.NextMatching(ctx, agent, s.opts.LeaseTimeout, s.agents.Accepts(agent))
+		if err != nil {
+			return nil, err
+		}
+		// Агента могли вывести из работы, пока он ждал задачу.
+		if accepting, _ := s.agents.Accepting(agent); !accepting {
+			s.tasks.Release(task.ID)
+			continue
+		}
+		return task, nil
+	}
+}
+
+func toProto(task *global.Task) *taskpb.Task {
+	return &taskpb.Task{
+		Id:            task.ID,
+		Arg1:          task.Arg1,
+		Arg2:          task.Arg2,
+		Operation:     task.Operation,
+		OperationTime: int32(task.OperationTime),
+		Priority:      int32(task.Priority),
+	}
 }
 
 func (s *server) GetTasks(_ *taskpb.Empty, stream taskpb.Orchestrator_GetTasksServer) error {
-	clientCtx := stream.Context()
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.shutdownCtx, cancel)
+	defer stop()
+	agent := agentName(ctx)
+	if err := s.claim(ctx, agent); err != nil {
+		return err
+	}
+	defer s.agents.Connect(agent)()
 	for {
-		select {
-		case <-clientCtx.Done():
-			return clientCtx.Err()
-		case <-s.shutdownCtx.Done():
+		task, err := s.next(ctx, agent)
+		if err != nil {
+			if s.shutdownCtx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := stream.Send(toProto(task)); err != nil {
+			s.tasks.Release(task.ID)
+			return err
+		}
+	}
+}
+
+// Work — двунаправленный поток: агент выдаёт кредиты и присылает результаты,
+// а оркестратор отправляет не больше задач, чем агент разрешил.
+func (s *server) Work(stream taskpb.Orchestrator_WorkServer) error {
+	return s.work(
+		stream.Context(),
+		1,
+		func() (int32, []*taskpb.SolvedTask, error) {
+			req, err := stream.Recv()
+			if err != nil || req.GetResult() == nil {
+				return req.GetCredits(), nil, err
+			}
+			return req.GetCredits(), []*taskpb.SolvedTask{req.GetResult()}, nil
+		},
+		func(tasks []*global.Task) error { return stream.Send(toProto(tasks[0])) },
+	)
+}
+
+// WorkBatch — пакетный вариант Work: задачи уходят пакетами до согласованного
+// при регистрации размера, результаты приходят пакетами.
+func (s *server) WorkBatch(stream taskpb.Orchestrator_WorkBatchServer) error {
+	size := 1
+	if agent, ok := s.agents.Get(agentName(stream.Context())); ok && agent.BatchSize > 0 {
+		size = agent.BatchSize
+	}
+	return s.work(
+		stream.Context(),
+		size,
+		func() (int32, []*taskpb.SolvedTask, error) {
+			batch, err := stream.Recv()
+			return batch.GetCredits(), batch.GetResults(), err
+		},
+		func(tasks []*global.Task) error {
+			batch := &taskpb.TaskBatch{Tasks: make([]*taskpb.Task, len(tasks))}
+			for i, task := range tasks {
+				batch.Tasks[i] = toProto(task)
+			}
+			return stream.Send(batch)
+		},
+	)
+}
+
+// work — общий цикл Work и WorkBatch. recv читает от агента кредиты и
+// результаты, send отправляет агенту от 1 до batch задач.
+func (s *server) work(
+	streamCtx context.Context,
+	batch int,
+	recv func() (int32, []*taskpb.SolvedTask, error),
+	send func([]*global.Task) error,
+) error {
+	ctx, cancel := context.WithCancel(streamCtx)
+	defer cancel()
+	stop := context.AfterFunc(s.shutdownCtx, cancel)
+	defer stop()
+	agent := agentName(ctx)
+	if err := s.claim(ctx, agent); err != nil {
+		return err
+	}
+	defer s.agents.Connect(agent)()
+	var credits atomic.Int64
+	granted := make(chan struct{}, 1)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			n, results, err := recv()
+			if err != nil {
+				recvErr <- err
+				cancel()
+				return
+			}
+			for _, result := range results {
+				s.complete(agent, result)
+			}
+			if n > 0 {
+				credits.Add(int64(n))
+				select {
+				case granted <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	closed := func() error {
+		if s.shutdownCtx.Err() != nil {
 			return nil
+		}
+		select {
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
 		default:
+			return ctx.Err()
 		}
-		var sent bool
-		var sendErr error
-		global.TasksMap.Range(func(key, value any) bool {
-			task := value.(*global.Task)
-			if err := stream.Send(&taskpb.Task{
-				Id:            task.ID,
-				Arg1:          task.Arg1,
-				Arg2:          task.Arg2,
-				Operation:     task.Operation,
-				OperationTime: int32(task.OperationTime),
-			}); err != nil {
-				sendErr = err
-				return false
+	}
+	for {
+		for credits.Load() <= 0 {
+			select {
+			case <-ctx.Done():
+				return closed()
+			case <-granted:
 			}
-			global.TasksMap.Delete(key)
-			sent = true
-			return false
-		})
-		if sendErr != nil {
-			return sendErr
 		}
-		if !sent {
-			time.Sleep(100 * time.Millisecond)
+		task, err := s.next(ctx, agent)
+		if errors.Is(err, errAgentExpired) {
+			return err
+		}
+		if err != nil {
+			return closed()
 		}
+		tasks := []*global.Task{task}
+		// Добираем пакет тем, что уже лежит в очереди, не дожидаясь новых задач.
+		for int64(len(tasks)) < min(int64(batch), credits.Load()) {
+			more := s.tasks.TryNext(agent, s.opts.LeaseTimeout, s.agents.Accepts(agent))
+			if more == nil {
+				break
+			}
+			tasks = append(tasks, more)
+		}
+		if err := send(tasks); err != nil {
+			for _, task := range tasks {
+				s.tasks.Release(task.ID)
+			}
+			return err
+		}
+		credits.Add(-int64(len(tasks)))
 	}
 }
 
+// SendResult идемпотентен: первый результат закрывает задачу, а повторные
+// (например, от агента с уже истёкшей арендой) отбрасываются.
 func (s *server) SendResult(ctx context.Context, in *taskpb.SolvedTask) (*taskpb.Empty, error) {
-	if f, ok := global.FuturesMap.Load(in.GetId()); ok {
-		f.(*global.Future).SetResult(in.GetResult())
+	agent := agentName(ctx)
+	if err := s.claim(ctx, agent); err != nil {
+		return nil, err
 	}
+	s.complete(agent, in)
 	return &taskpb.Empty{}, nil
 }
 
-func Run(ctx context.Context) (func(context.Context) error, error) {
-	lis, err := net.Listen("tcp", ":50051")
+// resultError превращает код ошибки из SolvedTask в ошибку выражения.
+func resultError(in *taskpb.SolvedTask) error {
+	if in.GetErrorCode() == taskpb.ErrorCode_ERROR_NONE {
+		return nil
+	}
+	if in.GetErrorMessage() == "" {
+		name := strings.TrimPrefix(in.GetErrorCode().String(), "ERROR_")
+		return errors.New(strings.ToLower(strings.ReplaceAll(name, "_", " ")))
+	}
+	return errors.New(in.GetErrorMessage())
+}
+
+func (s *server) complete(agent string, in *taskpb.SolvedTask) {
+	var lease *global.Lease
+	var resolved bool
+	if err := resultError(in); err != nil {
+		lease, resolved = s.tasks.Fail(in.GetId(), agent, err)
+		if resolved {
+			loggers.GetLogger("orchestrator").Warn("agent reported task error", "id", in.GetId(), "agent", agent, "code", in.GetErrorCode().String(), "err", err)
+		}
+	} else {
+		lease, resolved = s.tasks.Complete(in.GetId(), agent, in.GetResult())
+	}
+	for _, suspect := range s.tasks.Suspects() {
+		s.agents.Flag(suspect)
+		loggers.GetLogger("orchestrator").Warn("agent result disagrees with other agents", "agent", suspect, "task", in.GetId())
+	}
+	if !resolved {
+		loggers.GetLogger("orchestrator").Info("duplicate or stale result ignored", "id", in.GetId(), "agent", agent)
+		return
+	}
+	s.agents.Completed(agent)
+	if lease != nil && lease.Agent != agent {
+		global.Cancellations.Publish(global.Cancellation{
+			ExpressionID: lease.Task.ExpressionID,
+			TaskIDs:      []string{in.GetId()},
+		})
+	}
+}
+
+func (s *server) WatchCancellations(_ *taskpb.Empty, stream taskpb.Orchestrator_WatchCancellationsServer) error {
+	cancellations, unsubscribe := global.Cancellations.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.shutdownCtx.Done():
+			return nil
+		case c := <-cancellations:
+			if err := stream.Send(&taskpb.Cancellation{ExpressionId: c.ExpressionID, TaskIds: c.TaskIDs}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func Run(ctx context.Context, addr string, options Options, tasks *queue.Queue, agents *Registry) (func(context.Context) error, error) {
+	opts, err := serverOptions(options.TLS, options.Token)
+	if err != nil {
+		return nil, err
+	}
+	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	grpcServer := grpc.NewServer()
-	srv := &server{shutdownCtx: ctx}
+	grpcServer := grpc.NewServer(opts...)
+	srv := &server{shutdownCtx: ctx, tasks: tasks, agents: agents, opts: options}
 	taskpb.RegisterOrchestratorServer(grpcServer, srv)
+	go reapLeases(ctx, tasks, agents, time.Second)
+	go expireAgents(ctx, agents, tasks, time.Second, options.HeartbeatInterval)
+	go failUnroutable(ctx, tasks, agents, time.Second, options.RoutingGrace)
+	go speculate(ctx, tasks, agents, time.Second, options.StragglerFactor, options.StragglerMinDelay)
 	go func() {
 		if err := grpcServer.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
 			loggers.GetLogger("orchestrator").Error("gRPC Serve", "err", err)
 		}
 	}()
-	log.Println("gRPC server listening on :50051")
+	log.Println("gRPC server listening on " + addr)
+	loggers.GetLogger("orchestrator").Info(
+		"gRPC security",
+		"tls", options.TLS.CertFile != "",
+		"client_certificates", options.TLS.ClientCA != "",
+		"agent_token", options.Token != "",
+	)
 	return func(_ context.Context) error {
 		grpcServer.GracefulStop()
 		return nil
@@ -2,13 +2,21 @@ package rpc
 
 import (
 	"context"
+	"io"
+	"os"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"calculator/internal/global"
+	"calculator/internal/queue"
 	"calculator/internal/task/taskpb"
+	"calculator/pkg/loggers"
 
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type fakeStream struct {
@@ -27,23 +35,18 @@ func (f *fakeStream) SetTrailer(md metadata.MD)       {}
 func (f *fakeStream) SendMsg(m interface{}) error     { return nil }
 func (f *fakeStream) RecvMsg(m interface{}) error     { return nil }
 
-func clearMaps() {
-	global.TasksMap.Range(func(key, _ any) bool {
-		global.TasksMap.Delete(key)
-		return true
-	})
-	global.FuturesMap.Range(func(key, _ any) bool {
-		global.FuturesMap.Delete(key)
-		return true
-	})
+func TestMain(m *testing.M) {
+	loggers.InitLogger("orchestrator", os.DevNull)
+	code := m.Run()
+	loggers.CloseAllLoggers()
+	os.Exit(code)
 }
 
 func TestSendResult_SetsFutureResult(t *testing.T) {
-	clearMaps()
-	fut := global.NewFuture()
-	global.FuturesMap.Store("task1", fut)
+	tasks := queue.New(queue.Defaults)
+	fut := tasks.Submit(&global.Task{ID: "task1", Operation: "+"}, nil)
 
-	srv := &server{}
+	srv := &server{tasks: tasks, agents: NewRegistry(), opts: Defaults}
 	_, err := srv.SendResult(context.Background(), &taskpb.SolvedTask{Id: "task1", Result: 3.14})
 	if err != nil {
 		t.Fatalf("SendResult returned error: %v", err)
@@ -56,11 +59,10 @@ func TestSendResult_SetsFutureResult(t *testing.T) {
 }
 
 func TestGetTasks_NoTasks_ShutdownImmediately(t *testing.T) {
-	clearMaps()
 	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
 	shutdownCancel()
 
-	srv := &server{shutdownCtx: shutdownCtx}
+	srv := &server{shutdownCtx: shutdownCtx, tasks: queue.New(queue.Defaults), agents: NewRegistry(), opts: Defaults}
 	stream := &fakeStream{ctx: context.Background()}
 
 	err := srv.GetTasks(&taskpb.Empty{}, stream)
@@ -73,9 +75,9 @@ func TestGetTasks_NoTasks_ShutdownImmediately(t *testing.T) {
 }
 
 func TestGetTasks_SendsOneTaskThenStops(t *testing.T) {
-	clearMaps()
+	tasks := queue.New(queue.Defaults)
 	task := &global.Task{ID: "t1", Arg1: 1, Arg2: 2, Operation: "+", OperationTime: 0}
-	global.TasksMap.Store(task.ID, task)
+	tasks.Submit(task, nil)
 
 	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
 	defer shutdownCancel()
@@ -84,7 +86,7 @@ func TestGetTasks_SendsOneTaskThenStops(t *testing.T) {
 		shutdownCancel()
 	}()
 
-	srv := &server{shutdownCtx: shutdownCtx}
+	srv := &server{shutdownCtx: shutdownCtx, tasks: tasks, agents: NewRegistry(), opts: Defaults}
 	stream := &fakeStream{ctx: context.Background()}
 
 	err := srv.GetTasks(&taskpb.Empty{}, stream)
@@ -98,4 +100,396 @@ func TestGetTasks_SendsOneTaskThenStops(t *testing.T) {
 	if sent.Id != task.ID || sent.Arg1 != task.Arg1 || sent.Arg2 != task.Arg2 || sent.Operation != task.Operation {
 		t.Errorf("Sent task = %+v, want %+v", sent, task)
 	}
+	if stats := tasks.Stats(); stats.Pending != 0 || stats.Leased != 1 {
+		t.Errorf("queue stats = %+v, want the task leased", stats)
+	}
+}
+
+func TestGetTasks_WakesUpOnSubmit(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+	defer shutdownCancel()
+	srv := &server{shutdownCtx: shutdownCtx, tasks: tasks, agents: NewRegistry(), opts: Defaults}
+	stream := &fakeStream{ctx: context.Background()}
+	done := make(chan error, 1)
+	go func() { done <- srv.GetTasks(&taskpb.Empty{}, stream) }()
+
+	time.Sleep(20 * time.Millisecond)
+	tasks.Submit(&global.Task{ID: "late", Operation: "+"}, nil)
+	time.Sleep(20 * time.Millisecond)
+	shutdownCancel()
+	if err := <-done; err != nil {
+		t.Fatalf("GetTasks returned error: %v", err)
+	}
+	if len(stream.Sent) != 1 || stream.Sent[0].Id != "late" {
+		t.Errorf("Sent = %+v, want the late task", stream.Sent)
+	}
+}
+
+func TestSendResult_MarksTraceSolved(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	trace := global.NewTrace()
+	node := trace.Operation("+", trace.Number(1), trace.Number(2))
+	tasks.Submit(&global.Task{ID: "task2", Arg1: 1, Arg2: 2, Operation: "+"}, node)
+
+	srv := &server{tasks: tasks, agents: NewRegistry(), opts: Defaults}
+	if _, err := srv.SendResult(context.Background(), &taskpb.SolvedTask{Id: "task2", Result: 3}); err != nil {
+		t.Fatalf("SendResult returned error: %v", err)
+	}
+	if node.Status != "solved" || node.Result != 3 {
+		t.Errorf("trace node = %+v, want solved with result 3", node)
+	}
+}
+
+func TestSendResult_IgnoresDuplicates(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	fut := tasks.Submit(&global.Task{ID: "t-dup", Operation: "+"}, nil)
+
+	srv := &server{tasks: tasks, agents: NewRegistry(), opts: Defaults}
+	for _, res := range []float64{1, 2} {
+		if _, err := srv.SendResult(context.Background(), &taskpb.SolvedTask{Id: "t-dup", Result: res}); err != nil {
+			t.Fatalf("SendResult returned error: %v", err)
+		}
+	}
+	if got := fut.Get(); got != 1 {
+		t.Errorf("Future.Get() = %v, want first result 1", got)
+	}
+	if n := tasks.Len(); n != 0 {
+		t.Errorf("queue length = %d, want 0", n)
+	}
+}
+
+type fakeCancellationStream struct {
+	fakeStream
+	sent chan *taskpb.Cancellation
+}
+
+func (f *fakeCancellationStream) Send(c *taskpb.Cancellation) error {
+	f.sent <- c
+	return nil
+}
+
+func TestWatchCancellations_ForwardsPublished(t *testing.T) {
+	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+	defer shutdownCancel()
+	srv := &server{shutdownCtx: shutdownCtx}
+	stream := &fakeCancellationStream{fakeStream: fakeStream{ctx: context.Background()}, sent: make(chan *taskpb.Cancellation, 1)}
+	done := make(chan error, 1)
+	go func() { done <- srv.WatchCancellations(&taskpb.Empty{}, stream) }()
+
+	deadline := time.After(time.Second)
+	for {
+		global.Cancellations.Publish(global.Cancellation{ExpressionID: "e1", TaskIDs: []string{"t1"}})
+		select {
+		case c := <-stream.sent:
+			if c.ExpressionId != "e1" || len(c.TaskIds) != 1 || c.TaskIds[0] != "t1" {
+				t.Errorf("sent = %+v", c)
+			}
+			shutdownCancel()
+			if err := <-done; err != nil {
+				t.Errorf("WatchCancellations returned error: %v", err)
+			}
+			return
+		case <-deadline:
+			t.Fatal("cancellation was not forwarded")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestRegisterAgentAndHeartbeat(t *testing.T) {
+	opts := Defaults
+	opts.HeartbeatInterval = 3 * time.Second
+	srv := &server{tasks: queue.New(queue.Defaults), agents: NewRegistry(), opts: opts}
+	if _, err := srv.RegisterAgent(context.Background(), &taskpb.AgentInfo{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("RegisterAgent without ID error = %v, want InvalidArgument", err)
+	}
+	ack, _ := srv.Heartbeat(context.Background(), &taskpb.AgentHeartbeat{AgentId: "a1"})
+	if ack.GetRegistered() {
+		t.Error("Heartbeat before registration reported registered")
+	}
+	reg, err := srv.RegisterAgent(context.Background(), &taskpb.AgentInfo{
+		AgentId:        "a1",
+		Hostname:       "host",
+		Version:        "1.2.3",
+		ComputingPower: 4,
+	})
+	if err != nil {
+		t.Fatalf("RegisterAgent error: %v", err)
+	}
+	if reg.GetHeartbeatIntervalMs() != 3000 {
+		t.Errorf("HeartbeatIntervalMs = %d, want 3000", reg.GetHeartbeatIntervalMs())
+	}
+	ack, _ = srv.Heartbeat(context.Background(), &taskpb.AgentHeartbeat{AgentId: "a1", Load: 2})
+	if !ack.GetRegistered() {
+		t.Error("Heartbeat after registration reported unregistered")
+	}
+	agent, _ := srv.agents.Get("a1")
+	if agent.Hostname != "host" || agent.Version != "1.2.3" || agent.ComputingPower != 4 || agent.Load != 2 {
+		t.Errorf("agent = %+v", agent)
+	}
+}
+
+func TestAgentNamePrefersMetadata(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(agentIDKey, "a1"))
+	if got := agentName(ctx); got != "a1" {
+		t.Errorf("agentName = %q, want a1", got)
+	}
+	if got := agentName(context.Background()); got != "unknown" {
+		t.Errorf("agentName without metadata = %q, want unknown", got)
+	}
+}
+
+func TestGetTasks_HoldsTasksWhileAgentDrained(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	agents := NewRegistry()
+	agents.Register(Agent{ID: "a1"}, time.Now())
+	agents.SetState("a1", StateDraining)
+	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+	defer shutdownCancel()
+	srv := &server{shutdownCtx: shutdownCtx, tasks: tasks, agents: agents, opts: Defaults}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(agentIDKey, "a1"))
+	stream := &fakeStream{ctx: ctx}
+	done := make(chan error, 1)
+	go func() { done <- srv.GetTasks(&taskpb.Empty{}, stream) }()
+
+	tasks.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	time.Sleep(30 * time.Millisecond)
+	if stats := tasks.Stats(); stats.Pending != 1 {
+		t.Fatalf("stats = %+v, a draining agent received a task", stats)
+	}
+	agents.SetState("a1", StateActive)
+	time.Sleep(30 * time.Millisecond)
+	shutdownCancel()
+	if err := <-done; err != nil {
+		t.Fatalf("GetTasks returned error: %v", err)
+	}
+	if len(stream.Sent) != 1 || stream.Sent[0].Id != "t1" {
+		t.Errorf("Sent = %+v, want t1 after the agent was enabled", stream.Sent)
+	}
+}
+
+func TestSendResult_CountsCompletedTasks(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	agents := NewRegistry()
+	agents.Register(Agent{ID: "a1"}, time.Now())
+	tasks.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	srv := &server{tasks: tasks, agents: agents, opts: Defaults}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(agentIDKey, "a1"))
+	srv.SendResult(ctx, &taskpb.SolvedTask{Id: "t1", Result: 1})
+	srv.SendResult(ctx, &taskpb.SolvedTask{Id: "t1", Result: 1})
+	if agent, _ := agents.Get("a1"); agent.Completed != 1 {
+		t.Errorf("Completed = %d, want 1 (duplicates are not counted)", agent.Completed)
+	}
+}
+
+type fakeWorkStream struct {
+	fakeStream
+	mu       sync.Mutex
+	requests chan *taskpb.WorkRequest
+}
+
+func (f *fakeWorkStream) Send(task *taskpb.Task) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fakeStream.Send(task)
+}
+
+func (f *fakeWorkStream) Recv() (*taskpb.WorkRequest, error) {
+	req, ok := <-f.requests
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func (f *fakeWorkStream) sent() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.Sent)
+}
+
+func TestWork_SendsOnlyGrantedTasks(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	agents := NewRegistry()
+	agents.Register(Agent{ID: "a1"}, time.Now())
+	fut := tasks.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	tasks.Submit(&global.Task{ID: "t2", Operation: "+"}, nil)
+	tasks.Submit(&global.Task{ID: "t3", Operation: "+"}, nil)
+	srv := &server{shutdownCtx: context.Background(), tasks: tasks, agents: agents, opts: Defaults}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(agentIDKey, "a1"))
+	stream := &fakeWorkStream{fakeStream: fakeStream{ctx: ctx}, requests: make(chan *taskpb.WorkRequest)}
+	done := make(chan error, 1)
+	go func() { done <- srv.Work(stream) }()
+
+	stream.requests <- &taskpb.WorkRequest{Credits: 2}
+	time.Sleep(30 * time.Millisecond)
+	if n := stream.sent(); n != 2 {
+		t.Fatalf("Sent = %d tasks for 2 credits", n)
+	}
+	stream.requests <- &taskpb.WorkRequest{Credits: 1, Result: &taskpb.SolvedTask{Id: "t1", Result: 5}}
+	time.Sleep(30 * time.Millisecond)
+	if n := stream.sent(); n != 3 {
+		t.Errorf("Sent = %d tasks, want the third one after a returned credit", n)
+	}
+	if got := fut.Get(); got != 5 {
+		t.Errorf("Future.Get() = %v, want 5", got)
+	}
+	if agent, _ := agents.Get("a1"); agent.Completed != 1 {
+		t.Errorf("Completed = %d, want 1", agent.Completed)
+	}
+	close(stream.requests)
+	if err := <-done; err != nil {
+		t.Fatalf("Work returned error after the agent closed the stream: %v", err)
+	}
+}
+
+func TestSendResult_ReportedErrorFailsTask(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	fut := tasks.Submit(&global.Task{ID: "t-err", Operation: "%"}, nil)
+	srv := &server{tasks: tasks, agents: NewRegistry(), opts: Defaults}
+	srv.SendResult(context.Background(), &taskpb.SolvedTask{
+		Id:           "t-err",
+		ErrorCode:    taskpb.ErrorCode_ERROR_UNSUPPORTED_OPERATION,
+		ErrorMessage: `unsupported operation "%"`,
+	})
+	if _, err := fut.Wait(context.Background()); err == nil || err.Error() != `unsupported operation "%"` {
+		t.Errorf("future error = %v, want the agent's message", err)
+	}
+
+	fut = tasks.Submit(&global.Task{ID: "t-nan", Operation: "-"}, nil)
+	srv.SendResult(context.Background(), &taskpb.SolvedTask{Id: "t-nan", ErrorCode: taskpb.ErrorCode_ERROR_NAN})
+	if _, err := fut.Wait(context.Background()); err == nil || err.Error() != "nan" {
+		t.Errorf("future error = %v, want the code name", err)
+	}
+}
+
+type fakeBatchStream struct {
+	fakeStream
+	mu       sync.Mutex
+	batches  []*taskpb.TaskBatch
+	requests chan *taskpb.ResultBatch
+}
+
+func (f *fakeBatchStream) Send(batch *taskpb.TaskBatch) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, batch)
+	return nil
+}
+
+func (f *fakeBatchStream) Recv() (*taskpb.ResultBatch, error) {
+	req, ok := <-f.requests
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func (f *fakeBatchStream) sizes() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var sizes []int
+	for _, batch := range f.batches {
+		sizes = append(sizes, len(batch.Tasks))
+	}
+	return sizes
+}
+
+func TestWorkBatch_SendsNegotiatedBatches(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	opts := Defaults
+	opts.MaxBatchSize = 3
+	srv := &server{shutdownCtx: context.Background(), tasks: tasks, agents: NewRegistry(), opts: opts}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(agentIDKey, "a1"))
+	reg, err := srv.RegisterAgent(ctx, &taskpb.AgentInfo{AgentId: "a1", BatchSize: 10})
+	if err != nil || reg.GetBatchSize() != 3 {
+		t.Fatalf("RegisterAgent = %+v, %v, want batch size 3", reg, err)
+	}
+	var futures []*global.Future
+	for _, id := range []string{"t1", "t2", "t3", "t4", "t5"} {
+		futures = append(futures, tasks.Submit(&global.Task{ID: id, Operation: "+"}, nil))
+	}
+	stream := &fakeBatchStream{fakeStream: fakeStream{ctx: ctx}, requests: make(chan *taskpb.ResultBatch)}
+	done := make(chan error, 1)
+	go func() { done <- srv.WorkBatch(stream) }()
+
+	stream.requests <- &taskpb.ResultBatch{Credits: 4}
+	time.Sleep(30 * time.Millisecond)
+	if got := stream.sizes(); !reflect.DeepEqual(got, []int{3, 1}) {
+		t.Fatalf("batch sizes = %v, want [3 1] for 4 credits", got)
+	}
+	stream.requests <- &taskpb.ResultBatch{Credits: 2, Results: []*taskpb.SolvedTask{{Id: "t1", Result: 1}, {Id: "t2", Result: 2}}}
+	time.Sleep(30 * time.Millisecond)
+	if got := stream.sizes(); !reflect.DeepEqual(got, []int{3, 1, 1}) {
+		t.Errorf("batch sizes = %v, want the last task after returned credits", got)
+	}
+	for i, want := range []float64{1, 2} {
+		if got := futures[i].Get(); got != want {
+			t.Errorf("future %d = %v, want %v", i, got, want)
+		}
+	}
+	close(stream.requests)
+	if err := <-done; err != nil {
+		t.Fatalf("WorkBatch returned error: %v", err)
+	}
+}
+
+func TestSendResult_FlagsAgentOutvotedByVerification(t *testing.T) {
+	tasks := queue.New(queue.Options{Aging: queue.Defaults.Aging, VerificationRate: 1})
+	agents := NewRegistry()
+	for _, id := range []string{"a1", "a2", "a3"} {
+		agents.Register(Agent{ID: id}, time.Now())
+	}
+	fut := tasks.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	srv := &server{tasks: tasks, agents: agents, opts: Defaults}
+	answer := func(agent string, result float64) {
+		task := tasks.TryNext(agent, time.Second, nil)
+		if task == nil {
+			t.Fatalf("no replica for %s", agent)
+		}
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(agentIDKey, agent))
+		srv.SendResult(ctx, &taskpb.SolvedTask{Id: task.ID, Result: result})
+	}
+	answer("a1", 3)
+	answer("a2", 4)
+	answer("a3", 3)
+	if got := fut.Get(); got != 3 {
+		t.Errorf("Future.Get() = %v, want the majority result 3", got)
+	}
+	for id, flagged := range map[string]bool{"a1": false, "a2": true, "a3": false} {
+		if agent, _ := agents.Get(id); agent.Flagged != flagged {
+			t.Errorf("agent %s flagged = %v, want %v", id, agent.Flagged, flagged)
+		}
+	}
+}
+
+func TestRegisterAgent_BindsIDToSessionWithToken(t *testing.T) {
+	session := func(key string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(agentIDKey, "a1", agentSessionKey, key))
+	}
+	opts := Defaults
+	opts.Token = "secret"
+	srv := &server{tasks: queue.New(queue.Defaults), agents: NewRegistry(), opts: opts}
+	if _, err := srv.RegisterAgent(session("s1"), &taskpb.AgentInfo{AgentId: "a1"}); err != nil {
+		t.Fatalf("RegisterAgent error: %v", err)
+	}
+	if _, err := srv.RegisterAgent(session("s2"), &taskpb.AgentInfo{AgentId: "a1"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("RegisterAgent from another session error = %v, want PermissionDenied", err)
+	}
+	if _, err := srv.Heartbeat(session("s2"), &taskpb.AgentHeartbeat{AgentId: "a1"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Heartbeat from another session error = %v, want PermissionDenied", err)
+	}
+	if _, err := srv.Heartbeat(session("s1"), &taskpb.AgentHeartbeat{AgentId: "a1"}); err != nil {
+		t.Errorf("Heartbeat from the registering session error = %v", err)
+	}
+	srv.agents.Expire(time.Now().Add(time.Minute))
+	if _, err := srv.RegisterAgent(session("s2"), &taskpb.AgentInfo{AgentId: "a1"}); err != nil {
+		t.Errorf("RegisterAgent after expiry error = %v, want the restarted agent accepted", err)
+	}
+
+	srv.opts.Token = ""
+	if _, err := srv.RegisterAgent(session("s3"), &taskpb.AgentInfo{AgentId: "a1"}); err != nil {
+		t.Errorf("RegisterAgent without a token error = %v", err)
+	}
 }
@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"calculator/internal/global"
+	"calculator/internal/queue"
+	"calculator/pkg/loggers"
+	"context"
+	"time"
+)
+
+// straggling сообщает, что задача у агента дольше max(factor*OperationTime, minDelay).
+func straggling(task *global.Task, outstanding time.Duration, factor float64, minDelay time.Duration) bool {
+	threshold := time.Duration(factor * float64(time.Duration(task.OperationTime)*time.Millisecond))
+	return outstanding >= max(threshold, minDelay)
+}
+
+// speculate дублирует отстающие задачи, если есть другой свободный агент,
+// умеющий их операцию: результат берётся от того, кто ответит первым.
+// factor 0 выключает дублирование.
+func speculate(ctx context.Context, tasks *queue.Queue, agents *Registry, interval time.Duration, factor float64, delay time.Duration) {
+	if factor == 0 {
+		return
+	}
+	logger := loggers.GetLogger("orchestrator")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			backups := tasks.Speculate(now, func(lease *global.Lease, outstanding time.Duration) bool {
+				return straggling(lease.Task, outstanding, factor, delay) && agents.Idle(lease.Task.Operation, lease.Agent)
+			})
+			for _, task := range backups {
+				logger.Info("straggler duplicated", "id", task.ID, "expression", task.ExpressionID, "operation", task.Operation)
+			}
+		}
+	}
+}
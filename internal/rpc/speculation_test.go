@@ -0,0 +1,72 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"calculator/internal/global"
+	"calculator/internal/queue"
+)
+
+func TestStraggling(t *testing.T) {
+	task := &global.Task{OperationTime: 1000}
+	tests := []struct {
+		outstanding time.Duration
+		want        bool
+	}{
+		{2 * time.Second, false},
+		{3 * time.Second, true},
+	}
+	for _, tt := range tests {
+		if got := straggling(task, tt.outstanding, 3, time.Second); got != tt.want {
+			t.Errorf("straggling(%v) = %v, want %v", tt.outstanding, got, tt.want)
+		}
+	}
+	if straggling(&global.Task{}, 500*time.Millisecond, 3, time.Second) {
+		t.Error("a zero-time task was duplicated before the minimum delay")
+	}
+}
+
+func TestRegistryIdle(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Agent{ID: "a1", ComputingPower: 2}, time.Now())
+	if r.Idle("+", "a1") {
+		t.Error("the only agent was reported as another idle agent")
+	}
+	r.Register(Agent{ID: "a2", ComputingPower: 2, Load: 2}, time.Now())
+	if r.Idle("+", "a1") {
+		t.Error("a fully loaded agent was reported idle")
+	}
+	r.Heartbeat("a2", 1, time.Now())
+	if !r.Idle("+", "a1") {
+		t.Error("an agent with free capacity was not reported idle")
+	}
+}
+
+func TestSpeculateDuplicatesStragglerForIdleAgent(t *testing.T) {
+	tasks := queue.New(queue.Defaults)
+	agents := NewRegistry()
+	agents.Register(Agent{ID: "slow"}, time.Now())
+	fut := tasks.Submit(&global.Task{ID: "t1", Operation: "+"}, nil)
+	if _, err := tasks.Next(context.Background(), "slow", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go speculate(ctx, tasks, agents, 5*time.Millisecond, Defaults.StragglerFactor, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	if n := tasks.Len(); n != 0 {
+		t.Fatalf("%d duplicates queued without another agent", n)
+	}
+	agents.Register(Agent{ID: "fast"}, time.Now())
+	backup, err := tasks.Next(context.Background(), "fast", time.Minute)
+	if err != nil || backup.ID != "t1/spec" {
+		t.Fatalf("Next = %+v, %v, want the duplicate", backup, err)
+	}
+	tasks.Complete(backup.ID, "fast", 2)
+	if got := fut.Get(); got != 2 {
+		t.Errorf("Future.Get() = %v, want 2", got)
+	}
+}
@@ -21,6 +21,62 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// ErrorCode — почему агент не смог вычислить операцию.
+type ErrorCode int32
+
+const (
+	ErrorCode_ERROR_NONE                  ErrorCode = 0
+	ErrorCode_ERROR_UNSUPPORTED_OPERATION ErrorCode = 1
+	ErrorCode_ERROR_DIVISION_BY_ZERO      ErrorCode = 2
+	ErrorCode_ERROR_OVERFLOW              ErrorCode = 3
+	ErrorCode_ERROR_NAN                   ErrorCode = 4
+)
+
+// Enum value maps for ErrorCode.
+var (
+	ErrorCode_name = map[int32]string{
+		0: "ERROR_NONE",
+		1: "ERROR_UNSUPPORTED_OPERATION",
+		2: "ERROR_DIVISION_BY_ZERO",
+		3: "ERROR_OVERFLOW",
+		4: "ERROR_NAN",
+	}
+	ErrorCode_value = map[string]int32{
+		"ERROR_NONE":                  0,
+		"ERROR_UNSUPPORTED_OPERATION": 1,
+		"ERROR_DIVISION_BY_ZERO":      2,
+		"ERROR_OVERFLOW":              3,
+		"ERROR_NAN":                   4,
+	}
+)
+
+func (x ErrorCode) Enum() *ErrorCode {
+	p := new(ErrorCode)
+	*p = x
+	return p
+}
+
+func (x ErrorCode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ErrorCode) Descriptor() protoreflect.EnumDescriptor {
+	return file_internal_task_task_proto_enumTypes[0].Descriptor()
+}
+
+func (ErrorCode) Type() protoreflect.EnumType {
+	return &file_internal_task_task_proto_enumTypes[0]
+}
+
+func (x ErrorCode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ErrorCode.Descriptor instead.
+func (ErrorCode) EnumDescriptor() ([]byte, []int) {
+	return file_internal_task_task_proto_rawDescGZIP(), []int{0}
+}
+
 type Empty struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
@@ -64,6 +120,7 @@ type Task struct {
 	Arg2          float64                `protobuf:"fixed64,3,opt,name=arg2,proto3" json:"arg2,omitempty"`
 	Operation     string                 `protobuf:"bytes,4,opt,name=operation,proto3" json:"operation,omitempty"`
 	OperationTime int32                  `protobuf:"varint,5,opt,name=operation_time,json=operationTime,proto3" json:"operation_time,omitempty"`
+	Priority      int32                  `protobuf:"varint,6,opt,name=priority,proto3" json:"priority,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -133,10 +190,20 @@ func (x *Task) GetOperationTime() int32 {
 	return 0
 }
 
+func (x *Task) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+// SolvedTask — результат задачи; при error_code != ERROR_NONE поле result не используется.
 type SolvedTask struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Result        float64                `protobuf:"fixed64,2,opt,name=result,proto3" json:"result,omitempty"`
+	ErrorCode     ErrorCode              `protobuf:"varint,3,opt,name=error_code,json=errorCode,proto3,enum=task.ErrorCode" json:"error_code,omitempty"`
+	ErrorMessage  string                 `protobuf:"bytes,4,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -185,27 +252,557 @@ func (x *SolvedTask) GetResult() float64 {
 	return 0
 }
 
+func (x *SolvedTask) GetErrorCode() ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ErrorCode_ERROR_NONE
+}
+
+func (x *SolvedTask) GetErrorMessage() string {
+	if x != nil {
+		return x.ErrorMessage
+	}
+	return ""
+}
+
+// WorkRequest — сообщение агента в потоке Work: новые кредиты (сколько ещё
+// задач он готов принять) и, если есть, результат выполненной задачи.
+type WorkRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Credits       int32                  `protobuf:"varint,1,opt,name=credits,proto3" json:"credits,omitempty"`
+	Result        *SolvedTask            `protobuf:"bytes,2,opt,name=result,proto3" json:"result,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WorkRequest) Reset() {
+	*x = WorkRequest{}
+	mi := &file_internal_task_task_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WorkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkRequest) ProtoMessage() {}
+
+func (x *WorkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_task_task_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkRequest.ProtoReflect.Descriptor instead.
+func (*WorkRequest) Descriptor() ([]byte, []int) {
+	return file_internal_task_task_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *WorkRequest) GetCredits() int32 {
+	if x != nil {
+		return x.Credits
+	}
+	return 0
+}
+
+func (x *WorkRequest) GetResult() *SolvedTask {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+type Cancellation struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ExpressionId  string                 `protobuf:"bytes,1,opt,name=expression_id,json=expressionId,proto3" json:"expression_id,omitempty"`
+	TaskIds       []string               `protobuf:"bytes,2,rep,name=task_ids,json=taskIds,proto3" json:"task_ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Cancellation) Reset() {
+	*x = Cancellation{}
+	mi := &file_internal_task_task_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Cancellation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Cancellation) ProtoMessage() {}
+
+func (x *Cancellation) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_task_task_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Cancellation.ProtoReflect.Descriptor instead.
+func (*Cancellation) Descriptor() ([]byte, []int) {
+	return file_internal_task_task_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Cancellation) GetExpressionId() string {
+	if x != nil {
+		return x.ExpressionId
+	}
+	return ""
+}
+
+func (x *Cancellation) GetTaskIds() []string {
+	if x != nil {
+		return x.TaskIds
+	}
+	return nil
+}
+
+type AgentInfo struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	AgentId        string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Hostname       string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Version        string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+	ComputingPower int32                  `protobuf:"varint,4,opt,name=computing_power,json=computingPower,proto3" json:"computing_power,omitempty"`
+	Load           int32                  `protobuf:"varint,5,opt,name=load,proto3" json:"load,omitempty"`
+	Operations     []string               `protobuf:"bytes,6,rep,name=operations,proto3" json:"operations,omitempty"`
+	BatchSize      int32                  `protobuf:"varint,7,opt,name=batch_size,json=batchSize,proto3" json:"batch_size,omitempty"` // желаемый размер пакета задач
+	Name           string                 `protobuf:"bytes,8,opt,name=name,proto3" json:"name,omitempty"`                             // имя агента для администратора, по умолчанию hostname
+	Labels         map[string]string      `protobuf:"bytes,9,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *AgentInfo) Reset() {
+	*x = AgentInfo{}
+	mi := &file_internal_task_task_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentInfo) ProtoMessage() {}
+
+func (x *AgentInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_task_task_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentInfo.ProtoReflect.Descriptor instead.
+func (*AgentInfo) Descriptor() ([]byte, []int) {
+	return file_internal_task_task_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *AgentInfo) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *AgentInfo) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *AgentInfo) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *AgentInfo) GetComputingPower() int32 {
+	if x != nil {
+		return x.ComputingPower
+	}
+	return 0
+}
+
+func (x *AgentInfo) GetLoad() int32 {
+	if x != nil {
+		return x.Load
+	}
+	return 0
+}
+
+func (x *AgentInfo) GetOperations() []string {
+	if x != nil {
+		return x.Operations
+	}
+	return nil
+}
+
+func (x *AgentInfo) GetBatchSize() int32 {
+	if x != nil {
+		return x.BatchSize
+	}
+	return 0
+}
+
+func (x *AgentInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *AgentInfo) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type Registration struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	HeartbeatIntervalMs int64                  `protobuf:"varint,1,opt,name=heartbeat_interval_ms,json=heartbeatIntervalMs,proto3" json:"heartbeat_interval_ms,omitempty"`
+	BatchSize           int32                  `protobuf:"varint,2,opt,name=batch_size,json=batchSize,proto3" json:"batch_size,omitempty"` // согласованный размер пакета для WorkBatch
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *Registration) Reset() {
+	*x = Registration{}
+	mi := &file_internal_task_task_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Registration) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Registration) ProtoMessage() {}
+
+func (x *Registration) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_task_task_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Registration.ProtoReflect.Descriptor instead.
+func (*Registration) Descriptor() ([]byte, []int) {
+	return file_internal_task_task_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Registration) GetHeartbeatIntervalMs() int64 {
+	if x != nil {
+		return x.HeartbeatIntervalMs
+	}
+	return 0
+}
+
+func (x *Registration) GetBatchSize() int32 {
+	if x != nil {
+		return x.BatchSize
+	}
+	return 0
+}
+
+// TaskBatch — до batch_size задач одним сообщением.
+type TaskBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tasks         []*Task                `protobuf:"bytes,1,rep,name=tasks,proto3" json:"tasks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskBatch) Reset() {
+	*x = TaskBatch{}
+	mi := &file_internal_task_task_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskBatch) ProtoMessage() {}
+
+func (x *TaskBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_task_task_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskBatch.ProtoReflect.Descriptor instead.
+func (*TaskBatch) Descriptor() ([]byte, []int) {
+	return file_internal_task_task_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *TaskBatch) GetTasks() []*Task {
+	if x != nil {
+		return x.Tasks
+	}
+	return nil
+}
+
+// ResultBatch — пакетный аналог WorkRequest: кредиты и готовые результаты.
+type ResultBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Credits       int32                  `protobuf:"varint,1,opt,name=credits,proto3" json:"credits,omitempty"`
+	Results       []*SolvedTask          `protobuf:"bytes,2,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResultBatch) Reset() {
+	*x = ResultBatch{}
+	mi := &file_internal_task_task_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResultBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResultBatch) ProtoMessage() {}
+
+func (x *ResultBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_task_task_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResultBatch.ProtoReflect.Descriptor instead.
+func (*ResultBatch) Descriptor() ([]byte, []int) {
+	return file_internal_task_task_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ResultBatch) GetCredits() int32 {
+	if x != nil {
+		return x.Credits
+	}
+	return 0
+}
+
+func (x *ResultBatch) GetResults() []*SolvedTask {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type AgentHeartbeat struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Load          int32                  `protobuf:"varint,2,opt,name=load,proto3" json:"load,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentHeartbeat) Reset() {
+	*x = AgentHeartbeat{}
+	mi := &file_internal_task_task_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentHeartbeat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentHeartbeat) ProtoMessage() {}
+
+func (x *AgentHeartbeat) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_task_task_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentHeartbeat.ProtoReflect.Descriptor instead.
+func (*AgentHeartbeat) Descriptor() ([]byte, []int) {
+	return file_internal_task_task_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *AgentHeartbeat) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *AgentHeartbeat) GetLoad() int32 {
+	if x != nil {
+		return x.Load
+	}
+	return 0
+}
+
+type HeartbeatAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Registered    bool                   `protobuf:"varint,1,opt,name=registered,proto3" json:"registered,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatAck) Reset() {
+	*x = HeartbeatAck{}
+	mi := &file_internal_task_task_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatAck) ProtoMessage() {}
+
+func (x *HeartbeatAck) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_task_task_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatAck.ProtoReflect.Descriptor instead.
+func (*HeartbeatAck) Descriptor() ([]byte, []int) {
+	return file_internal_task_task_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *HeartbeatAck) GetRegistered() bool {
+	if x != nil {
+		return x.Registered
+	}
+	return false
+}
+
 var File_internal_task_task_proto protoreflect.FileDescriptor
 
 const file_internal_task_task_proto_rawDesc = "" +
 	"\n" +
 	"\x18internal/task/task.proto\x12\x04task\"\a\n" +
-	"\x05Empty\"\x83\x01\n" +
+	"\x05Empty\"\x9f\x01\n" +
 	"\x04Task\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
 	"\x04arg1\x18\x02 \x01(\x01R\x04arg1\x12\x12\n" +
 	"\x04arg2\x18\x03 \x01(\x01R\x04arg2\x12\x1c\n" +
 	"\toperation\x18\x04 \x01(\tR\toperation\x12%\n" +
-	"\x0eoperation_time\x18\x05 \x01(\x05R\roperationTime\"4\n" +
+	"\x0eoperation_time\x18\x05 \x01(\x05R\roperationTime\x12\x1a\n" +
+	"\bpriority\x18\x06 \x01(\x05R\bpriority\"\x89\x01\n" +
 	"\n" +
 	"SolvedTask\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
-	"\x06result\x18\x02 \x01(\x01R\x06result2b\n" +
+	"\x06result\x18\x02 \x01(\x01R\x06result\x12.\n" +
+	"\n" +
+	"error_code\x18\x03 \x01(\x0e2\x0f.task.ErrorCodeR\terrorCode\x12#\n" +
+	"\rerror_message\x18\x04 \x01(\tR\ferrorMessage\"Q\n" +
+	"\vWorkRequest\x12\x18\n" +
+	"\acredits\x18\x01 \x01(\x05R\acredits\x12(\n" +
+	"\x06result\x18\x02 \x01(\v2\x10.task.SolvedTaskR\x06result\"N\n" +
+	"\fCancellation\x12#\n" +
+	"\rexpression_id\x18\x01 \x01(\tR\fexpressionId\x12\x19\n" +
+	"\btask_ids\x18\x02 \x03(\tR\ataskIds\"\xdc\x02\n" +
+	"\tAgentInfo\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1a\n" +
+	"\bhostname\x18\x02 \x01(\tR\bhostname\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\tR\aversion\x12'\n" +
+	"\x0fcomputing_power\x18\x04 \x01(\x05R\x0ecomputingPower\x12\x12\n" +
+	"\x04load\x18\x05 \x01(\x05R\x04load\x12\x1e\n" +
+	"\n" +
+	"operations\x18\x06 \x03(\tR\n" +
+	"operations\x12\x1d\n" +
+	"\n" +
+	"batch_size\x18\a \x01(\x05R\tbatchSize\x12\x12\n" +
+	"\x04name\x18\b \x01(\tR\x04name\x123\n" +
+	"\x06labels\x18\t \x03(\v2\x1b.task.AgentInfo.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"a\n" +
+	"\fRegistration\x122\n" +
+	"\x15heartbeat_interval_ms\x18\x01 \x01(\x03R\x13heartbeatIntervalMs\x12\x1d\n" +
+	"\n" +
+	"batch_size\x18\x02 \x01(\x05R\tbatchSize\"-\n" +
+	"\tTaskBatch\x12 \n" +
+	"\x05tasks\x18\x01 \x03(\v2\n" +
+	".task.TaskR\x05tasks\"S\n" +
+	"\vResultBatch\x12\x18\n" +
+	"\acredits\x18\x01 \x01(\x05R\acredits\x12*\n" +
+	"\aresults\x18\x02 \x03(\v2\x10.task.SolvedTaskR\aresults\"?\n" +
+	"\x0eAgentHeartbeat\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x12\n" +
+	"\x04load\x18\x02 \x01(\x05R\x04load\".\n" +
+	"\fHeartbeatAck\x12\x1e\n" +
+	"\n" +
+	"registered\x18\x01 \x01(\bR\n" +
+	"registered*{\n" +
+	"\tErrorCode\x12\x0e\n" +
+	"\n" +
+	"ERROR_NONE\x10\x00\x12\x1f\n" +
+	"\x1bERROR_UNSUPPORTED_OPERATION\x10\x01\x12\x1a\n" +
+	"\x16ERROR_DIVISION_BY_ZERO\x10\x02\x12\x12\n" +
+	"\x0eERROR_OVERFLOW\x10\x03\x12\r\n" +
+	"\tERROR_NAN\x10\x042\xe8\x02\n" +
 	"\fOrchestrator\x12%\n" +
 	"\bGetTasks\x12\v.task.Empty\x1a\n" +
 	".task.Task0\x01\x12+\n" +
 	"\n" +
-	"SendResult\x12\x10.task.SolvedTask\x1a\v.task.EmptyB!Z\x1fcalculator/internal/task;taskpbb\x06proto3"
+	"SendResult\x12\x10.task.SolvedTask\x1a\v.task.Empty\x12)\n" +
+	"\x04Work\x12\x11.task.WorkRequest\x1a\n" +
+	".task.Task(\x010\x01\x123\n" +
+	"\tWorkBatch\x12\x11.task.ResultBatch\x1a\x0f.task.TaskBatch(\x010\x01\x127\n" +
+	"\x12WatchCancellations\x12\v.task.Empty\x1a\x12.task.Cancellation0\x01\x124\n" +
+	"\rRegisterAgent\x12\x0f.task.AgentInfo\x1a\x12.task.Registration\x125\n" +
+	"\tHeartbeat\x12\x14.task.AgentHeartbeat\x1a\x12.task.HeartbeatAckB!Z\x1fcalculator/internal/task;taskpbb\x06proto3"
 
 var (
 	file_internal_task_task_proto_rawDescOnce sync.Once
@@ -219,22 +816,48 @@ func file_internal_task_task_proto_rawDescGZIP() []byte {
 	return file_internal_task_task_proto_rawDescData
 }
 
-var file_internal_task_task_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_internal_task_task_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_internal_task_task_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
 var file_internal_task_task_proto_goTypes = []any{
-	(*Empty)(nil),      // 0: task.Empty
-	(*Task)(nil),       // 1: task.Task
-	(*SolvedTask)(nil), // 2: task.SolvedTask
+	(ErrorCode)(0),         // 0: task.ErrorCode
+	(*Empty)(nil),          // 1: task.Empty
+	(*Task)(nil),           // 2: task.Task
+	(*SolvedTask)(nil),     // 3: task.SolvedTask
+	(*WorkRequest)(nil),    // 4: task.WorkRequest
+	(*Cancellation)(nil),   // 5: task.Cancellation
+	(*AgentInfo)(nil),      // 6: task.AgentInfo
+	(*Registration)(nil),   // 7: task.Registration
+	(*TaskBatch)(nil),      // 8: task.TaskBatch
+	(*ResultBatch)(nil),    // 9: task.ResultBatch
+	(*AgentHeartbeat)(nil), // 10: task.AgentHeartbeat
+	(*HeartbeatAck)(nil),   // 11: task.HeartbeatAck
+	nil,                    // 12: task.AgentInfo.LabelsEntry
 }
 var file_internal_task_task_proto_depIdxs = []int32{
-	0, // 0: task.Orchestrator.GetTasks:input_type -> task.Empty
-	2, // 1: task.Orchestrator.SendResult:input_type -> task.SolvedTask
-	1, // 2: task.Orchestrator.GetTasks:output_type -> task.Task
-	0, // 3: task.Orchestrator.SendResult:output_type -> task.Empty
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0,  // 0: task.SolvedTask.error_code:type_name -> task.ErrorCode
+	3,  // 1: task.WorkRequest.result:type_name -> task.SolvedTask
+	12, // 2: task.AgentInfo.labels:type_name -> task.AgentInfo.LabelsEntry
+	2,  // 3: task.TaskBatch.tasks:type_name -> task.Task
+	3,  // 4: task.ResultBatch.results:type_name -> task.SolvedTask
+	1,  // 5: task.Orchestrator.GetTasks:input_type -> task.Empty
+	3,  // 6: task.Orchestrator.SendResult:input_type -> task.SolvedTask
+	4,  // 7: task.Orchestrator.Work:input_type -> task.WorkRequest
+	9,  // 8: task.Orchestrator.WorkBatch:input_type -> task.ResultBatch
+	1,  // 9: task.Orchestrator.WatchCancellations:input_type -> task.Empty
+	6,  // 10: task.Orchestrator.RegisterAgent:input_type -> task.AgentInfo
+	10, // 11: task.Orchestrator.Heartbeat:input_type -> task.AgentHeartbeat
+	2,  // 12: task.Orchestrator.GetTasks:output_type -> task.Task
+	1,  // 13: task.Orchestrator.SendResult:output_type -> task.Empty
+	2,  // 14: task.Orchestrator.Work:output_type -> task.Task
+	8,  // 15: task.Orchestrator.WorkBatch:output_type -> task.TaskBatch
+	5,  // 16: task.Orchestrator.WatchCancellations:output_type -> task.Cancellation
+	7,  // 17: task.Orchestrator.RegisterAgent:output_type -> task.Registration
+	11, // 18: task.Orchestrator.Heartbeat:output_type -> task.HeartbeatAck
+	12, // [12:19] is the sub-list for method output_type
+	5,  // [5:12] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_internal_task_task_proto_init() }
@@ -247,13 +870,14 @@ func file_internal_task_task_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_task_task_proto_rawDesc), len(file_internal_task_task_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   3,
+			NumEnums:      1,
+			NumMessages:   12,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_internal_task_task_proto_goTypes,
 		DependencyIndexes: file_internal_task_task_proto_depIdxs,
+		EnumInfos:         file_internal_task_task_proto_enumTypes,
 		MessageInfos:      file_internal_task_task_proto_msgTypes,
 	}.Build()
 	File_internal_task_task_proto = out.File
@@ -19,8 +19,13 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Orchestrator_GetTasks_FullMethodName   = "/task.Orchestrator/GetTasks"
-	Orchestrator_SendResult_FullMethodName = "/task.Orchestrator/SendResult"
+	Orchestrator_GetTasks_FullMethodName           = "/task.Orchestrator/GetTasks"
+	Orchestrator_SendResult_FullMethodName         = "/task.Orchestrator/SendResult"
+	Orchestrator_Work_FullMethodName               = "/task.Orchestrator/Work"
+	Orchestrator_WorkBatch_FullMethodName          = "/task.Orchestrator/WorkBatch"
+	Orchestrator_WatchCancellations_FullMethodName = "/task.Orchestrator/WatchCancellations"
+	Orchestrator_RegisterAgent_FullMethodName      = "/task.Orchestrator/RegisterAgent"
+	Orchestrator_Heartbeat_FullMethodName          = "/task.Orchestrator/Heartbeat"
 )
 
 // OrchestratorClient is the client API for Orchestrator service.
@@ -29,6 +34,11 @@ const (
 type OrchestratorClient interface {
 	GetTasks(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Task], error)
 	SendResult(ctx context.Context, in *SolvedTask, opts ...grpc.CallOption) (*Empty, error)
+	Work(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[WorkRequest, Task], error)
+	WorkBatch(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ResultBatch, TaskBatch], error)
+	WatchCancellations(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Cancellation], error)
+	RegisterAgent(ctx context.Context, in *AgentInfo, opts ...grpc.CallOption) (*Registration, error)
+	Heartbeat(ctx context.Context, in *AgentHeartbeat, opts ...grpc.CallOption) (*HeartbeatAck, error)
 }
 
 type orchestratorClient struct {
@@ -68,12 +78,82 @@ func (c *orchestratorClient) SendResult(ctx context.Context, in *SolvedTask, opt
 	return out, nil
 }
 
+func (c *orchestratorClient) Work(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[WorkRequest, Task], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Orchestrator_ServiceDesc.Streams[1], Orchestrator_Work_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WorkRequest, Task]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Orchestrator_WorkClient = grpc.BidiStreamingClient[WorkRequest, Task]
+
+func (c *orchestratorClient) WorkBatch(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ResultBatch, TaskBatch], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Orchestrator_ServiceDesc.Streams[2], Orchestrator_WorkBatch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ResultBatch, TaskBatch]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Orchestrator_WorkBatchClient = grpc.BidiStreamingClient[ResultBatch, TaskBatch]
+
+func (c *orchestratorClient) WatchCancellations(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Cancellation], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Orchestrator_ServiceDesc.Streams[3], Orchestrator_WatchCancellations_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Empty, Cancellation]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Orchestrator_WatchCancellationsClient = grpc.ServerStreamingClient[Cancellation]
+
+func (c *orchestratorClient) RegisterAgent(ctx context.Context, in *AgentInfo, opts ...grpc.CallOption) (*Registration, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Registration)
+	err := c.cc.Invoke(ctx, Orchestrator_RegisterAgent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorClient) Heartbeat(ctx context.Context, in *AgentHeartbeat, opts ...grpc.CallOption) (*HeartbeatAck, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HeartbeatAck)
+	err := c.cc.Invoke(ctx, Orchestrator_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // OrchestratorServer is the server API for Orchestrator service.
 // All implementations must embed UnimplementedOrchestratorServer
 // for forward compatibility.
 type OrchestratorServer interface {
 	GetTasks(*Empty, grpc.ServerStreamingServer[Task]) error
 	SendResult(context.Context, *SolvedTask) (*Empty, error)
+	Work(grpc.BidiStreamingServer[WorkRequest, Task]) error
+	WorkBatch(grpc.BidiStreamingServer[ResultBatch, TaskBatch]) error
+	WatchCancellations(*Empty, grpc.ServerStreamingServer[Cancellation]) error
+	RegisterAgent(context.Context, *AgentInfo) (*Registration, error)
+	Heartbeat(context.Context, *AgentHeartbeat) (*HeartbeatAck, error)
 	mustEmbedUnimplementedOrchestratorServer()
 }
 
@@ -90,6 +170,21 @@ func (UnimplementedOrchestratorServer) GetTasks(*Empty, grpc.ServerStreamingServ
 func (UnimplementedOrchestratorServer) SendResult(context.Context, *SolvedTask) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendResult not implemented")
 }
+func (UnimplementedOrchestratorServer) Work(grpc.BidiStreamingServer[WorkRequest, Task]) error {
+	return status.Errorf(codes.Unimplemented, "method Work not implemented")
+}
+func (UnimplementedOrchestratorServer) WorkBatch(grpc.BidiStreamingServer[ResultBatch, TaskBatch]) error {
+	return status.Errorf(codes.Unimplemented, "method WorkBatch not implemented")
+}
+func (UnimplementedOrchestratorServer) WatchCancellations(*Empty, grpc.ServerStreamingServer[Cancellation]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchCancellations not implemented")
+}
+func (UnimplementedOrchestratorServer) RegisterAgent(context.Context, *AgentInfo) (*Registration, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterAgent not implemented")
+}
+func (UnimplementedOrchestratorServer) Heartbeat(context.Context, *AgentHeartbeat) (*HeartbeatAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
 func (UnimplementedOrchestratorServer) mustEmbedUnimplementedOrchestratorServer() {}
 func (UnimplementedOrchestratorServer) testEmbeddedByValue()                      {}
 
@@ -140,6 +235,67 @@ func _Orchestrator_SendResult_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Orchestrator_Work_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(OrchestratorServer).Work(&grpc.GenericServerStream[WorkRequest, Task]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Orchestrator_WorkServer = grpc.BidiStreamingServer[WorkRequest, Task]
+
+func _Orchestrator_WorkBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(OrchestratorServer).WorkBatch(&grpc.GenericServerStream[ResultBatch, TaskBatch]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Orchestrator_WorkBatchServer = grpc.BidiStreamingServer[ResultBatch, TaskBatch]
+
+func _Orchestrator_WatchCancellations_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrchestratorServer).WatchCancellations(m, &grpc.GenericServerStream[Empty, Cancellation]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Orchestrator_WatchCancellationsServer = grpc.ServerStreamingServer[Cancellation]
+
+func _Orchestrator_RegisterAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentInfo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).RegisterAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_RegisterAgent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).RegisterAgent(ctx, req.(*AgentInfo))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orchestrator_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentHeartbeat)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).Heartbeat(ctx, req.(*AgentHeartbeat))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Orchestrator_ServiceDesc is the grpc.ServiceDesc for Orchestrator service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -151,6 +307,14 @@ var Orchestrator_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SendResult",
 			Handler:    _Orchestrator_SendResult_Handler,
 		},
+		{
+			MethodName: "RegisterAgent",
+			Handler:    _Orchestrator_RegisterAgent_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _Orchestrator_Heartbeat_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -158,6 +322,23 @@ var Orchestrator_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Orchestrator_GetTasks_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "Work",
+			Handler:       _Orchestrator_Work_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WorkBatch",
+			Handler:       _Orchestrator_WorkBatch_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchCancellations",
+			Handler:       _Orchestrator_WatchCancellations_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "internal/task/task.proto",
 }
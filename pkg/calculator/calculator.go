@@ -1,11 +1,15 @@
 package calculator
 
 import (
+	"calculator/internal/cost"
 	"calculator/internal/global"
 	"calculator/pkg/loggers"
+	"context"
 	"errors"
-	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 
 	"github.com/google/uuid"
@@ -66,6 +70,21 @@ func tokenize(expr string) ([]token, error) {
 	return tokens, nil
 }
 
+// CountOperations возвращает число операций выражения — столько задач получат агенты.
+func CountOperations(expr string) (int, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, tok := range tokens {
+		if tok.typ == tokenOperator {
+			count++
+		}
+	}
+	return count, nil
+}
+
 func precedence(op string) int {
 	switch op {
 	case "+", "-":
@@ -130,8 +149,58 @@ func wrapValueAsFuture(val float64) *global.Future {
 	return future
 }
 
-func evalRPN(tokens []token) (float64, error) {
+type dispatcher interface {
+	Submit(task *global.Task, node *global.TraceNode) *global.Future
+	Withdraw(ids []string) []string
+}
+
+type taskStore interface {
+	SaveTaskRecord(record global.TaskRecord) error
+}
+
+type evaluation struct {
+	expressionID string
+	userID       uint
+	priority     int
+	tasks        dispatcher
+	costs        cost.OperationCostModel
+	store        taskStore
+	records      map[int]global.TaskRecord
+	ops          []*global.TraceNode
+	taskIDs      []string
+}
+
+// running хранит функции отмены для выражений, которые сейчас вычисляются.
+var running sync.Map
+
+// buildTrace заранее строит дерево трассировки по RPN, чтобы у выражения,
+// которое ещё вычисляется, была видна вся структура.
+func buildTrace(rpn []token) (*global.Trace, []*global.TraceNode) {
+	trace := global.NewTrace()
+	var stack, ops []*global.TraceNode
+	for _, tok := range rpn {
+		switch tok.typ {
+		case tokenNumber:
+			num, _ := strconv.ParseFloat(tok.val, 64)
+			stack = append(stack, trace.Number(num))
+		case tokenOperator:
+			if len(stack) < 2 {
+				return trace, ops
+			}
+			n := trace.Operation(tok.val, stack[len(stack)-2], stack[len(stack)-1])
+			stack = append(stack[:len(stack)-2], n)
+			ops = append(ops, n)
+		}
+	}
+	if len(stack) == 1 {
+		trace.SetRoot(stack[0])
+	}
+	return trace, ops
+}
+
+func (e *evaluation) run(ctx context.Context, tokens []token) (float64, error) {
 	var stack []*global.Future
+	opIndex := 0
 	for _, tok := range tokens {
 		switch tok.typ {
 		case tokenNumber:
@@ -144,103 +213,247 @@ func evalRPN(tokens []token) (float64, error) {
 			if len(stack) < 2 {
 				return 0, errors.New("invalid expression")
 			}
-			b := stack[len(stack)-1].Get()
-			a := stack[len(stack)-2].Get()
+			b, err := stack[len(stack)-1].Wait(ctx)
+			if err != nil {
+				return 0, err
+			}
+			a, err := stack[len(stack)-2].Wait(ctx)
+			if err != nil {
+				return 0, err
+			}
 			stack = stack[:len(stack)-2]
-			future := global.NewFuture()
-			stack = append(stack, future)
-			var err error
-			t := 1000
-			switch tok.val {
-			case "+":
-				t, err = strconv.Atoi(os.Getenv("TIME_ADDITION_MS"))
-				if err != nil {
-					t = 1000
-				}
-			case "-":
-				t, err = strconv.Atoi(os.Getenv("TIME_SUBTRACTION_MS"))
-				if err != nil {
-					t = 1000
-				}
-			case "*":
-				t, err = strconv.Atoi(os.Getenv("TIME_MULTIPLICATIONS_MS"))
-				if err != nil {
-					t = 1000
-				}
-			case "/":
-				t, err = strconv.Atoi(os.Getenv("TIME_DIVISIONS_MS"))
-				if b == 0 {
-					return 0, errors.New("division by zero")
-				}
-				if err != nil {
-					t = 1000
+			if tok.val == "/" && b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			t, err := e.costs.Cost(e.userID, tok.val)
+			if err != nil {
+				return 0, err
+			}
+			var node *global.TraceNode
+			if opIndex < len(e.ops) {
+				node = e.ops[opIndex]
+			}
+			index := opIndex
+			opIndex++
+			record, ok := e.records[index]
+			if ok && record.Solved {
+				if node != nil {
+					node.Restored(record)
 				}
-			default:
-				return 0, errors.New("unknown operator: " + tok.val)
+				stack = append(stack, wrapValueAsFuture(record.Result))
+				continue
+			}
+			id := record.ID
+			if id == "" {
+				id = uuid.New().String()
 			}
 			task := global.Task{
-				ID:            uuid.New().String(),
+				ID:            id,
+				ExpressionID:  e.expressionID,
+				UserID:        e.userID,
 				Arg1:          a,
 				Arg2:          b,
 				Operation:     tok.val,
 				OperationTime: t,
+				Priority:      e.priority,
 			}
-			global.TasksMap.Store(task.ID, &task)
-			global.FuturesMap.Store(task.ID, future)
+			e.taskIDs = append(e.taskIDs, task.ID)
+			future := e.tasks.Submit(&task, node)
+			e.persist(ctx, index, &task, future)
+			stack = append(stack, future)
 		}
 	}
 	if len(stack) != 1 {
 		return 0, errors.New("invalid expression")
 	}
-	result := stack[0].Get()
-	return result, nil
+	return stack[0].Wait(ctx)
+}
+
+// persist сохраняет задачу в базе и дописывает результат, когда агент его пришлёт,
+// чтобы после перезапуска не пересчитывать уже решённые операции.
+func (e *evaluation) persist(ctx context.Context, index int, task *global.Task, future *global.Future) {
+	if e.store == nil {
+		return
+	}
+	logger := loggers.GetLogger("orchestrator")
+	record := global.TaskRecord{
+		ExpressionID: e.expressionID,
+		Index:        index,
+		ID:           task.ID,
+		Operation:    task.Operation,
+		Arg1:         task.Arg1,
+		Arg2:         task.Arg2,
+	}
+	if err := e.store.SaveTaskRecord(record); err != nil {
+		logger.Error("failed to save task", "id", task.ID, "err", err)
+	}
+	go func() {
+		result, err := future.Wait(ctx)
+		if err != nil {
+			return
+		}
+		record.Solved, record.Result = true, result
+		if err := e.store.SaveTaskRecord(record); err != nil {
+			logger.Error("failed to save task result", "id", task.ID, "err", err)
+		}
+	}()
+}
+
+// cleanup снимает задачи выражения и все их копии с очереди. Если вычисление
+// прервано, агентам рассылается отмена снятых задач.
+func (e *evaluation) cleanup(err error) {
+	withdrawn := e.tasks.Withdraw(e.taskIDs)
+	if err != nil && len(withdrawn) > 0 {
+		global.Cancellations.Publish(global.Cancellation{ExpressionID: e.expressionID, TaskIDs: withdrawn})
+	}
+}
+
+func Cancel(expressionID string) bool {
+	cancel, ok := running.Load(expressionID)
+	if ok {
+		cancel.(context.CancelFunc)()
+	}
+	return ok
 }
 
 type db interface {
-	UpdateExpressionStatus(id string, status string) error
+	UpdateActiveExpressionStatus(id string, status string) (bool, error)
 	GetExpressionByID(id string) (*global.ExpressionDTO, error)
 	UpdateExpressionResult(id string, result float64) error
+	GetTaskRecords(expressionID string) ([]global.TaskRecord, error)
+	SaveDeadLetter(letter global.DeadLetter) error
+	taskStore
+}
+
+// defaultTimeout — общий для сервера лимит времени на выражение в наносекундах;
+// 0 отключает лимит.
+var defaultTimeout atomic.Int64
+
+func init() {
+	defaultTimeout.Store(int64(10 * time.Minute))
 }
 
-func Calc(store db, expressionID string) {
-	err := store.UpdateExpressionStatus(expressionID, "processing")
+// SetDefaultTimeout задаёт лимит времени для выражений, у которых нет
+// собственного; 0 отключает лимит. Действует на выражения, запущенные после вызова.
+func SetDefaultTimeout(timeout time.Duration) {
+	defaultTimeout.Store(int64(timeout))
+}
+
+func DefaultTimeout() time.Duration {
+	return time.Duration(defaultTimeout.Load())
+}
+
+// setStatus записывает статус, только если выражение ещё активно; false —
+// выражение тем временем отменено (или уже завершено) и статус не изменён.
+func setStatus(store db, expressionID, status string) bool {
+	ok, err := store.UpdateActiveExpressionStatus(expressionID, status)
 	if err != nil {
 		panic(err)
 	}
+	return ok
+}
+
+func Calc(store db, tasks dispatcher, costs cost.OperationCostModel, expressionID string) {
 	expression, err := store.GetExpressionByID(expressionID)
 	if err != nil {
 		panic(err)
 	}
+	timeout := expression.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout()
+	}
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	// Функция отмены сохраняется до смены статуса: отмена, записанная после
+	// этой проверки, найдёт её, а записанная до — не даст перейти в processing.
+	running.Store(expressionID, cancel)
+	defer running.Delete(expressionID)
+	if !setStatus(store, expressionID, "processing") {
+		return
+	}
 	tokens, err := tokenize(expression.Data)
 	if err != nil {
-		err := store.UpdateExpressionStatus(expressionID, "calculation error: "+err.Error())
-		if err != nil {
-			panic(err)
-		}
+		setStatus(store, expressionID, "calculation error: "+err.Error())
 		return
 	}
 	rpn, err := shuntingYard(tokens)
 	if err != nil {
-		err := store.UpdateExpressionStatus(expressionID, "calculation error: "+err.Error())
-		if err != nil {
-			panic(err)
-		}
+		setStatus(store, expressionID, "calculation error: "+err.Error())
 		return
 	}
-	res, err := evalRPN(rpn)
+	saved, err := store.GetTaskRecords(expressionID)
 	if err != nil {
-		err := store.UpdateExpressionStatus(expressionID, "calculation error: "+err.Error())
-		if err != nil {
-			panic(err)
-		}
-	} else {
-		err := store.UpdateExpressionStatus(expressionID, "completed")
-		if err != nil {
-			panic(err)
-		}
+		panic(err)
+	}
+	records := make(map[int]global.TaskRecord, len(saved))
+	for _, record := range saved {
+		records[record.Index] = record
+	}
+	trace, ops := buildTrace(rpn)
+	global.TracesMap.Store(expressionID, trace)
+	defer global.TracesMap.Finish(expressionID)
+	e := &evaluation{
+		expressionID: expressionID,
+		userID:       expression.UserID,
+		priority:     expression.Priority,
+		tasks:        tasks,
+		costs:        costs,
+		store:        store,
+		records:      records,
+		ops:          ops,
+	}
+	res, err := e.run(ctx, rpn)
+	e.cleanup(err)
+	if errors.Is(err, context.Canceled) {
+		setStatus(store, expressionID, "cancelled")
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		loggers.GetLogger("orchestrator").Warn("expression timed out", "id", expressionID, "timeout", timeout)
+		setStatus(store, expressionID, "timed out")
+		return
+	}
+	var poison *global.PoisonTaskError
+	if errors.As(err, &poison) {
+		deadLetter(store, poison)
+	}
+	status := "completed"
+	if err != nil {
+		status = "calculation error: " + err.Error()
+	}
+	if !setStatus(store, expressionID, status) {
+		return
 	}
 	err = store.UpdateExpressionResult(expressionID, res)
 	if err != nil {
 		panic(err)
 	}
 }
+
+// deadLetter сохраняет задачу, исчерпавшую попытки, чтобы её можно было изучить и запустить заново.
+func deadLetter(store db, poison *global.PoisonTaskError) {
+	letter := global.DeadLetter{
+		TaskID:       poison.Task.ID,
+		ExpressionID: poison.Task.ExpressionID,
+		UserID:       poison.Task.UserID,
+		Operation:    poison.Task.Operation,
+		Arg1:         poison.Task.Arg1,
+		Arg2:         poison.Task.Arg2,
+		Attempts:     len(poison.History),
+		History:      poison.History,
+		Error:        poison.Error(),
+	}
+	logger := loggers.GetLogger("orchestrator")
+	logger.Warn("task moved to dead-letter", "id", letter.TaskID, "expression", letter.ExpressionID, "attempts", letter.Attempts)
+	if err := store.SaveDeadLetter(letter); err != nil {
+		logger.Error("failed to save dead letter", "id", letter.TaskID, "err", err)
+	}
+}
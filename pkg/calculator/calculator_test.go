@@ -1,13 +1,19 @@
 package calculator
 
 import (
+	"calculator/internal/cost"
+	"calculator/internal/global"
+	"calculator/internal/queue"
 	"calculator/pkg/loggers"
+	"context"
 	"errors"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
@@ -54,6 +60,22 @@ func TestTokenize(t *testing.T) {
 	}
 }
 
+func TestCountOperations(t *testing.T) {
+	tests := map[string]int{"42": 0, "1+2": 1, "(1+2)*3-4/5": 4}
+	for expr, want := range tests {
+		got, err := CountOperations(expr)
+		if err != nil {
+			t.Fatalf("CountOperations(%q) error: %v", expr, err)
+		}
+		if got != want {
+			t.Errorf("CountOperations(%q) = %d, want %d", expr, got, want)
+		}
+	}
+	if _, err := CountOperations("1+a"); err == nil {
+		t.Error("CountOperations accepted an invalid character")
+	}
+}
+
 func TestPrecedence(t *testing.T) {
 	tests := map[string]int{"+": 1, "-": 1, "*": 2, "/": 2, "^": 0}
 	for op, want := range tests {
@@ -94,6 +116,11 @@ func TestEvalRPN_Errors(t *testing.T) {
 	}{
 		{"empty", []token{}, "invalid expression"},
 		{"parse error", []token{{typ: tokenNumber, val: "x"}}, "invalid syntax"},
+		{
+			"unknown operator",
+			[]token{{typ: tokenNumber, val: "1"}, {typ: tokenNumber, val: "2"}, {typ: tokenOperator, val: "^"}},
+			"unknown operator: ^",
+		},
 		{
 			"div zero",
 			[]token{{typ: tokenNumber, val: "1"}, {typ: tokenNumber, val: "0"}, {typ: tokenOperator, val: "/"}},
@@ -101,7 +128,8 @@ func TestEvalRPN_Errors(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		_, err := evalRPN(tt.tokens)
+		e := &evaluation{tasks: queue.New(queue.Defaults), costs: cost.NewModel(cost.Defaults)}
+		_, err := e.run(context.Background(), tt.tokens)
 		if err == nil || !strings.Contains(err.Error(), tt.errSub) {
 			t.Errorf("%s: error = %v, want contain %q", tt.name, err, tt.errSub)
 		}
@@ -171,3 +199,270 @@ func TestEvalRPNSync(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildTrace(t *testing.T) {
+	tokens, _ := tokenize("2+3*4")
+	rpn, _ := shuntingYard(tokens)
+	trace, ops := buildTrace(rpn)
+	if len(ops) != 2 || ops[0].Operation != "*" || ops[1].Operation != "+" {
+		t.Fatalf("ops = %+v", ops)
+	}
+	data, err := trace.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON error: %v", err)
+	}
+	if !strings.HasPrefix(string(data), `{"operation":"+"`) {
+		t.Errorf("root = %s, want + operation", data)
+	}
+	if ops[1].Children[1] != ops[0] {
+		t.Error("right child of + must be the * node")
+	}
+}
+
+func TestEvaluationUsesUserCosts(t *testing.T) {
+	costs := cost.NewModel(cost.Defaults)
+	costs.Set(5, cost.Costs{Addition: 1, Subtraction: 2, Multiplication: 3, Division: 4})
+	tasks := queue.New(queue.Defaults)
+	e := &evaluation{userID: 5, tasks: tasks, costs: costs}
+	go e.run(context.Background(), []token{{tokenNumber, "6"}, {tokenNumber, "2"}, {tokenOperator, "/"}})
+	task, err := tasks.Next(context.Background(), "agent", time.Second)
+	if err != nil {
+		t.Fatalf("Next error: %v", err)
+	}
+	if task.OperationTime != 4 {
+		t.Errorf("OperationTime = %d, want the user's division cost 4", task.OperationTime)
+	}
+	tasks.Complete(task.ID, "agent", 3)
+}
+
+func TestEvaluationCancelWithdrawsTasks(t *testing.T) {
+	cancellations, unsubscribe := global.Cancellations.Subscribe()
+	defer unsubscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	tasks := queue.New(queue.Defaults)
+	e := &evaluation{expressionID: "expr-cancel", tasks: tasks, costs: cost.NewModel(cost.Defaults)}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := e.run(ctx, []token{{tokenNumber, "1"}, {tokenNumber, "2"}, {tokenOperator, "+"}})
+		e.cleanup(err)
+		errCh <- err
+	}()
+	for i := 0; i < 100 && tasks.Len() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if tasks.Len() != 1 {
+		t.Fatal("task was not queued")
+	}
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("run error = %v, want context.Canceled", err)
+	}
+	if n := tasks.Len(); n != 0 {
+		t.Errorf("%d tasks left in queue", n)
+	}
+	select {
+	case c := <-cancellations:
+		if c.ExpressionID != "expr-cancel" || len(c.TaskIDs) != 1 {
+			t.Errorf("cancellation = %+v", c)
+		}
+	default:
+		t.Error("agents were not notified")
+	}
+}
+
+type memStore struct {
+	mu      sync.Mutex
+	expr    global.ExpressionDTO
+	records map[int]global.TaskRecord
+	dead    []global.DeadLetter
+}
+
+func (m *memStore) UpdateActiveExpressionStatus(_ string, status string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.expr.Status != "pending" && m.expr.Status != "processing" {
+		return false, nil
+	}
+	m.expr.Status = status
+	return true, nil
+}
+
+func (m *memStore) GetExpressionByID(_ string) (*global.ExpressionDTO, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dto := m.expr
+	return &dto, nil
+}
+
+func (m *memStore) UpdateExpressionResult(_ string, result float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expr.Result = result
+	return nil
+}
+
+func (m *memStore) GetTaskRecords(_ string) ([]global.TaskRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var records []global.TaskRecord
+	for _, record := range m.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (m *memStore) SaveTaskRecord(record global.TaskRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.records == nil {
+		m.records = make(map[int]global.TaskRecord)
+	}
+	m.records[record.Index] = record
+	return nil
+}
+
+func (m *memStore) SaveDeadLetter(letter global.DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dead = append(m.dead, letter)
+	return nil
+}
+
+func (m *memStore) record(index int) global.TaskRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.records[index]
+}
+
+func TestCalcResumesSolvedTasks(t *testing.T) {
+	store := &memStore{
+		expr: global.ExpressionDTO{ID: "expr-resume", Data: "1+2*3", Status: "processing"},
+		records: map[int]global.TaskRecord{
+			0: {ExpressionID: "expr-resume", Index: 0, ID: "mul", Operation: "*", Arg1: 2, Arg2: 3, Solved: true, Result: 6},
+			1: {ExpressionID: "expr-resume", Index: 1, ID: "add", Operation: "+", Arg1: 1, Arg2: 6},
+		},
+	}
+	tasks := queue.New(queue.Defaults)
+	done := make(chan struct{})
+	go func() {
+		Calc(store, tasks, cost.NewModel(cost.Defaults), "expr-resume")
+		close(done)
+	}()
+	task, err := tasks.Next(context.Background(), "agent", time.Second)
+	if err != nil {
+		t.Fatalf("Next error: %v", err)
+	}
+	if task.ID != "add" || task.Arg1 != 1 || task.Arg2 != 6 {
+		t.Fatalf("dispatched %+v, want the unfinished addition with its saved ID", task)
+	}
+	tasks.Complete(task.ID, "agent", 7)
+	<-done
+	if store.expr.Status != "completed" || store.expr.Result != 7 {
+		t.Errorf("expression = %+v, want completed with 7", store.expr)
+	}
+	if n := tasks.Len(); n != 0 {
+		t.Errorf("%d extra tasks were dispatched", n)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !store.record(1).Solved && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if record := store.record(1); !record.Solved || record.Result != 7 {
+		t.Errorf("record = %+v, want the addition saved as solved", record)
+	}
+}
+
+func TestCalcKeepsCancelledStatus(t *testing.T) {
+	store := &memStore{expr: global.ExpressionDTO{ID: "expr-late-cancel", Data: "2*3", Status: "pending"}}
+	tasks := queue.New(queue.Defaults)
+	done := make(chan struct{})
+	go func() {
+		Calc(store, tasks, cost.NewModel(cost.Defaults), "expr-late-cancel")
+		close(done)
+	}()
+	task, err := tasks.Next(context.Background(), "agent", time.Second)
+	if err != nil {
+		t.Fatalf("Next error: %v", err)
+	}
+	// Отмена записана, но сигнал до вычисления ещё не дошёл.
+	store.UpdateActiveExpressionStatus("expr-late-cancel", "cancelled")
+	tasks.Complete(task.ID, "agent", 6)
+	<-done
+	if store.expr.Status != "cancelled" || store.expr.Result != 0 {
+		t.Errorf("expression = %+v, want the cancellation kept", store.expr)
+	}
+
+	store.expr.Status = "cancelled"
+	Calc(store, tasks, cost.NewModel(cost.Defaults), "expr-late-cancel")
+	if store.expr.Status != "cancelled" || tasks.Len() != 0 {
+		t.Errorf("cancelled expression was started: status %q, %d tasks queued", store.expr.Status, tasks.Len())
+	}
+}
+
+func TestCalcFailsOnAgentError(t *testing.T) {
+	store := &memStore{expr: global.ExpressionDTO{ID: "expr-fail", Data: "2*3", Status: "pending"}}
+	tasks := queue.New(queue.Defaults)
+	done := make(chan struct{})
+	go func() {
+		Calc(store, tasks, cost.NewModel(cost.Defaults), "expr-fail")
+		close(done)
+	}()
+	task, err := tasks.Next(context.Background(), "agent", time.Second)
+	if err != nil {
+		t.Fatalf("Next error: %v", err)
+	}
+	tasks.Fail(task.ID, "agent", errors.New("2 * 3 overflows float64"))
+	<-done
+	if want := "calculation error: 2 * 3 overflows float64"; store.expr.Status != want {
+		t.Errorf("status = %q, want %q", store.expr.Status, want)
+	}
+}
+
+func TestCalcDeadLettersPoisonTask(t *testing.T) {
+	store := &memStore{expr: global.ExpressionDTO{ID: "expr-poison", Data: "2*3", Status: "pending"}}
+	tasks := queue.New(queue.Options{Aging: queue.Defaults.Aging, MaxAttempts: 1})
+	done := make(chan struct{})
+	go func() {
+		Calc(store, tasks, cost.NewModel(cost.Defaults), "expr-poison")
+		close(done)
+	}()
+	task, err := tasks.Next(context.Background(), "agent", time.Second)
+	if err != nil {
+		t.Fatalf("Next error: %v", err)
+	}
+	tasks.RequeueAgent("agent")
+	<-done
+	if !strings.HasPrefix(store.expr.Status, "calculation error: ") {
+		t.Errorf("status = %q, want a calculation error", store.expr.Status)
+	}
+	if len(store.dead) != 1 {
+		t.Fatalf("dead letters = %+v, want one", store.dead)
+	}
+	letter := store.dead[0]
+	if letter.TaskID != task.ID || letter.ExpressionID != "expr-poison" || letter.Attempts != 1 || letter.History[0].Reason != "agent removed" {
+		t.Errorf("dead letter = %+v", letter)
+	}
+}
+
+func TestCalcTimesOut(t *testing.T) {
+	store := &memStore{expr: global.ExpressionDTO{ID: "expr-timeout", Data: "1+2", Status: "pending", Timeout: 20 * time.Millisecond}}
+	tasks := queue.New(queue.Defaults)
+	Calc(store, tasks, cost.NewModel(cost.Defaults), "expr-timeout")
+	if store.expr.Status != "timed out" {
+		t.Errorf("status = %q, want %q", store.expr.Status, "timed out")
+	}
+	if n := tasks.Len(); n != 0 {
+		t.Errorf("%d tasks were not withdrawn", n)
+	}
+}
+
+func TestCalcUsesDefaultTimeout(t *testing.T) {
+	previous := DefaultTimeout()
+	defer SetDefaultTimeout(previous)
+	SetDefaultTimeout(20 * time.Millisecond)
+	store := &memStore{expr: global.ExpressionDTO{ID: "expr-default-timeout", Data: "1+2", Status: "pending"}}
+	Calc(store, queue.New(queue.Defaults), cost.NewModel(cost.Defaults), "expr-default-timeout")
+	if store.expr.Status != "timed out" {
+		t.Errorf("status = %q, want %q", store.expr.Status, "timed out")
+	}
+}
@@ -0,0 +1,207 @@
+package calculator
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatLaTeX  = "latex"
+	FormatMathML = "mathml"
+)
+
+type node struct {
+	tok         token
+	left, right *node
+}
+
+func buildTree(rpn []token) (*node, error) {
+	var stack []*node
+	for _, tok := range rpn {
+		switch tok.typ {
+		case tokenNumber:
+			if _, err := strconv.ParseFloat(tok.val, 64); err != nil {
+				return nil, err
+			}
+			stack = append(stack, &node{tok: tok})
+		case tokenOperator:
+			if len(stack) < 2 {
+				return nil, errors.New("invalid expression")
+			}
+			n := &node{tok: tok, left: stack[len(stack)-2], right: stack[len(stack)-1]}
+			stack = append(stack[:len(stack)-2], n)
+		}
+	}
+	if len(stack) != 1 {
+		return nil, errors.New("invalid expression")
+	}
+	return stack[0], nil
+}
+
+func parseTree(expr string) (*node, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	rpn, err := shuntingYard(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return buildTree(rpn)
+}
+
+// needsParens сообщает, нужно ли взять операнд в скобки, чтобы сохранить
+// порядок вычислений исходного выражения. Дробь сама группирует операнды.
+func needsParens(parent *node, child *node, right bool) bool {
+	if child.tok.typ != tokenOperator || parent.tok.val == "/" || child.tok.val == "/" {
+		return false
+	}
+	p, c := precedence(parent.tok.val), precedence(child.tok.val)
+	if c < p {
+		return true
+	}
+	return right && c == p && parent.tok.val == "-"
+}
+
+func Render(expr, format string) (string, error) {
+	return render(expr, format, nil)
+}
+
+func RenderWithResult(expr string, result float64, format string) (string, error) {
+	return render(expr, format, &result)
+}
+
+func render(expr, format string, result *float64) (string, error) {
+	root, err := parseTree(expr)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	switch format {
+	case FormatLaTeX:
+		latexNode(&sb, root)
+		if result != nil {
+			sb.WriteString(" = ")
+			latexNumber(&sb, strconv.FormatFloat(*result, 'g', -1, 64))
+		}
+	case FormatMathML:
+		sb.WriteString(`<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow>`)
+		mathMLNode(&sb, root)
+		if result != nil {
+			sb.WriteString("<mo>=</mo>")
+			mathMLNumber(&sb, strconv.FormatFloat(*result, 'g', -1, 64))
+		}
+		sb.WriteString("</mrow></math>")
+	default:
+		return "", errors.New("unknown format: " + format)
+	}
+	return sb.String(), nil
+}
+
+// splitExponent разбивает число вида 1.5e+21 на мантиссу и порядок.
+func splitExponent(num string) (string, string, bool) {
+	mantissa, exp, ok := strings.Cut(strings.ToLower(num), "e")
+	if !ok {
+		return num, "", false
+	}
+	exp = strings.TrimPrefix(exp, "+")
+	if strings.HasPrefix(exp, "-") {
+		exp = "-" + strings.TrimLeft(exp[1:], "0")
+	} else {
+		exp = strings.TrimLeft(exp, "0")
+	}
+	return mantissa, exp, true
+}
+
+func latexNumber(sb *strings.Builder, num string) {
+	mantissa, exp, ok := splitExponent(num)
+	if !ok {
+		sb.WriteString(num)
+		return
+	}
+	if mantissa != "1" {
+		sb.WriteString(mantissa + ` \times `)
+	}
+	sb.WriteString("10^{" + exp + "}")
+}
+
+func latexOperand(sb *strings.Builder, parent, child *node, right bool) {
+	if needsParens(parent, child, right) {
+		sb.WriteString(`\left(`)
+		latexNode(sb, child)
+		sb.WriteString(`\right)`)
+		return
+	}
+	latexNode(sb, child)
+}
+
+func latexNode(sb *strings.Builder, n *node) {
+	if n.tok.typ == tokenNumber {
+		latexNumber(sb, n.tok.val)
+		return
+	}
+	if n.tok.val == "/" {
+		sb.WriteString(`\frac{`)
+		latexNode(sb, n.left)
+		sb.WriteString("}{")
+		latexNode(sb, n.right)
+		sb.WriteString("}")
+		return
+	}
+	latexOperand(sb, n, n.left, false)
+	switch n.tok.val {
+	case "*":
+		sb.WriteString(` \cdot `)
+	default:
+		sb.WriteString(" " + n.tok.val + " ")
+	}
+	latexOperand(sb, n, n.right, true)
+}
+
+func mathMLNumber(sb *strings.Builder, num string) {
+	mantissa, exp, ok := splitExponent(num)
+	if !ok {
+		sb.WriteString("<mn>" + num + "</mn>")
+		return
+	}
+	if mantissa != "1" {
+		sb.WriteString("<mn>" + mantissa + "</mn><mo>&#xD7;</mo>")
+	}
+	sb.WriteString("<msup><mn>10</mn><mn>" + exp + "</mn></msup>")
+}
+
+func mathMLOperand(sb *strings.Builder, parent, child *node, right bool) {
+	if needsParens(parent, child, right) {
+		sb.WriteString("<mrow><mo>(</mo>")
+		mathMLNode(sb, child)
+		sb.WriteString("<mo>)</mo></mrow>")
+		return
+	}
+	mathMLNode(sb, child)
+}
+
+func mathMLNode(sb *strings.Builder, n *node) {
+	if n.tok.typ == tokenNumber {
+		mathMLNumber(sb, n.tok.val)
+		return
+	}
+	if n.tok.val == "/" {
+		sb.WriteString("<mfrac><mrow>")
+		mathMLNode(sb, n.left)
+		sb.WriteString("</mrow><mrow>")
+		mathMLNode(sb, n.right)
+		sb.WriteString("</mrow></mfrac>")
+		return
+	}
+	mathMLOperand(sb, n, n.left, false)
+	switch n.tok.val {
+	case "*":
+		sb.WriteString("<mo>&#x22C5;</mo>")
+	case "-":
+		sb.WriteString("<mo>&#x2212;</mo>")
+	default:
+		sb.WriteString("<mo>" + n.tok.val + "</mo>")
+	}
+	mathMLOperand(sb, n, n.right, true)
+}
@@ -0,0 +1,69 @@
+package calculator
+
+import "testing"
+
+func TestRenderLaTeX(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"2+2*2", `2 + 2 \cdot 2`},
+		{"(2+2)*2", `\left(2 + 2\right) \cdot 2`},
+		{"(3+5)/2", `\frac{3 + 5}{2}`},
+		{"1-(2-3)", `1 - \left(2 - 3\right)`},
+		{"1-2-3", `1 - 2 - 3`},
+		{"2*(3/4)", `2 \cdot \frac{3}{4}`},
+	}
+	for _, tt := range tests {
+		got, err := Render(tt.expr, FormatLaTeX)
+		if err != nil {
+			t.Errorf("Render(%q) error: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestRenderMathML(t *testing.T) {
+	got, err := Render("(1-2)/3", FormatMathML)
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	want := `<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow>` +
+		`<mfrac><mrow><mn>1</mn><mo>&#x2212;</mo><mn>2</mn></mrow><mrow><mn>3</mn></mrow></mfrac>` +
+		`</mrow></math>`
+	if got != want {
+		t.Errorf("Render = %q, want %q", got, want)
+	}
+}
+
+func TestRenderWithResult(t *testing.T) {
+	got, err := RenderWithResult("1000000*1000000000000000", 1e21, FormatLaTeX)
+	if err != nil {
+		t.Fatalf("RenderWithResult error: %v", err)
+	}
+	if want := `1000000 \cdot 1000000000000000 = 10^{21}`; got != want {
+		t.Errorf("RenderWithResult = %q, want %q", got, want)
+	}
+	got, err = RenderWithResult("3/2", 1.5e-7, FormatMathML)
+	if err != nil {
+		t.Fatalf("RenderWithResult error: %v", err)
+	}
+	want := `<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow>` +
+		`<mfrac><mrow><mn>3</mn></mrow><mrow><mn>2</mn></mrow></mfrac>` +
+		`<mo>=</mo><mn>1.5</mn><mo>&#xD7;</mo><msup><mn>10</mn><mn>-7</mn></msup></mrow></math>`
+	if got != want {
+		t.Errorf("RenderWithResult = %q, want %q", got, want)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	if _, err := Render("2+*2", FormatLaTeX); err == nil {
+		t.Error("expected error for invalid expression")
+	}
+	if _, err := Render("2+2", "svg"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -0,0 +1,146 @@
+package configload
+
+import (
+	"calculator/pkg/loggers"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Setting — настройка конфигурации C, которую можно задать переменной
+// окружения Env и, если Flag не пуст, флагом командной строки.
+type Setting[C any] struct {
+	Env, Flag, Usage string
+	Set              func(c *C, raw string) error
+}
+
+func Text[C any](field func(c *C) *string) func(*C, string) error {
+	return func(c *C, raw string) error {
+		*field(c) = raw
+		return nil
+	}
+}
+
+func Integer[C any](field func(c *C) *int) func(*C, string) error {
+	return func(c *C, raw string) error {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func Number[C any](field func(c *C) *float64) func(*C, string) error {
+	return func(c *C, raw string) error {
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+// List разбирает значения через запятую; пустые и повторные пропускаются.
+func List[C any](field func(c *C) *[]string) func(*C, string) error {
+	return func(c *C, raw string) error {
+		var values []string
+		for _, v := range strings.Split(raw, ",") {
+			v = strings.TrimSpace(v)
+			if v != "" && !slices.Contains(values, v) {
+				values = append(values, v)
+			}
+		}
+		*field(c) = values
+		return nil
+	}
+}
+
+// ReadFile накладывает на c настройки из JSON-файла; не указанные в файле
+// поля сохраняют прежние значения, неизвестные поля — ошибка.
+func ReadFile[C any](path string, c *C) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Load собирает конфигурацию программы name по возрастанию приоритета:
+// defaults, JSON-файл (-config или переменная fileEnv), переменные окружения,
+// флаги из args — и проверяет её. На -help возвращает flag.ErrHelp.
+func Load[C interface{ Validate() error }](name, fileEnv string, defaults C, settings []Setting[C], args []string, output io.Writer) (C, error) {
+	var zero C
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	path := fs.String("config", os.Getenv(fileEnv), "JSON configuration file (env "+fileEnv+")")
+	type value struct {
+		setting Setting[C]
+		raw     string
+	}
+	// Флаги применяются после файла и окружения, поэтому при разборе только запоминаются.
+	var flags []value
+	for _, s := range settings {
+		if s.Flag == "" {
+			continue
+		}
+		fs.Func(s.Flag, s.Usage+" (env "+s.Env+")", func(raw string) error {
+			flags = append(flags, value{s, raw})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return zero, err
+	}
+	if fs.NArg() > 0 {
+		return zero, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	c := defaults
+	if *path != "" {
+		if err := ReadFile(*path, &c); err != nil {
+			return zero, err
+		}
+	}
+	for _, s := range settings {
+		if raw, ok := os.LookupEnv(s.Env); ok && raw != "" {
+			if err := s.Set(&c, raw); err != nil {
+				return zero, fmt.Errorf("%s: %w", s.Env, err)
+			}
+		}
+	}
+	for _, v := range flags {
+		if err := v.setting.Set(&c, v.raw); err != nil {
+			return zero, fmt.Errorf("-%s: %w", v.setting.Flag, err)
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return zero, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return c, nil
+}
+
+// Reloaded записывает в logger перезагрузку конфигурации с attrs и настройки
+// из restart, которые вступят в силу после перезапуска, и затем включает
+// level. Записи делаются до смены уровня, чтобы их не скрыл новый уровень.
+func Reloaded(logger *slog.Logger, level slog.Level, restart []string, attrs ...any) {
+	logger.Info("configuration reloaded", append([]any{"log_level", level}, attrs...)...)
+	if len(restart) > 0 {
+		logger.Warn("changed settings take effect only after a restart", "settings", restart)
+	}
+	loggers.SetLevel(level)
+}
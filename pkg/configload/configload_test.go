@@ -0,0 +1,79 @@
+package configload
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testConfig struct {
+	Name  string   `json:"name"`
+	Size  int      `json:"size"`
+	Ratio float64  `json:"ratio"`
+	Tags  []string `json:"tags"`
+}
+
+func (c testConfig) Validate() error {
+	if c.Size < 1 {
+		return errors.New("size must be positive")
+	}
+	return nil
+}
+
+var testSettings = []Setting[testConfig]{
+	{Env: "TEST_NAME", Flag: "name", Usage: "name", Set: Text(func(c *testConfig) *string { return &c.Name })},
+	{Env: "TEST_SIZE", Flag: "size", Usage: "size", Set: Integer(func(c *testConfig) *int { return &c.Size })},
+	{Env: "TEST_RATIO", Set: Number(func(c *testConfig) *float64 { return &c.Ratio })},
+	{Env: "TEST_TAGS", Flag: "tags", Usage: "tags", Set: List(func(c *testConfig) *[]string { return &c.Tags })},
+}
+
+func load(args ...string) (testConfig, error) {
+	return Load("test", "TEST_CONFIG", testConfig{Name: "default", Size: 1}, testSettings, args, io.Discard)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	if err := os.WriteFile(path, []byte(`{"name":"file","size":2,"ratio":0.5}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_CONFIG", path)
+	t.Setenv("TEST_SIZE", "3")
+	t.Setenv("TEST_TAGS", " a, b,a,")
+	c, err := load("-size", "4")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	want := testConfig{Name: "file", Size: 4, Ratio: 0.5, Tags: []string{"a", "b"}}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Load = %+v, want %+v", c, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Setenv("TEST_CONFIG", "")
+	cases := []struct {
+		name string
+		env  string
+		args []string
+		want string
+	}{
+		{"env not a number", "TEST_RATIO", nil, "TEST_RATIO"},
+		{"flag not an integer", "", []string{"-size", "big"}, "-size"},
+		{"validation", "", []string{"-size", "0"}, "invalid configuration"},
+		{"extra argument", "", []string{"-name", "x", "extra"}, "unexpected arguments: extra"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.env != "" {
+				t.Setenv(tc.env, "lots")
+			}
+			if _, err := load(tc.args...); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error = %v, want it to mention %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -1,37 +1,51 @@
 package loggers
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
+	"strings"
 	"sync"
 )
 
 var (
 	loggers  = make(map[string]*slog.Logger)
 	logFiles = make(map[string]*os.File)
+	level    = new(slog.LevelVar)
 	mu       sync.Mutex
 )
 
+// ParseLevel разбирает уровень логирования: DEBUG, INFO, WARN или ERROR
+// (регистр не важен). Пустая строка — INFO.
+func ParseLevel(raw string) (slog.Level, error) {
+	switch strings.ToUpper(raw) {
+	case "DEBUG":
+		return slog.LevelDebug, nil
+	case "", "INFO":
+		return slog.LevelInfo, nil
+	case "WARN":
+		return slog.LevelWarn, nil
+	case "ERROR":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", raw)
+}
+
+// SetLevel меняет уровень всех логгеров, в том числе уже созданных.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+func Level() slog.Level {
+	return level.Level()
+}
+
 func InitLogger(name, file string) {
 	mu.Lock()
 	defer mu.Unlock()
 	if _, exists := loggers[name]; exists {
 		return
 	}
-	logLevel := os.Getenv("LOG_LEVEL")
-	var level slog.Level
-	switch logLevel {
-	case "DEBUG":
-		level = slog.LevelDebug
-	case "INFO":
-		level = slog.LevelInfo
-	case "WARN":
-		level = slog.LevelWarn
-	case "ERROR":
-		level = slog.LevelError
-	default:
-		level = slog.LevelInfo
-	}
 	logFile, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		panic(err)
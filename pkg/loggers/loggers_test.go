@@ -2,6 +2,8 @@ package loggers_test
 
 import (
 	"calculator/pkg/loggers"
+	"context"
+	"log/slog"
 	"os"
 	"testing"
 )
@@ -17,3 +19,28 @@ func TestLoggerLifecycle(t *testing.T) {
 	}()
 	_ = loggers.GetLogger("test")
 }
+
+func TestParseLevel(t *testing.T) {
+	for raw, want := range map[string]slog.Level{"": slog.LevelInfo, "debug": slog.LevelDebug, "WARN": slog.LevelWarn, "Error": slog.LevelError} {
+		if got, err := loggers.ParseLevel(raw); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", raw, got, err, want)
+		}
+	}
+	if _, err := loggers.ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel accepted an unknown level")
+	}
+}
+
+func TestSetLevelAffectsExistingLoggers(t *testing.T) {
+	loggers.InitLogger("level", os.DevNull)
+	defer loggers.CloseAllLoggers()
+	defer loggers.SetLevel(slog.LevelInfo)
+	logger := loggers.GetLogger("level")
+	if logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatal("debug enabled at the default level")
+	}
+	loggers.SetLevel(slog.LevelDebug)
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("SetLevel did not reach an existing logger")
+	}
+}
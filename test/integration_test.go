@@ -18,6 +18,7 @@ import (
 
 	"calculator/internal/agent"
 	"calculator/internal/application"
+	"calculator/internal/config"
 	"calculator/internal/database"
 	"calculator/pkg/loggers"
 )
@@ -117,6 +118,7 @@ func startSystem(t *testing.T, port int, withAgent bool) context.CancelFunc {
 			}
 		}
 		_ = os.Remove("sqlite.db")
+		_ = os.Remove("agent_id")
 	})
 
 	loggers.InitLogger("server", os.DevNull)
@@ -125,19 +127,27 @@ func startSystem(t *testing.T, port int, withAgent bool) context.CancelFunc {
 	loggers.InitLogger("agent", os.DevNull)
 	t.Cleanup(func() { loggers.CloseAllLoggers() })
 
-	database.Init()
+	cfg, err := config.Load(nil, io.Discard)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	database.Init(cfg.Database)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	app := application.New()
+	app := application.New(cfg)
 	go func() { _ = app.Run(ctx) }()
 
 	if withAgent {
-		go agent.Run()
+		agentCfg, err := agent.LoadConfig(nil, io.Discard)
+		if err != nil {
+			t.Fatalf("agent config: %v", err)
+		}
+		go agent.Run(agentCfg)
 	}
 
 	baseURL := fmt.Sprintf("http://localhost:%d/api/v1/register", port)
-	err := waitFor(5*time.Second, 100*time.Millisecond, func() (bool, error) {
+	err = waitFor(5*time.Second, 100*time.Millisecond, func() (bool, error) {
 		resp, err := http.Post(baseURL, "application/json", strings.NewReader("{}"))
 		if err != nil {
 			return false, nil